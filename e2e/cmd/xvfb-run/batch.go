@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"xvfb-run/pkg/xvfb"
+)
+
+// batchIncompatible are the flags that act on the one display or command
+// of a normal run, which --parallel doesn't have.
+var batchIncompatible = []string{
+	"n", "server-num", "reuse", "prefer-existing", "print-display", "dry-run", "shell",
+	"fail-fast", "max-runtime", "screenshot-on-failure", "record", "status-file",
+	"on-ready", "wm", "probe", "export-env", "keep-on-failure", "no-cleanup", "json",
+	"timings", "output-file", "quiet-child-on-success", "prefix", "before", "after",
+}
+
+// readCommandsFile reads commands for --batch-file from path, one to a
+// line, split like parseServerArgs does. Blank lines and lines starting
+// with "#" are skipped.
+func readCommandsFile(path string) ([][]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var commands [][]string
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		if command := parseServerArgs(line); len(command) > 0 {
+			commands = append(commands, command)
+		}
+	}
+	return commands, nil
+}
+
+// lockedWriter serializes writes to w from the commands of a batch and
+// their Runners.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// runnerSet is the Runners of a batch that have a command running, driven
+// together by the signal handler. The first signal also cancels ctx, which
+// the Runners are started with, so those still starting give up and no
+// more commands are handed out.
+type runnerSet struct {
+	mu      sync.Mutex
+	runners map[*xvfb.Runner]bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+func newRunnerSet() *runnerSet {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &runnerSet{runners: map[*xvfb.Runner]bool{}, ctx: ctx, cancel: cancel}
+}
+
+// add makes r one of the Runners signals go to, or reports false if the
+// wrapper has already caught a signal and r's command shouldn't run.
+func (s *runnerSet) add(r *xvfb.Runner) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if caughtSignal.Load() != 0 {
+		return false
+	}
+	s.runners[r] = true
+	return true
+}
+
+func (s *runnerSet) remove(r *xvfb.Runner) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.runners, r)
+}
+
+func (s *runnerSet) Signal(sig syscall.Signal) error {
+	s.cancel()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for r := range s.runners {
+		r.Signal(sig)
+	}
+	return nil
+}
+
+func (s *runnerSet) Stop() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for r := range s.runners {
+		r.Stop()
+	}
+	return nil
+}
+
+// runBatch runs commands parallel at a time, each on a display of its own
+// that a Runner from newRunner allocates and stops again once the command
+// is done. run runs a command on its Runner's display. Every line a command
+// prints is tagged with its number, e.g. "[2] ", so their output can be told
+// apart. It returns the status to exit with: 0 if every command succeeded,
+// otherwise that of the first one in commands that didn't. Once the wrapper
+// catches a signal the running commands get it and no more are started.
+// Each command's exit code goes through exitMap, from --map-exit.
+func runBatch(commands [][]string, parallel int, newRunner func() *xvfb.Runner, run func(*xvfb.Runner, []string) error, exitMap map[int]int, quiet bool) int {
+	codes := make([]int, len(commands))
+	running := newRunnerSet()
+	defer running.cancel()
+	stopSignals := setupSignalHandling(running, signalGracePeriod)
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(parallel, len(commands)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				codes[i] = runBatchCommand(i, len(commands), commands[i], newRunner(), run, exitMap, running)
+			}
+		}()
+	}
+handOut:
+	for i := range commands {
+		if caughtSignal.Load() != 0 {
+			break
+		}
+		select {
+		case jobs <- i:
+		case <-running.ctx.Done():
+			break handOut
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	stopSignals()
+
+	if sig := caughtSignal.Load(); sig != 0 {
+		return 128 + int(sig)
+	}
+	var failed []string
+	code := 0
+	for i, c := range codes {
+		if c != 0 {
+			failed = append(failed, strconv.Itoa(i+1))
+			if code == 0 {
+				code = c
+			}
+		}
+	}
+	if len(failed) > 0 && !quiet {
+		logError("command_failed", fmt.Sprintf("%d of %d commands failed: %s", len(failed), len(commands), strings.Join(failed, ", ")))
+	}
+	return code
+}
+
+// runBatchCommand starts runner, runs command i of n on its display and
+// stops it, returning the status the command, mapped with exitMap, or the
+// failed start comes to.
+func runBatchCommand(i, n int, command []string, runner *xvfb.Runner, run func(*xvfb.Runner, []string) error, exitMap map[int]int, running *runnerSet) int {
+	tag := fmt.Sprintf("[%d] ", i+1)
+	stdout, errout := newPrefixWriter(runner.Stdout, tag), newPrefixWriter(runner.Stderr, tag)
+	// Several commands can't share the wrapper's stdin
+	runner.Stdin, runner.Stdout, runner.Stderr = nil, stdout, errout
+
+	if caughtSignal.Load() != 0 {
+		return 0
+	}
+	if err := runner.StartContext(running.ctx); err != nil {
+		if caughtSignal.Load() != 0 {
+			return 0
+		}
+		logError("xvfb_start", fmt.Sprintf("Command %d: failed to start Xvfb: %v", i+1, err))
+		return exitCode(err)
+	}
+	if !running.add(runner) {
+		runner.Stop()
+		return 0
+	}
+	display := runner.ActiveDisplay()
+	logEvent("command_start", fmt.Sprintf("Running command %d of %d on %s: %s", i+1, n, display, strings.Join(command, " ")), "command", command, "index", i+1, "display", display)
+	err := run(runner, command)
+	stdout.Flush()
+	errout.Flush()
+	running.remove(runner)
+	if err := runner.Stop(); err != nil {
+		logWarning("cleanup", fmt.Sprintf("Command %d: couldn't stop Xvfb on %s: %v", i+1, display, err), "index", i+1, "display", display, "error", err.Error())
+	}
+	logEvent("command_exit", fmt.Sprintf("Command %d exited with code %d", i+1, exitCode(err)), "exit_code", exitCode(err), "index", i+1, "display", display)
+	return mapExitCode(exitMap, exitCode(err))
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReadCommandsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "commands")
+	content := "# the suite\nmytool --first\n\n  # skipped\nmytool 'two words'\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readCommandsFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"mytool", "--first"}, {"mytool", "two words"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestRunParallelGivesEachCommandItsOwnDisplay(t *testing.T) {
+	restoreLogging(t)
+	socketDir := useFakeDaemonXvfb(t)
+	marks := t.TempDir()
+	// Each waits for the other, so they only pass if they run at once
+	wait := func(mine, theirs string) []string {
+		script := `touch "$1/` + mine + `"; echo "$DISPLAY"; for i in $(seq 100); do [ -e "$1/` + theirs + `" ] && exit 0; sleep 0.05; done; exit 1`
+		return []string{"sh", "-c", script, "sh", marks}
+	}
+	args := []string{"--parallel", "2", "--socket-dir", socketDir, "--display-base", "30"}
+	args = append(append(args, wait("a", "b")...), "---")
+	args = append(append(args, wait("b", "a")...), "---", "sh", "-c", "exit 3")
+
+	var stdout, stderr strings.Builder
+	code := run(args, &stdout, &stderr)
+	if code != 3 {
+		t.Fatalf("expected the failing command's exit code 3, got %d: %s", code, stderr.String())
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	slices.Sort(lines)
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "[1] :3") || !strings.HasPrefix(lines[1], "[2] :3") {
+		t.Fatalf("expected a tagged display from each command, got %q", lines)
+	}
+	if strings.TrimPrefix(lines[0], "[1] ") == strings.TrimPrefix(lines[1], "[2] ") {
+		t.Errorf("expected different displays, got %q", lines)
+	}
+	if !strings.Contains(stderr.String(), "1 of 3 commands failed: 3") {
+		t.Errorf("expected a summary of the failures, got: %s", stderr.String())
+	}
+}
+
+func TestSignalDuringParallelStartupRunsNothing(t *testing.T) {
+	restoreLogging(t)
+	socketDir := useFakeDaemonXvfb(t)
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "Xvfb"), []byte(slowXvfb), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	caughtSignal.Store(0)
+	t.Cleanup(func() { caughtSignal.Store(0) })
+	marks := t.TempDir()
+	touch := func(name string) []string {
+		return []string{"touch", filepath.Join(marks, name)}
+	}
+	args := []string{"--parallel", "2", "--socket-dir", socketDir, "--display-base", "40"}
+	args = append(append(args, touch("a")...), "---")
+	args = append(append(args, touch("b")...), "---")
+	args = append(args, touch("c")...)
+
+	codes := make(chan int, 1)
+	go func() {
+		var stdout, stderr strings.Builder
+		codes <- run(args, &stdout, &stderr)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(filepath.Join(socketDir, "xvfb.pid")); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+
+	select {
+	case code := <-codes:
+		if code != 128+int(syscall.SIGTERM) {
+			t.Errorf("expected exit code %d, got %d", 128+int(syscall.SIGTERM), code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the batch kept waiting for its displays after SIGTERM")
+	}
+	if ran, _ := os.ReadDir(marks); len(ran) > 0 {
+		t.Errorf("expected no command to run after SIGTERM, got %d", len(ran))
+	}
+	locks, _ := filepath.Glob(filepath.Join(socketDir, ".X*-lock"))
+	if len(locks) > 0 {
+		t.Errorf("expected every Xvfb to be stopped, got lock files %q", locks)
+	}
+}
+
+func TestRunBatchFile(t *testing.T) {
+	restoreLogging(t)
+	socketDir := useFakeDaemonXvfb(t)
+	path := filepath.Join(t.TempDir(), "commands")
+	if err := os.WriteFile(path, []byte("echo one\necho two\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr strings.Builder
+	code := run([]string{"--parallel", "1", "--socket-dir", socketDir, "--batch-file", path}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if got := stdout.String(); got != "[1] one\n[2] two\n" {
+		t.Errorf("expected both commands' output in order, got %q", got)
+	}
+
+	// Without --parallel they run one after another on one display
+	stdout.Reset()
+	code = run([]string{"-a", "--socket-dir", socketDir, "--batch-file", path}, &stdout, &stderr)
+	if code != 0 || stdout.String() != "one\ntwo\n" {
+		t.Errorf("expected exit code 0 and both outputs, got %d and %q: %s", code, stdout.String(), stderr.String())
+	}
+}
+
+func TestRunParallelRejectsSingleDisplayFlags(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("XVFB_RUN_ARGS", "")
+
+	for _, args := range [][]string{
+		{"--parallel", "2", "-n", "5", "true"},
+		{"--parallel", "2", "--reuse", "true"},
+		{"--parallel", "2", "--json", "true"},
+	} {
+		var stdout, stderr strings.Builder
+		if code := run(args, &stdout, &stderr); code != 2 {
+			t.Errorf("%q: expected exit code 2, got %d", args, code)
+		}
+		if !strings.Contains(stderr.String(), "--parallel can't be used with") {
+			t.Errorf("%q: unexpected stderr: %s", args, stderr.String())
+		}
+	}
+	var stdout, stderr strings.Builder
+	if code := run([]string{"--parallel", "-1", "true"}, &stdout, &stderr); code != 2 {
+		t.Errorf("expected exit code 2 for a negative --parallel, got %d", code)
+	}
+}
+
+func TestRunParallelWarnsAboutGeometryOnce(t *testing.T) {
+	restoreLogging(t)
+	socketDir := useFakeDaemonXvfb(t)
+	t.Setenv("XVFB_SCREEN_GEOMETRY", "huge")
+
+	var stdout, stderr strings.Builder
+	code := run([]string{"--parallel", "3", "--socket-dir", socketDir, "--display-base", "40", "true", "---", "true", "---", "true"}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if n := strings.Count(stderr.String(), "Ignoring XVFB_SCREEN_GEOMETRY"); n != 1 {
+		t.Errorf("expected one warning, got %d: %s", n, stderr.String())
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"xvfb-run/pkg/xvfb"
+)
+
+// cleanCommand is "xvfb-run clean [--force] [--socket-dir DIR]": it reports
+// the lock files and sockets that X servers killed along with their job
+// left in the socket directory, and with --force removes them so their
+// displays can be used again. Files of running servers are never touched.
+func cleanCommand(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("xvfb-run clean", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	force := fs.Bool("force", false, "remove the stale files instead of only listing them")
+	socketDir := fs.String("socket-dir", "", "directory the X servers keep their lock files and .X11-unix in, instead of /tmp")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		logError("usage", "usage: xvfb-run clean [--force] [--socket-dir DIR]")
+		return 2
+	}
+	stale, err := xvfb.FindStaleDisplays(*socketDir)
+	if err != nil {
+		logError("cleanup", "Can't look for stale displays: "+err.Error(), "error", err.Error())
+		return 1
+	}
+	if len(stale) == 0 {
+		fmt.Fprintln(stdout, "No stale displays")
+		return 0
+	}
+	code := 0
+	for _, d := range stale {
+		why := "nothing listens on its socket"
+		if d.PID != 0 {
+			why = fmt.Sprintf("PID %d is gone", d.PID)
+		}
+		files := strings.Join(d.Files, " ")
+		if !*force {
+			fmt.Fprintf(stdout, "Would remove %s (%s): %s\n", d.Display, why, files)
+			continue
+		}
+		if err := xvfb.RemoveStaleDisplay(*socketDir, d); err != nil {
+			logError("cleanup", fmt.Sprintf("Can't remove %s: %v", d.Display, err), "display", d.Display, "error", err.Error())
+			code = 1
+			continue
+		}
+		fmt.Fprintf(stdout, "Removed %s (%s): %s\n", d.Display, why, files)
+	}
+	if !*force {
+		fmt.Fprintln(stdout, "Run with --force to remove them")
+	}
+	return code
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// socketDirWithLocks makes a socket directory with a lock and socket left
+// by a dead server on :7000 and the lock of this live process on :7001.
+func socketDirWithLocks(t *testing.T) (dir string, dead int) {
+	t.Helper()
+	dir = t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, ".X11-unix"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	dead = cmd.Process.Pid
+	files := map[string]string{
+		".X7000-lock":     fmt.Sprintf("%10d\n", dead),
+		".X11-unix/X7000": "",
+		".X7001-lock":     fmt.Sprintf("%10d\n", os.Getpid()),
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir, dead
+}
+
+func TestCleanDryRun(t *testing.T) {
+	dir, dead := socketDirWithLocks(t)
+	var stdout, stderr bytes.Buffer
+	if code := dispatch([]string{"clean", "--socket-dir", dir}, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit 0, got %d: %s", code, stderr.String())
+	}
+	want := fmt.Sprintf("Would remove :7000 (PID %d is gone)", dead)
+	if out := stdout.String(); !strings.Contains(out, want) || strings.Contains(out, ":7001") || !strings.Contains(out, "--force") {
+		t.Errorf("unexpected output: %q", out)
+	}
+	for _, name := range []string{".X7000-lock", ".X11-unix/X7000", ".X7001-lock"} {
+		if !fileExists(filepath.Join(dir, name)) {
+			t.Errorf("dry run removed %s", name)
+		}
+	}
+}
+
+func TestCleanForce(t *testing.T) {
+	dir, _ := socketDirWithLocks(t)
+	var stdout, stderr bytes.Buffer
+	if code := dispatch([]string{"clean", "--force", "--socket-dir", dir}, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit 0, got %d: %s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "Removed :7000") {
+		t.Errorf("unexpected output: %q", stdout.String())
+	}
+	for _, name := range []string{".X7000-lock", ".X11-unix/X7000"} {
+		if fileExists(filepath.Join(dir, name)) {
+			t.Errorf("expected %s to be removed", name)
+		}
+	}
+	if !fileExists(filepath.Join(dir, ".X7001-lock")) {
+		t.Error("removed the lock of a live server")
+	}
+
+	stdout.Reset()
+	if code := dispatch([]string{"clean", "--socket-dir", dir}, &stdout, &stderr); code != 0 || !strings.Contains(stdout.String(), "No stale displays") {
+		t.Errorf("expected nothing left to clean, got %d: %q", code, stdout.String())
+	}
+}
+
+func TestCleanUsage(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := dispatch([]string{"clean", "extra"}, &stdout, &stderr); code != 2 {
+		t.Errorf("expected exit 2, got %d", code)
+	}
+}
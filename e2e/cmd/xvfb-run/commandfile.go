@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// readCommandFile reads the command for --command-file from path, for
+// command lines too long to pass as arguments. The file is either a JSON
+// array of strings:
+//
+//	["mytool", "--name", "two words"]
+//
+// or one argument to a line, taken as is, so nothing needs quoting. The
+// newline ending the last line doesn't start another argument.
+func readCommandFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var command []string
+	if text := strings.TrimSpace(string(data)); strings.HasPrefix(text, "[") {
+		if err := json.Unmarshal([]byte(text), &command); err != nil {
+			return nil, fmt.Errorf("%s: not a JSON array of strings: %w", path, err)
+		}
+	} else if text != "" {
+		lines := strings.TrimSuffix(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+		command = strings.Split(lines, "\n")
+	}
+	if len(command) == 0 || command[0] == "" {
+		return nil, errors.New(path + " has no command")
+	}
+	return command, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadCommandFile(t *testing.T) {
+	tests := []struct {
+		name, content string
+		want          []string
+	}{
+		{"lines", "echo\nhello world\n'quoted'\n", []string{"echo", "hello world", "'quoted'"}},
+		{"no trailing newline", "echo\nhi", []string{"echo", "hi"}},
+		{"crlf", "echo\r\nhi\r\n", []string{"echo", "hi"}},
+		{"empty argument", "printf\n\n", []string{"printf", ""}},
+		{"json", ` ["echo", "two words", "line\nbreak"]` + "\n", []string{"echo", "two words", "line\nbreak"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "command")
+			os.WriteFile(path, []byte(tt.content), 0o644)
+			got, err := readCommandFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadCommandFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"empty":      "",
+		"blank":      "\n\n",
+		"empty json": "[]",
+		"bad json":   `["echo", 1]`,
+	} {
+		path := filepath.Join(dir, strings.ReplaceAll(name, " ", "-"))
+		os.WriteFile(path, []byte(content), 0o644)
+		if _, err := readCommandFile(path); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if _, err := readCommandFile(filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+func TestRunCommandFile(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+	path := filepath.Join(t.TempDir(), "command")
+	os.WriteFile(path, []byte(`["sh", "-c", "echo \"$1 on $DISPLAY\"", "sh", "two words"]`), 0o644)
+
+	var stdout, stderr strings.Builder
+	if code := run([]string{"--reuse", "--command-file", path}, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if got := stdout.String(); got != "two words on :42\n" {
+		t.Errorf("unexpected output %q", got)
+	}
+
+	stderr.Reset()
+	if code := run([]string{"--reuse", "--command-file", path, "echo", "hi"}, &stdout, &stderr); code != 2 {
+		t.Errorf("expected exit code 2 with a command too, got %d", code)
+	}
+	if !strings.Contains(stderr.String(), "--command-file can't be used with a command") {
+		t.Errorf("unexpected error %q", stderr.String())
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Options is what a --config file can set, in YAML. Each field stands for
+// the flag of the same name, and a flag given on the command line or in
+// XVFB_RUN_ARGS wins over the file. For example:
+//
+//	screens: [1920x1080x24]
+//	dpi: 96
+//	timeout: 10m
+//	env:
+//	  LANG: C.UTF-8
+type Options struct {
+	AutoDisplay bool              `yaml:"auto_display"`
+	ServerNum   *int              `yaml:"server_num"`
+	Screens     []string          `yaml:"screens"`
+	DPI         int               `yaml:"dpi"`
+	ServerArgs  string            `yaml:"server_args"`
+	ListenTCP   bool              `yaml:"listen_tcp"`
+	Timeout     time.Duration     `yaml:"timeout"`
+	WaitTimeout time.Duration     `yaml:"wait_timeout"`
+	ErrorFile   string            `yaml:"error_file"`
+	Workdir     string            `yaml:"workdir"`
+	Env         map[string]string `yaml:"env"`
+}
+
+// loadConfig reads a --config file. Keys Options doesn't know are an error,
+// so a typo doesn't silently leave a setting at its default.
+func loadConfig(path string) (Options, error) {
+	var opts Options
+	f, err := os.Open(path)
+	if err != nil {
+		return opts, err
+	}
+	defer f.Close()
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&opts); err != nil && !errors.Is(err, io.EOF) {
+		return Options{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return opts, nil
+}
+
+// applyConfig sets the flags in fs that opts has values for, unless one of
+// the flag's names was already given. The file's env is the exception:
+// --env values are applied on top of it.
+func applyConfig(fs *flag.FlagSet, opts Options) error {
+	var errs []error
+	set := func(names []string, values ...string) {
+		if len(values) == 0 || flagPassed(fs, names...) {
+			return
+		}
+		for _, v := range values {
+			if err := fs.Set(names[0], v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", names[0], err))
+			}
+		}
+	}
+	if opts.AutoDisplay {
+		set([]string{"a"}, "true")
+	}
+	if opts.ServerNum != nil {
+		set([]string{"n", "server-num"}, strconv.Itoa(*opts.ServerNum))
+	}
+	set([]string{"screen"}, opts.Screens...)
+	if opts.DPI != 0 {
+		set([]string{"dpi"}, strconv.Itoa(opts.DPI))
+	}
+	if opts.ServerArgs != "" {
+		set([]string{"s", "server-args"}, opts.ServerArgs)
+	}
+	if opts.ListenTCP {
+		set([]string{"listen-tcp"}, "true")
+	}
+	if opts.Timeout != 0 {
+		set([]string{"timeout"}, opts.Timeout.String())
+	}
+	if opts.WaitTimeout != 0 {
+		set([]string{"wait-timeout"}, opts.WaitTimeout.String())
+	}
+	if opts.ErrorFile != "" {
+		set([]string{"e", "error-file"}, opts.ErrorFile)
+	}
+	if opts.Workdir != "" {
+		set([]string{"workdir"}, opts.Workdir)
+	}
+	var env []string
+	for _, key := range sortedKeys(opts.Env) {
+		env = append(env, key+"="+opts.Env[key])
+	}
+	// --env is merged key by key rather than replaced, so one on the
+	// command line doesn't drop the rest of the file's env.
+	if f := fs.Lookup("env"); f != nil && len(env) > 0 {
+		if given, ok := f.Value.(*envFlag); ok {
+			*given = mergeEnv(env, *given)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
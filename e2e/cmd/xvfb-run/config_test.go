@@ -0,0 +1,134 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "xvfb-run.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeConfig(t, "server_num: 0\nscreens: [800x600x24, 1=640x480x8]\ndpi: 96\ntimeout: 90s\nenv:\n  LANG: C.UTF-8\n")
+	opts, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.ServerNum == nil || *opts.ServerNum != 0 {
+		t.Errorf("expected server_num 0, got %v", opts.ServerNum)
+	}
+	if !slices.Equal(opts.Screens, []string{"800x600x24", "1=640x480x8"}) || opts.DPI != 96 || opts.Timeout != 90*time.Second {
+		t.Errorf("unexpected options %+v", opts)
+	}
+	if opts.Env["LANG"] != "C.UTF-8" {
+		t.Errorf("unexpected env %v", opts.Env)
+	}
+}
+
+func TestLoadConfigRejectsUnknownFields(t *testing.T) {
+	_, err := loadConfig(writeConfig(t, "dpi: 96\ngeometry: 800x600x24\n"))
+	if err == nil || !strings.Contains(err.Error(), "geometry") {
+		t.Errorf("expected an error naming the unknown field, got %v", err)
+	}
+}
+
+func TestLoadConfigEmptyFile(t *testing.T) {
+	opts, err := loadConfig(writeConfig(t, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.ServerNum != nil || opts.DPI != 0 {
+		t.Errorf("expected no options, got %+v", opts)
+	}
+}
+
+func TestApplyConfigKeepsFlagsAlreadySet(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	dpi := fs.Int("dpi", 0, "")
+	serverNum := 99
+	fs.IntVar(&serverNum, "n", 99, "")
+	fs.IntVar(&serverNum, "server-num", 99, "")
+	var env envFlag
+	fs.Var(&env, "env", "")
+	if err := fs.Parse([]string{"--server-num", "5"}); err != nil {
+		t.Fatal(err)
+	}
+
+	seven := 7
+	if err := applyConfig(fs, Options{ServerNum: &seven, DPI: 96, Env: map[string]string{"B": "2", "A": "1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if serverNum != 5 {
+		t.Errorf("expected --server-num to win over the file, got %d", serverNum)
+	}
+	if *dpi != 96 {
+		t.Errorf("expected dpi from the file, got %d", *dpi)
+	}
+	if !slices.Equal(env, envFlag{"A=1", "B=2"}) {
+		t.Errorf("unexpected env %q", env)
+	}
+}
+
+func TestApplyConfigMergesEnv(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	var env envFlag
+	fs.Var(&env, "env", "")
+	if err := fs.Parse([]string{"--env", "FOO=1", "--env", "LANG=C"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := applyConfig(fs, Options{Env: map[string]string{"LANG": "C.UTF-8", "TZ": "UTC"}}); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(env, envFlag{"LANG=C", "TZ=UTC", "FOO=1"}) {
+		t.Errorf("expected --env on top of the file's env, got %q", env)
+	}
+}
+
+func TestRunConfigPrecedence(t *testing.T) {
+	restoreLogging(t)
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "mytool"), []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin)
+	t.Setenv("XVFB_RUN_ARGS", "--dpi 120")
+	t.Setenv("XVFB_SCREEN_GEOMETRY", "")
+	path := writeConfig(t, "server_num: 7\ndpi: 96\nscreens: [800x600x24]\n")
+
+	var stdout, stderr strings.Builder
+	code := run([]string{"--config", path, "--dry-run", "-n", "5", "mytool"}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	want := "DISPLAY=:5\n" +
+		"XVFB=Xvfb :5 -auth '$XAUTHORITY' -nolisten tcp -screen 0 800x600x24 -dpi 120\n" +
+		"COMMAND=mytool\n"
+	if got := stdout.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRunInvalidConfig(t *testing.T) {
+	restoreLogging(t)
+	path := writeConfig(t, "dpi: loud\n")
+
+	var stdout, stderr strings.Builder
+	if code := run([]string{"--config", path, "true"}, &stdout, &stderr); code != 2 {
+		t.Errorf("expected exit code 2, got %d", code)
+	}
+	if !strings.Contains(stderr.String(), "Invalid --config") {
+		t.Errorf("unexpected stderr %q", stderr.String())
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// --cpu-affinity pins the command to some CPUs. Like resource limits it is
+// set by the wrapper started with rlimitExecArg just before it execs the
+// command, which keeps the mask. Only Linux is supported.
+
+// numCPU is how many CPUs --cpu-affinity can choose from.
+var numCPU = runtime.NumCPU
+
+// parseCPUList parses a CPU list as taskset and /sys/devices/system/cpu
+// write it, e.g. "0,2-3", into the sorted CPU numbers it names. Every CPU
+// must be below numCPU().
+func parseCPUList(s string) ([]int, error) {
+	var cpus []int
+	for _, part := range strings.Split(s, ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		lo, err := strconv.Atoi(first)
+		hi := lo
+		if err == nil && isRange {
+			hi, err = strconv.Atoi(last)
+		}
+		if err != nil || lo < 0 || hi < lo {
+			return nil, fmt.Errorf("invalid CPU list %q, want CPU numbers and ranges like 0,2-3", s)
+		}
+		if hi >= numCPU() {
+			return nil, fmt.Errorf("CPU %d is out of range, this machine has CPUs 0 to %d", hi, numCPU()-1)
+		}
+		for cpu := lo; cpu <= hi; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	slices.Sort(cpus)
+	return slices.Compact(cpus), nil
+}
+
+// formatCPUList writes cpus back as a comma-separated list, as parseCPUList
+// reads it.
+func formatCPUList(cpus []int) string {
+	parts := make([]string, len(cpus))
+	for i, cpu := range cpus {
+		parts[i] = strconv.Itoa(cpu)
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// setCPUAffinity restricts the calling thread to cpus and locks the
+// goroutine to it, so the exec that follows, which keeps that thread's
+// mask, is made from the same thread.
+func setCPUAffinity(cpus []int) error {
+	runtime.LockOSThread()
+	var mask [1024 / 64]uint64
+	for _, cpu := range cpus {
+		if cpu < 0 || cpu >= len(mask)*64 {
+			return fmt.Errorf("--cpu-affinity: CPU %d is out of range", cpu)
+		}
+		mask[cpu/64] |= 1 << (cpu % 64)
+	}
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, 0, unsafe.Sizeof(mask), uintptr(unsafe.Pointer(&mask)))
+	if errno != 0 {
+		return fmt.Errorf("--cpu-affinity %s: %w", formatCPUList(cpus), errno)
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// setCPUAffinity fails: --cpu-affinity is only supported on Linux.
+func setCPUAffinity([]int) error {
+	return errors.New("--cpu-affinity is only supported on Linux")
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fakeCPUs makes parseCPUList see n CPUs.
+func fakeCPUs(t *testing.T, n int) {
+	t.Helper()
+	old := numCPU
+	numCPU = func() int { return n }
+	t.Cleanup(func() { numCPU = old })
+}
+
+func TestParseCPUList(t *testing.T) {
+	fakeCPUs(t, 4)
+	for in, want := range map[string][]int{
+		"0":       {0},
+		"0,2-3":   {0, 2, 3},
+		"3,1":     {1, 3},
+		"1-2,2-3": {1, 2, 3},
+		"2-2":     {2},
+	} {
+		if got, err := parseCPUList(in); err != nil || !slices.Equal(got, want) {
+			t.Errorf("parseCPUList(%q) = %v, %v, want %v", in, got, err, want)
+		}
+	}
+}
+
+func TestParseCPUListRejectsInvalid(t *testing.T) {
+	fakeCPUs(t, 4)
+	tooHigh := "4"
+	for _, in := range []string{"", "a", "-1", "1-", "-", "3-1", "0,,1", "1.5", tooHigh, "0-" + tooHigh} {
+		if _, err := parseCPUList(in); err == nil {
+			t.Errorf("parseCPUList(%q): expected an error", in)
+		}
+	}
+	if _, err := parseCPUList(tooHigh); err == nil || !strings.Contains(err.Error(), "out of range") {
+		t.Errorf("expected an out of range error, got %v", err)
+	}
+}
+
+func TestRlimitCommandWithCPUs(t *testing.T) {
+	args, err := rlimitCommand(nil, []int{0, 2, 3}, []string{"mytool"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{rlimitExecArg, "cpus=0,2,3", "--", "mytool"}; !slices.Equal(args[1:], want) {
+		t.Errorf("got %q, want the binary then %q", args, want)
+	}
+}
+
+func TestRunAppliesCPUAffinity(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("CPU affinity is Linux only")
+	}
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+
+	var stdout, stderr strings.Builder
+	code := run([]string{"--reuse", "--cpu-affinity", "0", "sh", "-c", "grep Cpus_allowed_list /proc/self/status"}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if got := strings.Join(strings.Fields(stdout.String()), " "); got != "Cpus_allowed_list: 0" {
+		t.Errorf("expected the command pinned to CPU 0, got %q", stdout.String())
+	}
+}
+
+func TestRunRejectsInvalidCPUAffinity(t *testing.T) {
+	restoreLogging(t)
+
+	var stdout, stderr strings.Builder
+	if code := run([]string{"--cpu-affinity", "2-1", "true"}, &stdout, &stderr); code != 2 {
+		t.Errorf("expected exit code 2, got %d: %s", code, stderr.String())
+	}
+	if !strings.Contains(stderr.String(), "Invalid --cpu-affinity") {
+		t.Errorf("expected an --cpu-affinity error, got %q", stderr.String())
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// noID is what --uid and --gid are when not given.
+const noID = -1
+
+// credential returns who the command should run as for --uid and --gid,
+// or nil if neither was given. Without --gid the group is the user's
+// primary group; without --uid the user is the wrapper's own.
+func credential(uid, gid int) (*syscall.Credential, error) {
+	if uid == noID && gid == noID {
+		return nil, nil
+	}
+	if err := checkID("--uid", uid); err != nil {
+		return nil, err
+	}
+	if err := checkID("--gid", gid); err != nil {
+		return nil, err
+	}
+	if uid == noID {
+		uid = os.Geteuid()
+	}
+	if gid == noID {
+		u, err := user.LookupId(strconv.Itoa(uid))
+		if err != nil {
+			return nil, fmt.Errorf("--gid is needed: %w", err)
+		}
+		if gid, err = strconv.Atoi(u.Gid); err != nil {
+			return nil, fmt.Errorf("--gid is needed: user %s has group %q", u.Username, u.Gid)
+		}
+	}
+	return &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}, nil
+}
+
+// checkID rejects IDs the kernel can't take; the largest uint32 means
+// "no change" to setuid and setgid.
+func checkID(flag string, id int) error {
+	if id != noID && (id < 0 || id >= math.MaxUint32) {
+		return fmt.Errorf("%s must be between 0 and %d", flag, uint32(math.MaxUint32-1))
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestCredential(t *testing.T) {
+	tests := []struct {
+		name     string
+		uid, gid int
+		want     *syscall.Credential
+	}{
+		{"neither", noID, noID, nil},
+		{"both", 1000, 100, &syscall.Credential{Uid: 1000, Gid: 100}},
+		{"root", 0, 0, &syscall.Credential{Uid: 0, Gid: 0}},
+		{"gid only", noID, 100, &syscall.Credential{Uid: uint32(os.Geteuid()), Gid: 100}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := credential(tt.uid, tt.gid)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if (got == nil) != (tt.want == nil) || got != nil && (got.Uid != tt.want.Uid || got.Gid != tt.want.Gid) {
+				t.Errorf("credential(%d, %d) = %+v, want %+v", tt.uid, tt.gid, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCredentialDefaultsToPrimaryGroup(t *testing.T) {
+	got, err := credential(os.Getuid(), noID)
+	if err != nil {
+		t.Skipf("no passwd entry for the current user: %v", err)
+	}
+	if got.Gid != uint32(os.Getgid()) {
+		t.Errorf("expected the primary group %d, got %d", os.Getgid(), got.Gid)
+	}
+}
+
+func TestCredentialRejectsBadIDs(t *testing.T) {
+	tests := []struct {
+		uid, gid int
+		want     string
+	}{
+		{-5, 0, "--uid must be between 0 and 4294967294"},
+		{0, 1 << 32, "--gid must be between"},
+	}
+	for _, tt := range tests {
+		if _, err := credential(tt.uid, tt.gid); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("credential(%d, %d) error = %v, want %q", tt.uid, tt.gid, err, tt.want)
+		}
+	}
+}
+
+func TestCredentialUnknownUserNeedsGid(t *testing.T) {
+	if _, err := credential(1<<31, noID); err == nil || !strings.Contains(err.Error(), "--gid is needed") {
+		t.Errorf("expected --gid to be asked for, got %v", err)
+	}
+}
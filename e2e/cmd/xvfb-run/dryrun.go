@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// printDryRun writes what a run would do as KEY=VALUE lines: the display,
+// the X server's command line unless there is none to start, the variables
+// set in the commands' environment, and each command's, in the order they
+// would run. Command lines are quoted for sh,
+// so parseServerArgs or a shell splits them back into the same arguments.
+func printDryRun(w io.Writer, display string, server, env []string, commands ...[]string) {
+	fmt.Fprintf(w, "DISPLAY=%s\n", display)
+	if server != nil {
+		fmt.Fprintf(w, "XVFB=%s\n", shellJoin(server))
+	}
+	for _, kv := range env {
+		fmt.Fprintf(w, "ENV=%s\n", shellQuote(kv))
+	}
+	for _, command := range commands {
+		fmt.Fprintf(w, "COMMAND=%s\n", shellJoin(command))
+	}
+}
+
+// shellJoin quotes each of args that needs it and joins them with spaces.
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// shellQuote single-quotes s unless it only has characters a shell leaves
+// alone.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_=+:,./@%") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"-screen", "-screen"},
+		{"1280x1024x24", "1280x1024x24"},
+		{"", "''"},
+		{"two words", "'two words'"},
+		{"$XAUTHORITY", "'$XAUTHORITY'"},
+		{"it's", `'it'\''s'`},
+	}
+	for _, tt := range tests {
+		if got := shellQuote(tt.in); got != tt.want {
+			t.Errorf("shellQuote(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestShellJoinRoundTrips(t *testing.T) {
+	args := []string{"sh", "-c", `echo "it's" $HOME`, "", "a\\b"}
+	if got := parseServerArgs(shellJoin(args)); !slices.Equal(got, args) {
+		t.Errorf("got %q back, want %q", got, args)
+	}
+}
+
+func TestPrintDryRun(t *testing.T) {
+	var out strings.Builder
+	printDryRun(&out, ":99", []string{"Xvfb", ":99", "-auth", "$XAUTHORITY"}, []string{"LANG=C"}, []string{"mytool", "--flag", "a b"})
+	want := "DISPLAY=:99\nXVFB=Xvfb :99 -auth '$XAUTHORITY'\nENV=LANG=C\nCOMMAND=mytool --flag 'a b'\n"
+	if got := out.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestPrintDryRunWithoutServer(t *testing.T) {
+	var out strings.Builder
+	printDryRun(&out, ":42", nil, nil, []string{"true"})
+	if got := out.String(); got != "DISPLAY=:42\nCOMMAND=true\n" {
+		t.Errorf("unexpected output %q", got)
+	}
+}
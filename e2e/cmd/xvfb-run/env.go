@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envFlag collects repeated --env KEY=VALUE flags.
+type envFlag []string
+
+func (e *envFlag) String() string {
+	return strings.Join(*e, " ")
+}
+
+func (e *envFlag) Set(s string) error {
+	if key, _, ok := strings.Cut(s, "="); !ok || key == "" {
+		return fmt.Errorf("expected KEY=VALUE, got %q", s)
+	}
+	*e = append(*e, s)
+	return nil
+}
+
+// mergeEnv returns base with extra applied on top: a key set in extra
+// replaces its value in base, later entries override earlier ones, and
+// extra entries without "=" are skipped.
+func mergeEnv(base []string, extra []string) []string {
+	merged := make([]string, 0, len(base)+len(extra))
+	index := make(map[string]int, len(base)+len(extra))
+	add := func(kv string) {
+		key, _, _ := strings.Cut(kv, "=")
+		if i, ok := index[key]; ok {
+			merged[i] = kv
+			return
+		}
+		index[key] = len(merged)
+		merged = append(merged, kv)
+	}
+	for _, kv := range base {
+		add(kv)
+	}
+	for _, kv := range extra {
+		if key, _, ok := strings.Cut(kv, "="); ok && key != "" {
+			add(kv)
+		}
+	}
+	return merged
+}
+
+// parseEnvFile reads the KEY=VALUE lines of the dotenv file path for
+// --env-file. Blank lines and lines starting with "#" are skipped, and a
+// line may start with "export ". A value may be single-quoted, taken
+// literally, or double-quoted, where \n, \t, \r, \", \\ and \$ are
+// escapes; either way it ends at its closing quote. An unquoted value ends
+// at a " #" comment and has surrounding space trimmed. Nothing is expanded.
+func parseEnvFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var env []string
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if rest, ok := strings.CutPrefix(line, "export"); ok && rest != "" && (rest[0] == ' ' || rest[0] == '\t') {
+			line = strings.TrimSpace(rest)
+		}
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || !validEnvKey(key) {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE, got %q", path, n, line)
+		}
+		if value, err = envFileValue(strings.TrimSpace(value)); err != nil {
+			return nil, fmt.Errorf("%s:%d: %s: %w", path, n, key, err)
+		}
+		env = append(env, key+"="+value)
+	}
+	return env, scanner.Err()
+}
+
+// validEnvKey reports whether key is a name a shell could export.
+func validEnvKey(key string) bool {
+	for i, c := range key {
+		if c != '_' && (c < 'A' || c > 'Z') && (c < 'a' || c > 'z') && (i == 0 || c < '0' || c > '9') {
+			return false
+		}
+	}
+	return key != ""
+}
+
+// envFileValue unquotes s, what follows "=" on a line of an --env-file.
+func envFileValue(s string) (string, error) {
+	if s == "" || (s[0] != '"' && s[0] != '\'') {
+		if i := strings.Index(s, " #"); i >= 0 {
+			s = s[:i]
+		}
+		return strings.TrimSpace(s), nil
+	}
+	quote := s[0]
+	var value strings.Builder
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == quote:
+			if rest := strings.TrimSpace(s[i+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+				return "", fmt.Errorf("unexpected %q after the closing quote", rest)
+			}
+			return value.String(), nil
+		case c == '\\' && quote == '"' && i+1 < len(s):
+			i++
+			switch s[i] {
+			case 'n':
+				value.WriteByte('\n')
+			case 't':
+				value.WriteByte('\t')
+			case 'r':
+				value.WriteByte('\r')
+			case '"', '\\', '$':
+				value.WriteByte(s[i])
+			default:
+				value.WriteByte('\\')
+				value.WriteByte(s[i])
+			}
+		default:
+			value.WriteByte(c)
+		}
+	}
+	return "", fmt.Errorf("missing closing %c", quote)
+}
+
+// argsFromEnv returns the default flags in XVFB_RUN_ARGS, split like a
+// shell would. They are parsed before the command line, so explicit flags
+// override them.
+func argsFromEnv() []string {
+	return parseServerArgs(os.Getenv("XVFB_RUN_ARGS"))
+}
+
+// resolveXvfbPath returns the Xvfb binary to run: flag if given, then
+// $XVFB_BINARY, and "" to look in PATH.
+func resolveXvfbPath(flag string) string {
+	if flag != "" {
+		return flag
+	}
+	return os.Getenv("XVFB_BINARY")
+}
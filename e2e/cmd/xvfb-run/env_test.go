@@ -0,0 +1,164 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMergeEnv(t *testing.T) {
+	tests := []struct {
+		name  string
+		base  []string
+		extra []string
+		want  []string
+	}{
+		{"no extras", []string{"A=1", "B=2"}, nil, []string{"A=1", "B=2"}},
+		{"adds new keys", []string{"A=1"}, []string{"LIBGL_ALWAYS_SOFTWARE=1"}, []string{"A=1", "LIBGL_ALWAYS_SOFTWARE=1"}},
+		{"overrides in place", []string{"A=1", "B=2"}, []string{"A=3"}, []string{"A=3", "B=2"}},
+		{"later extras win", []string{"A=1"}, []string{"A=2", "A=3"}, []string{"A=3"}},
+		{"empty values", []string{"A=1"}, []string{"A="}, []string{"A="}},
+		{"values containing =", nil, []string{"OPTS=a=b"}, []string{"OPTS=a=b"}},
+		{"skips malformed extras", []string{"A=1"}, []string{"NOEQUALS", "=nokey", "B=2"}, []string{"A=1", "B=2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergeEnv(tt.base, tt.extra); !slices.Equal(got, tt.want) {
+				t.Errorf("mergeEnv(%q, %q) = %q, want %q", tt.base, tt.extra, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnvFlag(t *testing.T) {
+	var env envFlag
+	for _, s := range []string{"A=1", "MOZ_HEADLESS=1"} {
+		if err := env.Set(s); err != nil {
+			t.Errorf("Set(%q): %v", s, err)
+		}
+	}
+	for _, s := range []string{"NOEQUALS", "=1", ""} {
+		if err := env.Set(s); err == nil {
+			t.Errorf("expected Set(%q) to fail", s)
+		}
+	}
+	if !slices.Equal(env, envFlag{"A=1", "MOZ_HEADLESS=1"}) {
+		t.Errorf("unexpected flag value %q", env)
+	}
+}
+
+func TestArgsFromEnv(t *testing.T) {
+	t.Setenv("XVFB_RUN_ARGS", `-a --screen 1920x1080x24 -s "-dpi 96 -nocursor" --env 'GREETING=hello world'`)
+
+	want := []string{"-a", "--screen", "1920x1080x24", "-s", "-dpi 96 -nocursor", "--env", "GREETING=hello world"}
+	if got := argsFromEnv(); !slices.Equal(got, want) {
+		t.Errorf("argsFromEnv() = %q, want %q", got, want)
+	}
+}
+
+func TestArgsFromEnvUnset(t *testing.T) {
+	t.Setenv("XVFB_RUN_ARGS", "")
+
+	if got := argsFromEnv(); len(got) != 0 {
+		t.Errorf("expected no args, got %q", got)
+	}
+}
+
+func TestResolveXvfbPath(t *testing.T) {
+	t.Setenv("XVFB_BINARY", "/opt/x11/bin/Xvfb")
+	if got := resolveXvfbPath("/usr/local/bin/Xvfb"); got != "/usr/local/bin/Xvfb" {
+		t.Errorf("expected the flag to win, got %q", got)
+	}
+	if got := resolveXvfbPath(""); got != "/opt/x11/bin/Xvfb" {
+		t.Errorf("expected $XVFB_BINARY, got %q", got)
+	}
+	t.Setenv("XVFB_BINARY", "")
+	if got := resolveXvfbPath(""); got != "" {
+		t.Errorf("expected a PATH lookup, got %q", got)
+	}
+}
+
+func TestParseEnvFile(t *testing.T) {
+	content := `# headless settings
+LANG=C.UTF-8
+
+export MOZ_HEADLESS=1
+  SPACED = padded value   # comment
+EMPTY=
+URL=http://host/#anchor
+SINGLE='literal \n $HOME # kept'
+DOUBLE="line\none\t\"quoted\" \\ \$HOME \q"
+HASH="a # b" # comment
+exportNAME=x
+`
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := parseEnvFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"LANG=C.UTF-8",
+		"MOZ_HEADLESS=1",
+		"SPACED=padded value",
+		"EMPTY=",
+		"URL=http://host/#anchor",
+		`SINGLE=literal \n $HOME # kept`,
+		"DOUBLE=line\none\t\"quoted\" \\ $HOME \\q",
+		"HASH=a # b",
+		"exportNAME=x",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestParseEnvFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	for _, line := range []string{
+		"NOVALUE",
+		"=value",
+		"1KEY=value",
+		"BAD-KEY=value",
+		`OPEN="unterminated`,
+		`OPEN='unterminated`,
+		`TRAILING="value" junk`,
+	} {
+		path := filepath.Join(dir, ".env")
+		if err := os.WriteFile(path, []byte("OK=1\n"+line+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := parseEnvFile(path)
+		if err == nil || !strings.Contains(err.Error(), ".env:2:") {
+			t.Errorf("%s: expected an error for line 2, got %v", line, err)
+		}
+	}
+	if _, err := parseEnvFile(filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+func TestRunEnvFile(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(path, []byte("FROM_FILE=file\nOVERRIDDEN=file\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr strings.Builder
+	code := run([]string{"--reuse", "--env-file", path, "--env", "OVERRIDDEN=flag", "sh", "-c", `echo "$FROM_FILE $OVERRIDDEN"`}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if got := stdout.String(); got != "file flag\n" {
+		t.Errorf("expected --env to win over --env-file, got %q", got)
+	}
+	if code := run([]string{"--reuse", "--env-file", path + "-missing", "true"}, &stdout, &stderr); code != 2 {
+		t.Errorf("expected exit code 2 for a missing --env-file, got %d", code)
+	}
+}
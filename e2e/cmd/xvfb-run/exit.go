@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+
+	"xvfb-run/pkg/xvfb"
+)
+
+// Exit statuses for failures of the wrapper itself rather than the
+// command, listed in --help so CI can tell them apart.
+const (
+	// serverNotFoundExitCode means the X server isn't installed.
+	serverNotFoundExitCode = 3
+	// serverStartExitCode means the X server failed to start.
+	serverStartExitCode = 4
+	// displayTimeoutExitCode means the X server started but its display
+	// never became ready.
+	displayTimeoutExitCode = 5
+	// timeoutExitCode is what the wrapper exits with after --timeout, as
+	// timeout(1) does.
+	timeoutExitCode = 124
+)
+
+// exitCode maps an error from starting the X server or running the child to
+// the status the wrapper should exit with. A child that ran reports its own
+// code, or 128+signum if it was killed by a signal like a shell does.
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, xvfb.ErrTimeout):
+		return timeoutExitCode
+	case errors.Is(err, xvfb.ErrServerNotFound):
+		return serverNotFoundExitCode
+	case errors.Is(err, xvfb.ErrDisplayTimeout):
+		return displayTimeoutExitCode
+	case errors.Is(err, xvfb.ErrXvfbStart):
+		return serverStartExitCode
+	case errors.Is(err, xvfb.ErrCommandNotFound):
+		return notFoundExitCode
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return 1
+	}
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return exitErr.ExitCode()
+}
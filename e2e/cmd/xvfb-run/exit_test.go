@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"testing"
+
+	"xvfb-run/pkg/xvfb"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   int
+	}{
+		{"success", "exit 0", 0},
+		{"usage error", "exit 2", 2},
+		{"skip", "exit 77", 77},
+		{"killed by SIGTERM", "kill -TERM $$", 128 + 15},
+		{"killed by SIGKILL", "kill -KILL $$", 128 + 9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := exec.Command("sh", "-c", tt.script).Run()
+			if got := exitCode(err); got != tt.want {
+				t.Errorf("expected exit code %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestExitCodeForNonExitError(t *testing.T) {
+	if got := exitCode(errors.New("exec: not started")); got != 1 {
+		t.Errorf("expected exit code 1, got %d", got)
+	}
+}
+
+func TestExitCodeForTimeout(t *testing.T) {
+	err := fmt.Errorf("%w after 5s", xvfb.ErrTimeout)
+	if got := exitCode(err); got != 124 {
+		t.Errorf("expected exit code 124, got %d", got)
+	}
+}
+
+func TestExitCodeForWrapperFailures(t *testing.T) {
+	exitErr := exec.Command("sh", "-c", "exit 3").Run()
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"server not found", fmt.Errorf("%w in PATH", xvfb.ErrServerNotFound), serverNotFoundExitCode},
+		{"server failed to start", fmt.Errorf("display :99: %w", xvfb.ErrXvfbStart), serverStartExitCode},
+		{"display timeout", fmt.Errorf("display :99 not ready: %w", xvfb.ErrDisplayTimeout), displayTimeoutExitCode},
+		{"command not found", fmt.Errorf("%w: %w", xvfb.ErrCommandNotFound, exec.ErrNotFound), notFoundExitCode},
+		{"command failed", fmt.Errorf("%w: %w", xvfb.ErrCommandFailed, exitErr), 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(tt.err); got != tt.want {
+				t.Errorf("expected exit code %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseExitMap parses --map-exit's OLD:NEW pairs, e.g. "77:0,3:1", into
+// the exit codes to replace and what with. Both sides must be exit codes,
+// 0 to 255, and each OLD can only be mapped once.
+func parseExitMap(s string) (map[int]int, error) {
+	m := map[int]int{}
+	for _, pair := range strings.Split(s, ",") {
+		from, to, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok {
+			return nil, fmt.Errorf("%q is not OLD:NEW", pair)
+		}
+		old, err := parseExitStatus(from)
+		if err != nil {
+			return nil, err
+		}
+		code, err := parseExitStatus(to)
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := m[old]; dup {
+			return nil, fmt.Errorf("exit code %d is mapped twice", old)
+		}
+		m[old] = code
+	}
+	return m, nil
+}
+
+// parseExitStatus parses s as an exit code, 0 to 255.
+func parseExitStatus(s string) (int, error) {
+	code, err := strconv.Atoi(s)
+	if err != nil || code < 0 || code > 255 {
+		return 0, fmt.Errorf("%q is not an exit code from 0 to 255", s)
+	}
+	return code, nil
+}
+
+// mapExitCode returns what --map-exit's m turns code into, which is code
+// itself if it isn't mapped.
+func mapExitCode(m map[int]int, code int) int {
+	mapped, ok := m[code]
+	if !ok || mapped == code {
+		return code
+	}
+	logEvent("exit_map", fmt.Sprintf("Mapping exit code %d to %d", code, mapped), "exit_code", code, "mapped_exit_code", mapped)
+	return mapped
+}
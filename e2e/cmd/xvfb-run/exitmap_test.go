@@ -0,0 +1,47 @@
+package main
+
+import (
+	"maps"
+	"strings"
+	"testing"
+)
+
+func TestParseExitMap(t *testing.T) {
+	got, err := parseExitMap("77:0, 3:1,0:255")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[int]int{77: 0, 3: 1, 0: 255}; !maps.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, s := range []string{"77", "77:", ":0", "a:1", "1:256", "-1:0", "1:0,1:2", "1:0,"} {
+		if _, err := parseExitMap(s); err == nil {
+			t.Errorf("expected %q to be rejected", s)
+		}
+	}
+}
+
+func TestRunMapExit(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+
+	var stdout, stderr strings.Builder
+	if code := run([]string{"--reuse", "-v", "--map-exit", "77:0,3:1", "sh", "-c", "exit 77"}, &stdout, &stderr); code != 0 {
+		t.Errorf("expected 77 to be mapped to 0, got %d", code)
+	}
+	if !strings.Contains(stderr.String(), "Mapping exit code 77 to 0") || strings.Contains(stderr.String(), "Command failed") {
+		t.Errorf("expected the remap to be logged instead of a failure, got %q", stderr.String())
+	}
+	if code := run([]string{"--reuse", "--map-exit", "77:0,3:1", "sh", "-c", "exit 3"}, &stdout, &stderr); code != 1 {
+		t.Errorf("expected 3 to be mapped to 1, got %d", code)
+	}
+	if code := run([]string{"--reuse", "--map-exit", "77:0", "sh", "-c", "exit 4"}, &stdout, &stderr); code != 4 {
+		t.Errorf("expected an unmapped code to be kept, got %d", code)
+	}
+	if code := run([]string{"--reuse", "--map-exit", "0:9", "true"}, &stdout, &stderr); code != 9 {
+		t.Errorf("expected 0 to be mapped to 9, got %d", code)
+	}
+	if code := run([]string{"--reuse", "--map-exit", "77=0", "true"}, &stdout, &stderr); code != 2 {
+		t.Errorf("expected exit code 2 for a bad mapping, got %d", code)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"xvfb-run/pkg/xvfb"
+)
+
+// maxScreens is how many screens one Xvfb server can have.
+const maxScreens = 16
+
+// supportedDepths are the color depths Xvfb accepts for a screen.
+var supportedDepths = []int{8, 15, 16, 24, 32}
+
+// parseGeometry parses a WIDTHxHEIGHTxDEPTH screen geometry such as
+// 1920x1080x24.
+func parseGeometry(s string) (w, h, depth int, err error) {
+	parts := strings.Split(s, "x")
+	if len(parts) != 3 {
+		return 0, 0, 0, fmt.Errorf("%q is not WIDTHxHEIGHTxDEPTH, e.g. 1920x1080x24", s)
+	}
+	values := make([]int, 3)
+	for i, name := range []string{"width", "height", "depth"} {
+		v, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("invalid %s %q in %q, want a number", name, parts[i], s)
+		}
+		values[i] = v
+	}
+	w, h, depth = values[0], values[1], values[2]
+	if w <= 0 || h <= 0 {
+		return 0, 0, 0, fmt.Errorf("invalid size %dx%d in %q, width and height must be positive", w, h, s)
+	}
+	for _, d := range supportedDepths {
+		if depth == d {
+			return w, h, depth, nil
+		}
+	}
+	return 0, 0, 0, fmt.Errorf("unsupported depth %d in %q, want one of 8, 15, 16, 24 or 32", depth, s)
+}
+
+// screenFlag collects repeated --screen flags for parseScreens.
+type screenFlag []string
+
+func (f *screenFlag) String() string {
+	return strings.Join(*f, " ")
+}
+
+func (f *screenFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+// parseScreens turns --screen values into screens, in the order given. A
+// value is INDEX=WIDTHxHEIGHTxDEPTH, or just the geometry for screen 0.
+func parseScreens(values []string) ([]xvfb.Screen, error) {
+	screens := make([]xvfb.Screen, 0, len(values))
+	seen := make(map[int]bool, len(values))
+	for _, v := range values {
+		index, geometry := 0, v
+		if i, g, ok := strings.Cut(v, "="); ok {
+			n, err := strconv.Atoi(i)
+			if err != nil || n < 0 || n >= maxScreens {
+				return nil, fmt.Errorf("invalid screen index %q in %q, want 0 to %d", i, v, maxScreens-1)
+			}
+			index, geometry = n, g
+		}
+		if _, _, _, err := parseGeometry(geometry); err != nil {
+			return nil, err
+		}
+		if seen[index] {
+			return nil, fmt.Errorf("screen %d is defined more than once", index)
+		}
+		seen[index] = true
+		screens = append(screens, xvfb.Screen{Index: index, Geometry: geometry})
+	}
+	return screens, nil
+}
+
+// resolveGeometry picks the geometry of screen 0: flagVal if set, else
+// envVal from XVFB_SCREEN_GEOMETRY, else xvfb.DefaultScreenGeometry. An
+// invalid envVal is reported and ignored.
+func resolveGeometry(flagVal, envVal string) string {
+	if flagVal != "" {
+		return flagVal
+	}
+	if envVal != "" {
+		if _, _, _, err := parseGeometry(envVal); err != nil {
+			logWarning("xvfb_start", "Ignoring XVFB_SCREEN_GEOMETRY: "+err.Error(), "error", err.Error())
+		} else {
+			return envVal
+		}
+	}
+	return xvfb.DefaultScreenGeometry
+}
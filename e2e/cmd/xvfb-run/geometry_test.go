@@ -0,0 +1,121 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"xvfb-run/pkg/xvfb"
+)
+
+func TestParseGeometry(t *testing.T) {
+	tests := []struct {
+		in          string
+		w, h, depth int
+	}{
+		{"1920x1080x24", 1920, 1080, 24},
+		{"1280x1024x24", 1280, 1024, 24},
+		{"800x600x8", 800, 600, 8},
+		{"640x480x15", 640, 480, 15},
+		{"1024x768x16", 1024, 768, 16},
+		{"3840x2160x32", 3840, 2160, 32},
+	}
+	for _, tt := range tests {
+		w, h, depth, err := parseGeometry(tt.in)
+		if err != nil {
+			t.Errorf("parseGeometry(%q): unexpected error: %v", tt.in, err)
+			continue
+		}
+		if w != tt.w || h != tt.h || depth != tt.depth {
+			t.Errorf("parseGeometry(%q) = %d, %d, %d; want %d, %d, %d", tt.in, w, h, depth, tt.w, tt.h, tt.depth)
+		}
+	}
+}
+
+func TestParseGeometryRejectsInvalidValues(t *testing.T) {
+	for name, in := range map[string]string{
+		"empty":             "",
+		"missing height":    "1920x",
+		"missing depth":     "1920x1080",
+		"trailing x":        "1920x1080x",
+		"too many parts":    "1920x1080x24x2",
+		"letters in width":  "abcx1080x24",
+		"letters in depth":  "1920x1080xdeep",
+		"zero width":        "0x1080x24",
+		"negative height":   "1920x-1080x24",
+		"unsupported depth": "1920x1080x12",
+		"uppercase X":       "1920X1080X24",
+		"spaces":            "1920 x 1080 x 24",
+	} {
+		if _, _, _, err := parseGeometry(in); err == nil {
+			t.Errorf("%s: expected parseGeometry(%q) to fail", name, in)
+		}
+	}
+}
+
+func TestParseScreens(t *testing.T) {
+	screens, err := parseScreens([]string{"0=1280x1024x24", "1=800x600x24"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []xvfb.Screen{{Index: 0, Geometry: "1280x1024x24"}, {Index: 1, Geometry: "800x600x24"}}
+	if !slices.Equal(screens, want) {
+		t.Errorf("got %v, want %v", screens, want)
+	}
+
+	// A bare geometry is screen 0
+	screens, err = parseScreens([]string{"1920x1080x24"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []xvfb.Screen{{Index: 0, Geometry: "1920x1080x24"}}; !slices.Equal(screens, want) {
+		t.Errorf("got %v, want %v", screens, want)
+	}
+}
+
+func TestParseScreensRejectsInvalidValues(t *testing.T) {
+	for name, in := range map[string][]string{
+		"duplicate index":         {"0=1280x1024x24", "0=800x600x24"},
+		"bare geometry and index": {"1280x1024x24", "0=800x600x24"},
+		"negative index":          {"-1=800x600x24"},
+		"index too large":         {"16=800x600x24"},
+		"non-numeric index":       {"a=800x600x24"},
+		"bad geometry":            {"1=800x600"},
+	} {
+		if _, err := parseScreens(in); err == nil {
+			t.Errorf("%s: expected parseScreens(%q) to fail", name, in)
+		}
+	}
+}
+
+func TestResolveGeometry(t *testing.T) {
+	tests := []struct {
+		name, flagVal, envVal, want string
+	}{
+		{"flag wins", "800x600x16", "1920x1080x24", "800x600x16"},
+		{"env over default", "", "1920x1080x24", "1920x1080x24"},
+		{"built-in default", "", "", "1280x1024x24"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := captureLog(t, false)
+			if got := resolveGeometry(tt.flagVal, tt.envVal); got != tt.want {
+				t.Errorf("resolveGeometry(%q, %q) = %q, want %q", tt.flagVal, tt.envVal, got, tt.want)
+			}
+			if out.Len() != 0 {
+				t.Errorf("expected no warning, got %q", out.String())
+			}
+		})
+	}
+}
+
+func TestResolveGeometryWarnsAboutInvalidEnv(t *testing.T) {
+	out := captureLog(t, false)
+
+	if got := resolveGeometry("", "huge"); got != "1280x1024x24" {
+		t.Errorf("expected the built-in default, got %q", got)
+	}
+	if !strings.Contains(out.String(), "Ignoring XVFB_SCREEN_GEOMETRY") {
+		t.Errorf("expected a warning, got %q", out.String())
+	}
+}
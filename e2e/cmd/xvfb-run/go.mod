@@ -1,3 +1,5 @@
 module xvfb-run
 
-go 1.21
\ No newline at end of file
+go 1.21
+
+require gopkg.in/yaml.v3 v3.0.1
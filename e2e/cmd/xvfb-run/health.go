@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// healthShutdownTimeout is how long teardown lets --health-port's requests
+// in flight finish.
+const healthShutdownTimeout = time.Second
+
+// health is what --health-port's /healthz reports.
+type health struct {
+	Status  string `json:"status"`
+	Display string `json:"display"`
+	XvfbPID int    `json:"xvfb_pid,omitempty"`
+}
+
+// serverAlive reports whether the X server with PID pid, 0 for one the
+// wrapper didn't start, is still running and listening on socket.
+func serverAlive(pid int, socket string) bool {
+	if pid != 0 && !processAlive(pid) {
+		return false
+	}
+	_, err := os.Stat(socket)
+	return err == nil
+}
+
+// healthHandler serves /healthz for the X server on display with PID pid:
+// 200 with its details as JSON while alive says it is running, 503 once
+// it isn't.
+func healthHandler(display string, pid int, alive func() bool) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		h, code := health{Status: "ok", Display: display, XvfbPID: pid}, http.StatusOK
+		if !alive() {
+			h.Status, code = "down", http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(h)
+	})
+	return mux
+}
+
+// startHealthServer serves handler on port on every interface, so probes
+// from outside a container reach it. The port is bound before it returns,
+// so one in use is an error here. stop shuts the server down.
+func startHealthServer(port int, handler http.Handler) (stop func(), err error) {
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{Handler: handler, ReadHeaderTimeout: 5 * time.Second}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logWarning("health", fmt.Sprintf("--health-port stopped serving: %v", err), "error", err.Error())
+		}
+	}()
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), healthShutdownTimeout)
+		defer cancel()
+		if srv.Shutdown(ctx) != nil {
+			srv.Close()
+		}
+		<-done
+	}, nil
+}
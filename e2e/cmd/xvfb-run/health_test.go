@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHealthHandler(t *testing.T) {
+	alive := true
+	handler := healthHandler(":99", 1234, func() bool { return alive })
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected 200 while alive, got %d", rec.Code)
+	}
+	var got health
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := (health{Status: "ok", Display: ":99", XvfbPID: 1234}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	alive = false
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), `"status":"down"`) {
+		t.Errorf("expected 503 once down, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 elsewhere, got %d", rec.Code)
+	}
+}
+
+func TestServerAlive(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "X99")
+	if err := os.WriteFile(socket, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if !serverAlive(os.Getpid(), socket) || !serverAlive(0, socket) {
+		t.Error("expected a running server with its socket to be alive")
+	}
+	if serverAlive(os.Getpid(), socket+"-missing") {
+		t.Error("expected a server without its socket to be down")
+	}
+	if serverAlive(1<<22+1, socket) {
+		t.Error("expected a server that has exited to be down")
+	}
+}
+
+func TestStartHealthServer(t *testing.T) {
+	restoreLogging(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	stop, err := startHealthServer(port, healthHandler(":99", 0, func() bool { return true }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/healthz", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200, got %d", resp.StatusCode)
+	}
+
+	if _, err := startHealthServer(port, http.NotFoundHandler()); err == nil {
+		t.Error("expected a port in use to be an error")
+	}
+	stop()
+	if _, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/healthz", port)); err == nil {
+		t.Error("expected the server to be shut down")
+	}
+}
+
+func TestRunHealthPortNeedsPrintDisplay(t *testing.T) {
+	restoreLogging(t)
+	var stdout, stderr strings.Builder
+	if code := run([]string{"--health-port", "8080", "true"}, &stdout, &stderr); code != 2 {
+		t.Errorf("expected exit code 2 without --print-display, got %d", code)
+	}
+	if code := run([]string{"--print-display", "--health-port", "70000"}, &stdout, &stderr); code != 2 {
+		t.Errorf("expected exit code 2 for a bad port, got %d", code)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// errHook is what a failing --before or --after hook's error wraps.
+var errHook = errors.New("hook failed")
+
+// hookGracePeriod is how long teardown gives an --on-ready process after
+// SIGTERM before it sends SIGKILL.
+const hookGracePeriod = 2 * time.Second
+
+// runReadyHook starts command with sh -c on session's display once it is
+// ready, typically a window manager that applications expect to be there:
+//
+//	xvfb-run --on-ready fluxbox -- mytool
+//
+// It runs in the background, in its own process group, with env plus
+// DISPLAY and XAUTHORITY, and its output goes to out. The main command
+// doesn't wait for it; stopReadyHook ends it at teardown.
+func runReadyHook(session Session, command string, env []string, out io.Writer) (*exec.Cmd, error) {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Env = hookEnv(session, env)
+	cmd.Stdout, cmd.Stderr = out, out
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.WaitDelay = hookGracePeriod
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	logEvent("ready_hook", fmt.Sprintf("Started --on-ready hook (PID %d): %s", cmd.Process.Pid, command), "command", command, "pid", cmd.Process.Pid)
+	return cmd, nil
+}
+
+// stopReadyHook terminates the hook's process group, with SIGKILL if it is
+// still there after hookGracePeriod, and reaps the hook.
+func stopReadyHook(cmd *exec.Cmd) {
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	stopGroup(cmd.Process.Pid, done)
+}
+
+// stopGroup terminates the process group led by pid, with SIGKILL if its
+// leader hasn't exited, which done reports, after hookGracePeriod.
+func stopGroup(pid int, done <-chan error) {
+	syscall.Kill(-pid, syscall.SIGTERM)
+	select {
+	case <-done:
+	case <-time.After(hookGracePeriod):
+		syscall.Kill(-pid, syscall.SIGKILL)
+		<-done
+	}
+	// Anything the leader left behind in its group goes too
+	syscall.Kill(-pid, syscall.SIGKILL)
+}
+
+// runHook runs command for --before or --after, name says which, with sh
+// -c on session's display and waits for it:
+//
+//	xvfb-run --before ./seed-db --after ./dump-logs -- mytool
+//
+// Like an --on-ready hook it gets env plus DISPLAY and XAUTHORITY, and
+// runs in its own process group, which is killed once it exits. Its
+// output goes to stdout and stderr, as the command's does. A failure is
+// returned wrapped in errHook, so the exit status is still the hook's.
+func runHook(name string, session Session, command string, env []string, stdout, stderr io.Writer) error {
+	logEvent("hook_run", fmt.Sprintf("Running --%s hook: %s", name, command), "hook", name, "command", command)
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Env = hookEnv(session, env)
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.WaitDelay = hookGracePeriod
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("%w: --%s: %w", errHook, name, err)
+	}
+	err := cmd.Wait()
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	if err != nil {
+		return fmt.Errorf("%w: --%s: %w", errHook, name, err)
+	}
+	return nil
+}
+
+// hookEnv is env with DISPLAY and XAUTHORITY set for session.
+func hookEnv(session Session, env []string) []string {
+	vars := []string{"DISPLAY=" + session.Display}
+	if session.AuthFile != "" {
+		vars = append(vars, "XAUTHORITY="+session.AuthFile)
+	}
+	return mergeEnv(env, vars)
+}
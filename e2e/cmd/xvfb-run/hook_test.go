@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReadyHookRunsOnDisplayAndIsStopped(t *testing.T) {
+	restoreLogging(t)
+	marker := filepath.Join(t.TempDir(), "started")
+	var out strings.Builder
+	cmd, err := runReadyHook(Session{Display: ":7", AuthFile: "/tmp/auth"}, `echo "$DISPLAY $XAUTHORITY"; touch `+marker+`; exec sleep 30`, []string{"PATH=" + os.Getenv("PATH")}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(marker); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stopReadyHook(cmd)
+	if got := out.String(); got != ":7 /tmp/auth\n" {
+		t.Errorf("unexpected hook output %q", got)
+	}
+	// Killed processes can take a moment to go
+	deadline = time.Now().Add(2 * time.Second)
+	for syscall.Kill(-cmd.Process.Pid, 0) == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the hook's process group to be gone")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRunOnReadyHookStartsBeforeCommand(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "ready")
+	pidFile := filepath.Join(dir, "pid")
+
+	var stdout, stderr strings.Builder
+	hook := `echo $$ > ` + pidFile + `; echo "$DISPLAY" > ` + marker + `; exec sleep 30`
+	command := `for i in $(seq 100); do [ -s "$1" ] && break; sleep 0.05; done; cat "$1"`
+	if code := run([]string{"--reuse", "--on-ready", hook, "sh", "-c", command, "sh", marker}, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if got := stdout.String(); got != ":42\n" {
+		t.Errorf("expected the hook to see the display, got %q", got)
+	}
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	if processAlive(pid) {
+		t.Errorf("expected the hook (PID %d) to be stopped at cleanup", pid)
+	}
+}
+
+func TestRunHook(t *testing.T) {
+	restoreLogging(t)
+	var stdout, stderr strings.Builder
+	err := runHook("before", Session{Display: ":7", AuthFile: "/tmp/auth"}, `echo "$DISPLAY $XAUTHORITY"; echo oops >&2`, []string{"PATH=" + os.Getenv("PATH")}, &stdout, &stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != ":7 /tmp/auth\n" || stderr.String() != "oops\n" {
+		t.Errorf("unexpected hook output %q and %q", stdout.String(), stderr.String())
+	}
+
+	err = runHook("after", Session{Display: ":7"}, "exit 3", nil, &stdout, &stderr)
+	if !errors.Is(err, errHook) || exitCode(err) != 3 || !strings.Contains(err.Error(), "--after") {
+		t.Errorf("expected a --after hook error with its exit status, got %v", err)
+	}
+}
+
+func TestRunBeforeAndAfterHooks(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+
+	var stdout, stderr strings.Builder
+	code := run([]string{"--reuse", "--before", `echo "before $DISPLAY"`, "--after", `echo "after $DISPLAY"`, "sh", "-c", "echo command; exit 4"}, &stdout, &stderr)
+	if code != 4 {
+		t.Errorf("expected the command's exit code 4, got %d: %s", code, stderr.String())
+	}
+	if got := stdout.String(); got != "before :42\ncommand\nafter :42\n" {
+		t.Errorf("expected the hooks around the command, got %q", got)
+	}
+}
+
+func TestRunBeforeFailureSkipsCommand(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+
+	var stdout, stderr strings.Builder
+	code := run([]string{"--reuse", "--before", "exit 6", "--after", "echo after", "echo", "command"}, &stdout, &stderr)
+	if code != 6 {
+		t.Errorf("expected the --before hook's exit code 6, got %d: %s", code, stderr.String())
+	}
+	if got := stdout.String(); got != "after\n" {
+		t.Errorf("expected only the --after hook to run, got %q", got)
+	}
+	if !strings.Contains(stderr.String(), "hook failed: --before") {
+		t.Errorf("expected the --before failure to be reported, got %q", stderr.String())
+	}
+}
+
+func TestRunAfterFailure(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+
+	var stdout, stderr strings.Builder
+	if code := run([]string{"--reuse", "--after", "exit 5", "true"}, &stdout, &stderr); code != 0 {
+		t.Errorf("expected a failing --after hook not to change the exit code, got %d", code)
+	}
+	if !strings.Contains(stderr.String(), "hook failed: --after") {
+		t.Errorf("expected a warning, got %q", stderr.String())
+	}
+
+	stderr.Reset()
+	if code := run([]string{"--reuse", "--after", "exit 5", "--after-must-pass", "true"}, &stdout, &stderr); code != 5 {
+		t.Errorf("expected --after-must-pass to exit with the hook's code 5, got %d", code)
+	}
+	if code := run([]string{"--reuse", "--after", "exit 5", "--after-must-pass", "sh", "-c", "exit 2"}, &stdout, &stderr); code != 2 {
+		t.Errorf("expected the command's failure to win over the hook's, got %d", code)
+	}
+}
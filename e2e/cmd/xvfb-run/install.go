@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"xvfb-run/pkg/xvfb"
+)
+
+// installHints maps a package manager to the command that installs each
+// backend's X server with it, in the order they're checked.
+var installHints = []struct {
+	manager  string
+	xvfb     string
+	xwayland string
+}{
+	{"apt-get", "apt-get install xvfb", "apt-get install xwayland weston"},
+	{"dnf", "dnf install xorg-x11-server-Xvfb", "dnf install xorg-x11-server-Xwayland weston"},
+	{"yum", "yum install xorg-x11-server-Xvfb", "yum install xorg-x11-server-Xwayland weston"},
+	{"apk", "apk add xvfb", "apk add xwayland weston"},
+	{"pacman", "pacman -S xorg-server-xvfb", "pacman -S xorg-xwayland weston"},
+	{"zypper", "zypper install xorg-x11-server-Xvfb", "zypper install xwayland weston"},
+	{"brew", "brew install --cask xquartz", ""},
+}
+
+// installHint returns how to install the X server for backend on this
+// machine: $XVFB_RUN_INSTALL_HINT if set, otherwise the command for the
+// first package manager found in PATH, falling back to the apt-get one.
+func installHint(backend string) string {
+	if hint := os.Getenv("XVFB_RUN_INSTALL_HINT"); hint != "" {
+		return hint
+	}
+	pick := func(i int) string {
+		if backend == xvfb.BackendXwayland {
+			return installHints[i].xwayland
+		}
+		return installHints[i].xvfb
+	}
+	for i, h := range installHints {
+		if _, err := exec.LookPath(h.manager); err == nil && pick(i) != "" {
+			return pick(i)
+		}
+	}
+	return pick(0)
+}
+
+// reportMissingServer tells the user the X server for backend isn't
+// installed and how to get it.
+func reportMissingServer(backend string, err error) {
+	name := "Xvfb"
+	if backend == xvfb.BackendXwayland {
+		name = "Xwayland"
+	}
+	msg := fmt.Sprintf("%s is not installed: %v\nInstall it with: %s", name, err, installHint(backend))
+	if backend != xvfb.BackendXwayland {
+		msg += "\nor point --xvfb-path or $XVFB_BINARY at an Xvfb binary."
+	}
+	logError("xvfb_start", msg, "error", err.Error())
+}
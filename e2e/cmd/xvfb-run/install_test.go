@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"xvfb-run/pkg/xvfb"
+)
+
+// pathWith makes PATH a fresh directory holding empty executables with the
+// given names, and nothing else.
+func pathWith(t *testing.T, names ...string) {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir)
+}
+
+func TestInstallHint(t *testing.T) {
+	t.Setenv("XVFB_RUN_INSTALL_HINT", "")
+	tests := []struct {
+		name    string
+		path    []string
+		backend string
+		want    string
+	}{
+		{"apt", []string{"apt-get"}, xvfb.BackendXvfb, "apt-get install xvfb"},
+		{"dnf", []string{"dnf", "yum"}, xvfb.BackendXvfb, "dnf install xorg-x11-server-Xvfb"},
+		{"alpine", []string{"apk"}, xvfb.BackendXvfb, "apk add xvfb"},
+		{"xwayland", []string{"pacman"}, xvfb.BackendXwayland, "pacman -S xorg-xwayland weston"},
+		{"no package manager", nil, xvfb.BackendXvfb, "apt-get install xvfb"},
+		{"no xwayland package", []string{"brew"}, xvfb.BackendXwayland, "apt-get install xwayland weston"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pathWith(t, tt.path...)
+			if got := installHint(tt.backend); got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestInstallHintOverride(t *testing.T) {
+	pathWith(t, "apt-get")
+	t.Setenv("XVFB_RUN_INSTALL_HINT", "ask IT for Xvfb")
+
+	if got := installHint(xvfb.BackendXvfb); got != "ask IT for Xvfb" {
+		t.Errorf("expected the override, got %q", got)
+	}
+}
+
+func TestRunWithoutXvfbInstalled(t *testing.T) {
+	restoreLogging(t)
+	pathWith(t, "apt-get")
+	t.Setenv("XVFB_BINARY", "")
+	t.Setenv("XVFB_RUN_ARGS", "")
+	t.Setenv("XVFB_RUN_INSTALL_HINT", "")
+
+	var stdout, stderr strings.Builder
+	code := run([]string{"/bin/true"}, &stdout, &stderr)
+	if code != serverNotFoundExitCode {
+		t.Fatalf("expected exit code %d, got %d: %s", serverNotFoundExitCode, code, stderr.String())
+	}
+	for _, want := range []string{"Xvfb is not installed", "Install it with: apt-get install xvfb", "--xvfb-path"} {
+		if !strings.Contains(stderr.String(), want) {
+			t.Errorf("expected %q in stderr, got: %s", want, stderr.String())
+		}
+	}
+}
+
+func TestRunWithoutXwaylandUsesHintOverride(t *testing.T) {
+	restoreLogging(t)
+	pathWith(t, "Xvfb")
+	t.Setenv("XVFB_RUN_ARGS", "")
+	t.Setenv("XVFB_RUN_INSTALL_HINT", "see the wiki")
+
+	var stdout, stderr strings.Builder
+	code := run([]string{"--backend", "xwayland", "/bin/true"}, &stdout, &stderr)
+	if code != serverNotFoundExitCode {
+		t.Fatalf("expected exit code %d, got %d: %s", serverNotFoundExitCode, code, stderr.String())
+	}
+	if !strings.Contains(stderr.String(), "Xwayland is not installed") || !strings.Contains(stderr.String(), "Install it with: see the wiki") {
+		t.Errorf("unexpected stderr: %s", stderr.String())
+	}
+}
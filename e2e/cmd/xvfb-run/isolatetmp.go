@@ -0,0 +1,25 @@
+package main
+
+import "os"
+
+// setupIsolatedTmp creates a private temporary directory for --isolate-tmp,
+// so commands that leave files in the shared /tmp can't trip over each
+// other's. cleanup removes it and everything in it.
+func setupIsolatedTmp() (dir string, cleanup func(), err error) {
+	dir, err = os.MkdirTemp("", "xvfb-run-tmp.")
+	if err != nil {
+		return "", nil, err
+	}
+	return dir, func() { os.RemoveAll(dir) }, nil
+}
+
+// isolatedTmpEnv is what --isolate-tmp adds to the command's environment:
+// TMPDIR for dir, and XDG_RUNTIME_DIR for socketDir if --socket-dir is set,
+// as the X server has it, instead of the runtime directory sessions share.
+func isolatedTmpEnv(dir, socketDir string) []string {
+	env := []string{"TMPDIR=" + dir}
+	if socketDir != "" {
+		env = append(env, "XDG_RUNTIME_DIR="+socketDir)
+	}
+	return env
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSetupIsolatedTmp(t *testing.T) {
+	dir, cleanup, err := setupIsolatedTmp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dir+"/artifact", nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	other, cleanupOther, err := setupIsolatedTmp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanupOther()
+	if other == dir {
+		t.Errorf("expected a new directory each time, got %s twice", dir)
+	}
+
+	cleanup()
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed, got %v", dir, err)
+	}
+}
+
+func TestIsolatedTmpEnv(t *testing.T) {
+	if got, want := isolatedTmpEnv("/tmp/x", ""), []string{"TMPDIR=/tmp/x"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := isolatedTmpEnv("/tmp/x", "/run/x11"), []string{"TMPDIR=/tmp/x", "XDG_RUNTIME_DIR=/run/x11"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestRunIsolateTmp(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+
+	var stdout, stderr strings.Builder
+	code := run([]string{"--reuse", "--isolate-tmp", "sh", "-c", `touch "$TMPDIR/artifact" && echo "$TMPDIR"`}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	dir := strings.TrimSpace(stdout.String())
+	if dir == "" || dir == os.TempDir() {
+		t.Fatalf("expected a private TMPDIR, got %q", dir)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed after the run, got %v", dir, err)
+	}
+}
+
+func TestRunIsolateTmpLetsEnvWin(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+
+	var stdout, stderr strings.Builder
+	code := run([]string{"--reuse", "--isolate-tmp", "--env", "TMPDIR=/mine", "sh", "-c", `echo "$TMPDIR"`}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if got := stdout.String(); got != "/mine\n" {
+		t.Errorf("expected --env to override TMPDIR, got %q", got)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// defaultKeepOnFailure is how long a bare --keep-on-failure holds the
+// display.
+const defaultKeepOnFailure = 10 * time.Minute
+
+// keepFlag is --keep-on-failure. Given alone it holds the display for
+// defaultKeepOnFailure, and given as --keep-on-failure=DURATION for that
+// long. Zero means off.
+type keepFlag time.Duration
+
+func (k *keepFlag) String() string {
+	if *k == 0 {
+		return ""
+	}
+	return time.Duration(*k).String()
+}
+
+func (k *keepFlag) Set(s string) error {
+	switch s {
+	case "true":
+		*k = keepFlag(defaultKeepOnFailure)
+		return nil
+	case "false":
+		*k = 0
+		return nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return fmt.Errorf("expected a positive duration such as 5m, got %q", s)
+	}
+	*k = keepFlag(d)
+	return nil
+}
+
+func (k *keepFlag) IsBoolFlag() bool { return true }
+
+// holdForInspection keeps a failed run's display up for d, or until stop
+// delivers a signal, so someone can connect and look at it. How to connect
+// goes to w.
+func holdForInspection(w io.Writer, display, authFile string, d time.Duration, stop <-chan os.Signal) {
+	fmt.Fprintf(w, decorate("⏸️ --keep-on-failure: keeping %s up for %s, interrupt to clean up now.\n"), display, d)
+	if authFile != "" {
+		fmt.Fprintf(w, "   Connect with DISPLAY=%s XAUTHORITY=%s\n", display, authFile)
+	} else {
+		fmt.Fprintf(w, "   Connect with DISPLAY=%s\n", display)
+	}
+	logEvent("display_hold", fmt.Sprintf("Holding %s for %s after the command failed", display, d), "display", display, "duration_ms", d.Milliseconds())
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-stop:
+	}
+}
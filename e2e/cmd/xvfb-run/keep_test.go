@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestKeepFlag(t *testing.T) {
+	var k keepFlag
+	if err := k.Set("true"); err != nil || time.Duration(k) != defaultKeepOnFailure {
+		t.Errorf("bare flag: got %v, %v", time.Duration(k), err)
+	}
+	if err := k.Set("90s"); err != nil || time.Duration(k) != 90*time.Second {
+		t.Errorf("=90s: got %v, %v", time.Duration(k), err)
+	}
+	if k.String() != "1m30s" {
+		t.Errorf("unexpected String %q", k.String())
+	}
+	if err := k.Set("false"); err != nil || k != 0 {
+		t.Errorf("=false: got %v, %v", time.Duration(k), err)
+	}
+	for _, bad := range []string{"soon", "0s", "-1m"} {
+		if err := k.Set(bad); err == nil {
+			t.Errorf("Set(%q): expected an error", bad)
+		}
+	}
+}
+
+func TestHoldForInspectionTimesOut(t *testing.T) {
+	restoreLogging(t)
+	var out strings.Builder
+	start := time.Now()
+	holdForInspection(&out, ":99", "/tmp/auth", 100*time.Millisecond, make(chan os.Signal))
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("returned after only %s", elapsed)
+	}
+	if !strings.Contains(out.String(), "DISPLAY=:99 XAUTHORITY=/tmp/auth") {
+		t.Errorf("expected connection info, got %q", out.String())
+	}
+}
+
+func TestHoldForInspectionStopsOnSignal(t *testing.T) {
+	restoreLogging(t)
+	stop := make(chan os.Signal, 1)
+	stop <- syscall.SIGINT
+	start := time.Now()
+	holdForInspection(&strings.Builder{}, ":99", "", time.Hour, stop)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected a signal to end the hold, took %s", elapsed)
+	}
+}
+
+func TestRunKeepOnFailure(t *testing.T) {
+	if _, err := exec.LookPath("xauth"); err != nil {
+		t.Skip("xauth not installed")
+	}
+	restoreLogging(t)
+	t.Setenv("XVFB_RUN_ARGS", "")
+	bin, socketDir := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "Xvfb"), []byte(fakeDaemonXvfb), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(socketDir, ".X11-unix"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	for _, tt := range []struct {
+		name string
+		cmd  string
+		code int
+		held bool
+	}{
+		{"failure", "exit 3", 3, true},
+		{"success", "exit 0", 0, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr strings.Builder
+			start := time.Now()
+			code := run([]string{"-n", "13", "--socket-dir", socketDir, "--keep-on-failure=200ms", "sh", "-c", tt.cmd}, &stdout, &stderr)
+			if code != tt.code {
+				t.Errorf("expected the command's exit code %d, got %d: %s", tt.code, code, stderr.String())
+			}
+			held := strings.Contains(stderr.String(), "--keep-on-failure: keeping :13 up for 200ms")
+			if held != tt.held {
+				t.Errorf("expected held=%v, stderr: %s", tt.held, stderr.String())
+			}
+			if tt.held && time.Since(start) < 200*time.Millisecond {
+				t.Errorf("expected the display to be held for 200ms")
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"xvfb-run/pkg/xvfb"
+)
+
+// maxStatusFileSize bounds what listDisplays reads of a file that might be
+// a status file; real ones are a few lines.
+const maxStatusFileSize = 4096
+
+// listDisplays finds the displays recorded in statusDir by --status-file
+// and xvfb-run start, sorted by display number. Every regular file there
+// with a DISPLAY line naming an X display counts, so the directory can
+// hold other files too. Where the X server's command line can be read,
+// Geometry is filled in from it, and Socket is set if the display's socket
+// is in socketDir, /tmp if empty.
+func listDisplays(statusDir, socketDir string) ([]Session, error) {
+	entries, err := os.ReadDir(statusDir)
+	if err != nil {
+		return nil, err
+	}
+	var sessions []Session
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		session, ok := readStatus(filepath.Join(statusDir, entry.Name()))
+		if !ok {
+			continue
+		}
+		if session.XvfbPID != 0 {
+			session.Geometry = serverGeometry(session.XvfbPID)
+		}
+		n, _ := xvfb.DisplayNumber(session.Display)
+		if socket := xvfb.SocketPath(socketDir, n); fileExists(socket) {
+			session.Socket = socket
+		}
+		sessions = append(sessions, session)
+	}
+	slices.SortFunc(sessions, func(a, b Session) int {
+		n, _ := xvfb.DisplayNumber(a.Display)
+		m, _ := xvfb.DisplayNumber(b.Display)
+		return n - m
+	})
+	return sessions, nil
+}
+
+// readStatus reads the session in the status or state file path, if it is
+// one.
+func readStatus(path string) (Session, bool) {
+	if info, err := os.Stat(path); err != nil || info.Size() > maxStatusFileSize {
+		return Session{}, false
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return Session{}, false
+	}
+	defer f.Close()
+	var session Session
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), "=")
+		switch key {
+		case "DISPLAY":
+			session.Display = value
+		case "XAUTHORITY":
+			session.AuthFile = value
+		case "XVFB_PID":
+			session.XvfbPID, _ = strconv.Atoi(value)
+		}
+	}
+	_, err = xvfb.DisplayNumber(session.Display)
+	return session, err == nil && scanner.Err() == nil
+}
+
+// serverGeometry returns the first -screen geometry on the command line
+// of process pid, or "" if it has none or can't be read.
+func serverGeometry(pid int) string {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return ""
+	}
+	args := strings.Split(strings.TrimSuffix(string(data), "\x00"), "\x00")
+	for i, arg := range args {
+		if arg == "-screen" && i+2 < len(args) {
+			return args[i+2]
+		}
+	}
+	return ""
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// listCommand is "xvfb-run list [--json] [--socket-dir DIR] [DIR]": it
+// prints the displays listDisplays finds in DIR, the temporary directory by
+// default, as a table or, with --json, a JSON array. Each says whether its
+// X server is still running, so leaked sessions and stale files stand out.
+func listCommand(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("xvfb-run list", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	jsonOutput := fs.Bool("json", false, "print the displays as a JSON array")
+	socketDir := fs.String("socket-dir", "", "directory the X servers keep their lock files and .X11-unix in, instead of /tmp")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 1 {
+		logError("usage", "usage: xvfb-run list [--json] [--socket-dir DIR] [DIR]")
+		return 2
+	}
+	dir := os.TempDir()
+	if fs.NArg() == 1 {
+		dir = fs.Arg(0)
+	}
+	sessions, err := listDisplays(dir, *socketDir)
+	if err != nil {
+		logError("list", "Can't list displays: "+err.Error(), "error", err.Error())
+		return 1
+	}
+	type listed struct {
+		Session
+		Running bool `json:"running"`
+	}
+	displays := make([]listed, 0, len(sessions))
+	for _, s := range sessions {
+		displays = append(displays, listed{s, s.XvfbPID != 0 && processAlive(s.XvfbPID)})
+	}
+	if *jsonOutput {
+		json.NewEncoder(stdout).Encode(displays)
+		return 0
+	}
+	w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DISPLAY\tPID\tGEOMETRY\tSTATUS")
+	for _, d := range displays {
+		pid, geometry, status := "-", "-", "gone"
+		if d.XvfbPID != 0 {
+			pid = strconv.Itoa(d.XvfbPID)
+		}
+		if d.Geometry != "" {
+			geometry = d.Geometry
+		}
+		if d.Running {
+			status = "running"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", d.Display, pid, geometry, status)
+	}
+	w.Flush()
+	return 0
+}
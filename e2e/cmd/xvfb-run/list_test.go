@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// statusDir makes a directory with a status file for :5, served by a live
+// process with an X server's -screen argument, a start state file for :3,
+// whose server is gone, and files that aren't either.
+func statusDir(t *testing.T) (dir string, pid int) {
+	t.Helper()
+	dir = t.TempDir()
+	cmd := exec.Command("sh", "-c", "sleep 30; :", "Xvfb", ":5", "-screen", "0", "800x600x24")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	pid = cmd.Process.Pid
+	files := map[string]string{
+		"ci.status":       "DISPLAY=:5\nXVFB_PID=" + strconv.Itoa(pid) + "\n",
+		"xvfb-run-3.env":  "DISPLAY=:3\nXAUTHORITY=/tmp/xvfb-run.1.Xauthority\nXVFB_PID=4194305\n",
+		"notes.txt":       "nothing to see\n",
+		"other.env":       "DISPLAY=wayland-0\n",
+		"subdir/x.status": "DISPLAY=:9\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir, pid
+}
+
+func TestListDisplays(t *testing.T) {
+	dir, pid := statusDir(t)
+	socketDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(socketDir, ".X11-unix"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(socketDir, ".X11-unix", "X5"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sessions, err := listDisplays(dir, socketDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("expected :3 and :5, got %+v", sessions)
+	}
+	if s := sessions[0]; s.Display != ":3" || s.AuthFile != "/tmp/xvfb-run.1.Xauthority" || s.XvfbPID != 4194305 || s.Geometry != "" || s.Socket != "" {
+		t.Errorf("unexpected session for :3: %+v", s)
+	}
+	if s := sessions[1]; s.Display != ":5" || s.XvfbPID != pid || s.Geometry != "800x600x24" || s.Socket != filepath.Join(socketDir, ".X11-unix", "X5") {
+		t.Errorf("unexpected session for :5: %+v", s)
+	}
+
+	if _, err := listDisplays(filepath.Join(dir, "missing"), ""); err == nil {
+		t.Error("expected an error for a missing directory")
+	}
+}
+
+func TestReadStatusDisplayForms(t *testing.T) {
+	dir := t.TempDir()
+	for _, display := range []string{":7", ":7.0", "localhost:7", "localhost:7.0"} {
+		path := filepath.Join(dir, "status")
+		if err := os.WriteFile(path, []byte("DISPLAY="+display+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if s, ok := readStatus(path); !ok || s.Display != display {
+			t.Errorf("readStatus with DISPLAY=%s = %+v, %v", display, s, ok)
+		}
+	}
+}
+
+func TestListCommand(t *testing.T) {
+	restoreLogging(t)
+	dir, pid := statusDir(t)
+
+	var stdout, stderr strings.Builder
+	if code := dispatch([]string{"list", dir}, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	want := "DISPLAY  PID      GEOMETRY    STATUS\n" +
+		":3       4194305  -           gone\n" +
+		":5       " + strconv.Itoa(pid) + strings.Repeat(" ", 9-len(strconv.Itoa(pid))) + "800x600x24  running\n"
+	if got := stdout.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	stdout.Reset()
+	if code := dispatch([]string{"list", "--json", dir}, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	var listed []struct {
+		Display string `json:"display"`
+		XvfbPID int    `json:"xvfb_pid"`
+		Running bool   `json:"running"`
+	}
+	if err := json.Unmarshal([]byte(stdout.String()), &listed); err != nil {
+		t.Fatalf("expected JSON, got %q: %v", stdout.String(), err)
+	}
+	if len(listed) != 2 || listed[0].Running || !listed[1].Running || listed[1].XvfbPID != pid {
+		t.Errorf("unexpected list %+v", listed)
+	}
+
+	if code := dispatch([]string{"list", dir, dir}, &stdout, &stderr); code != 2 {
+		t.Errorf("expected exit code 2 for two directories, got %d", code)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"unicode"
+)
+
+var (
+	// verbose turns on the wrapper's own status messages.
+	verbose bool
+	// logOutput is where status messages go; never stdout, which belongs
+	// to the child.
+	logOutput io.Writer = os.Stderr
+	// logFormat is how events are written: human, text or json.
+	logFormat = "human"
+	// logLevel is the least severe level logged. If empty, it is info with
+	// verbose and warn otherwise.
+	logLevel string
+	// plainOutput drops the emoji from the wrapper's messages, for log
+	// viewers that show them as mojibake. See plainStatus.
+	plainOutput bool
+)
+
+// eventEmoji is what the human format puts in front of each event's
+// message, as the wrapper's messages always had.
+var eventEmoji = map[string]string{
+	"xvfb_start":      "🎬",
+	"display_reuse":   "♻️",
+	"display_ready":   "📺",
+	"framebuffer":     "🖼️",
+	"display_probe":   "✅",
+	"display_hold":    "🖥️",
+	"health":          "🩺",
+	"ready_hook":      "🪟",
+	"wm_start":        "🪟",
+	"hook_run":        "🪝",
+	"recording_start": "🎥",
+	"command_start":   "🚀",
+	"command_exit":    "🏁",
+	"command_retry":   "🔁",
+	"exit_map":        "🔀",
+	"screenshot":      "📸",
+	"cleanup":         "🧹",
+}
+
+// plainStatus reports whether messages written to stderr should be plain
+// ASCII: NO_COLOR is set, or stderr isn't a terminal and so is most likely
+// going to a CI log or a log aggregator.
+func plainStatus(stderr io.Writer) bool {
+	return os.Getenv("NO_COLOR") != "" || !isTerminal(stderr)
+}
+
+// decorate returns msg as given, or with plainOutput without the emoji
+// and space it starts with. Everything the wrapper prints with an emoji
+// goes through it.
+func decorate(msg string) string {
+	if !plainOutput {
+		return msg
+	}
+	rest := strings.TrimLeftFunc(msg, isEmoji)
+	if rest == msg {
+		return msg
+	}
+	return strings.TrimPrefix(rest, " ")
+}
+
+// isEmoji reports whether r is part of one of the wrapper's emoji,
+// including the variation selector and joiner some of them are made with.
+func isEmoji(r rune) bool {
+	return r == '\ufe0f' || r == '\u200d' || unicode.Is(unicode.So, r)
+}
+
+// newLogger returns a logger for the current logging settings.
+func newLogger() (*slog.Logger, error) {
+	level := slog.LevelWarn
+	if verbose {
+		level = slog.LevelInfo
+	}
+	if logLevel != "" {
+		if err := level.UnmarshalText([]byte(logLevel)); err != nil {
+			return nil, fmt.Errorf("invalid --log-level %q, want debug, info, warn or error", logLevel)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	switch logFormat {
+	case "human":
+		return slog.New(&humanHandler{w: logOutput, level: level}), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(logOutput, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(logOutput, opts)), nil
+	}
+	return nil, fmt.Errorf("invalid --log-format %q, want human, text or json", logFormat)
+}
+
+// logEvent logs msg at info level, with event and attrs as attributes for
+// the text and json formats. run checks the settings first, so a logger
+// that can't be made means nothing is logged.
+func logEvent(event, msg string, attrs ...any) {
+	logger, err := newLogger()
+	if err != nil {
+		return
+	}
+	logger.Info(msg, append([]any{"event", event}, attrs...)...)
+}
+
+// logWarning is logEvent at warn level, which is shown without -v too,
+// for things that went wrong but didn't stop the run.
+func logWarning(event, msg string, attrs ...any) {
+	logger, err := newLogger()
+	if err != nil {
+		return
+	}
+	logger.Warn(msg, append([]any{"event", event}, attrs...)...)
+}
+
+// logError logs msg at error level, for what stopped the wrapper or a
+// subcommand. Until the logging flags have been checked, or if they are
+// invalid, it falls back to the human format so the error isn't lost.
+func logError(event, msg string, attrs ...any) {
+	logger, err := newLogger()
+	if err != nil {
+		logger = slog.New(&humanHandler{w: logOutput, level: slog.LevelError})
+	}
+	logger.Error(msg, append([]any{"event", event}, attrs...)...)
+}
+
+// humanHandler writes just each record's message, after its event's
+// emoji, or a warning sign for warnings and a cross for errors, unless
+// plainOutput is set, one per line.
+type humanHandler struct {
+	w     io.Writer
+	level slog.Leveler
+}
+
+func (h *humanHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *humanHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	switch {
+	case plainOutput:
+	case r.Level >= slog.LevelError:
+		b.WriteString("❌ ")
+	case r.Level >= slog.LevelWarn:
+		b.WriteString("⚠️ ")
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		if r.Level >= slog.LevelWarn {
+			return false
+		}
+		if a.Key != "event" {
+			return true
+		}
+		if emoji, ok := eventEmoji[a.Value.String()]; ok && !plainOutput {
+			b.WriteString(emoji + " ")
+		}
+		return false
+	})
+	b.WriteString(r.Message)
+	if !strings.HasSuffix(r.Message, "\n") {
+		b.WriteString("\n")
+	}
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+func (h *humanHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+
+func (h *humanHandler) WithGroup(string) slog.Handler { return h }
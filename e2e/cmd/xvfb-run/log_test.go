@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+func captureLog(t *testing.T, v bool) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	oldVerbose, oldOutput, oldFormat, oldLevel, oldPlain := verbose, logOutput, logFormat, logLevel, plainOutput
+	verbose, logOutput, plainOutput = v, &buf, false
+	t.Cleanup(func() {
+		verbose, logOutput, logFormat, logLevel, plainOutput = oldVerbose, oldOutput, oldFormat, oldLevel, oldPlain
+	})
+	return &buf
+}
+
+func TestLogEventIsSilentByDefault(t *testing.T) {
+	buf := captureLog(t, false)
+
+	logEvent("xvfb_start", "Starting Xvfb on :99")
+
+	if buf.Len() != 0 {
+		t.Errorf("expected no output, got %q", buf.String())
+	}
+}
+
+func TestLogEventWhenVerbose(t *testing.T) {
+	buf := captureLog(t, true)
+
+	logEvent("xvfb_start", "Starting Xvfb on :99", "display", ":99")
+	logEvent("unknown", "done\n")
+
+	if got := buf.String(); got != "🎬 Starting Xvfb on :99\ndone\n" {
+		t.Errorf("unexpected output %q", got)
+	}
+}
+
+func TestLogEventLevelOverridesVerbose(t *testing.T) {
+	buf := captureLog(t, false)
+	logLevel = "info"
+
+	logEvent("command_start", "Running command: true")
+	if got := buf.String(); got != "🚀 Running command: true\n" {
+		t.Errorf("expected --log-level info to log without -v, got %q", got)
+	}
+
+	buf.Reset()
+	verbose, logLevel = true, "error"
+	logEvent("command_start", "Running command: true")
+	if buf.Len() != 0 {
+		t.Errorf("expected --log-level error to hide info events, got %q", buf.String())
+	}
+}
+
+func TestLogEventJSON(t *testing.T) {
+	buf := captureLog(t, true)
+	logFormat = "json"
+
+	logEvent("command_exit", "Command exited with code 3", "exit_code", 3)
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("expected a JSON record, got %q: %v", buf.String(), err)
+	}
+	if record["level"] != "INFO" || record["msg"] != "Command exited with code 3" || record["event"] != "command_exit" || record["exit_code"] != float64(3) {
+		t.Errorf("unexpected record %v", record)
+	}
+}
+
+func TestLogEventText(t *testing.T) {
+	buf := captureLog(t, true)
+	logFormat = "text"
+
+	logEvent("cleanup", "Stopped Xvfb on :99", "display", ":99")
+
+	if got := buf.String(); !strings.Contains(got, `msg="Stopped Xvfb on :99" event=cleanup display=:99`) {
+		t.Errorf("unexpected output %q", got)
+	}
+}
+
+func TestNewLoggerRejectsBadSettings(t *testing.T) {
+	captureLog(t, false)
+
+	logFormat = "xml"
+	if _, err := newLogger(); err == nil || !strings.Contains(err.Error(), "--log-format") {
+		t.Errorf("expected a --log-format error, got %v", err)
+	}
+	logFormat, logLevel = "human", "loud"
+	if _, err := newLogger(); err == nil || !strings.Contains(err.Error(), "--log-level") {
+		t.Errorf("expected a --log-level error, got %v", err)
+	}
+}
+
+func TestLogEventPlain(t *testing.T) {
+	buf := captureLog(t, true)
+	plainOutput = true
+
+	logEvent("xvfb_start", "Starting Xvfb on :99")
+
+	if got := buf.String(); got != "Starting Xvfb on :99\n" {
+		t.Errorf("expected no emoji, got %q", got)
+	}
+}
+
+func TestDecorate(t *testing.T) {
+	captureLog(t, false)
+
+	if got := decorate("⚠️ --argb: no depth 32"); got != "⚠️ --argb: no depth 32" {
+		t.Errorf("expected the emoji kept on a terminal, got %q", got)
+	}
+	plainOutput = true
+	for msg, want := range map[string]string{
+		"⚠️ --argb: no depth 32":     "--argb: no depth 32",
+		"❌ Command failed: %v\n":     "Command failed: %v\n",
+		"   Connect with DISPLAY=:1": "   Connect with DISPLAY=:1",
+		"Timings:":                   "Timings:",
+	} {
+		if got := decorate(msg); got != want {
+			t.Errorf("decorate(%q) = %q, want %q", msg, got, want)
+		}
+	}
+}
+
+func TestPlainStatus(t *testing.T) {
+	t.Setenv("NO_COLOR", "")
+	if !plainStatus(&bytes.Buffer{}) {
+		t.Error("expected plain output when stderr isn't a terminal")
+	}
+
+	t.Setenv("NO_COLOR", "1")
+	if !plainStatus(os.Stderr) {
+		t.Error("expected NO_COLOR to make output plain")
+	}
+}
+
+func TestLogWarningShowsWithoutVerbose(t *testing.T) {
+	buf := captureLog(t, false)
+
+	logWarning("cleanup", "Couldn't stop Xvfb on :99: operation not permitted", "display", ":99")
+	if got := buf.String(); got != "⚠️ Couldn't stop Xvfb on :99: operation not permitted\n" {
+		t.Errorf("unexpected output %q", got)
+	}
+
+	buf.Reset()
+	plainOutput = true
+	logWarning("cleanup", "Couldn't stop Xvfb on :99")
+	if got := buf.String(); got != "Couldn't stop Xvfb on :99\n" {
+		t.Errorf("expected no emoji, got %q", got)
+	}
+
+	buf.Reset()
+	logLevel = "error"
+	logWarning("cleanup", "Couldn't stop Xvfb on :99")
+	if buf.Len() != 0 {
+		t.Errorf("expected --log-level error to hide warnings, got %q", buf.String())
+	}
+}
+
+func TestLogError(t *testing.T) {
+	buf := captureLog(t, false)
+
+	logLevel = "error"
+	logError("usage", "--dpi must be between 1 and 2000")
+	if got := buf.String(); got != "❌ --dpi must be between 1 and 2000\n" {
+		t.Errorf("unexpected output %q", got)
+	}
+
+	buf.Reset()
+	logFormat = "json"
+	logError("cleanup", "Can't stop Xvfb on :99: no such process", "display", ":99")
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("expected a JSON record, got %q", buf.String())
+	}
+	if record["level"] != "ERROR" || record["event"] != "cleanup" || record["display"] != ":99" {
+		t.Errorf("unexpected record %v", record)
+	}
+
+	buf.Reset()
+	logFormat = "loud"
+	plainOutput = true
+	logError("usage", "invalid --log-format")
+	if got := buf.String(); got != "invalid --log-format\n" {
+		t.Errorf("expected the human format to stand in for an invalid one, got %q", got)
+	}
+}
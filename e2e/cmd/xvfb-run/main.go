@@ -1,62 +1,1107 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
+	"syscall"
+	"time"
+
+	"xvfb-run/pkg/xvfb"
+)
+
+// minDPI and maxDPI bound --dpi to values Xvfb renders sensibly.
+const (
+	minDPI = 48
+	maxDPI = 300
 )
 
-func filterArgs(args []string) []string {
-	cleanedArgs := make([]string, 0, len(args))
-	for _, arg := range args {
-		if arg != "-a" {
-			cleanedArgs = append(cleanedArgs, arg)
+// minNice and maxNice bound --xvfb-nice and --child-nice, as setpriority
+// does.
+const (
+	minNice = -20
+	maxNice = 19
+)
+
+// splitArgs separates the wrapper's flags, as defined in fs, from the
+// command. The first token that isn't one of fs's flags or a flag's value
+// starts the command, and it and everything after it are the command's,
+// so "mytool --verbose" passes --verbose to mytool. An explicit "--" also
+// ends the wrapper's flags and is dropped. A dash-prefixed token before the
+// command that fs doesn't define is an error rather than the command.
+func splitArgs(fs *flag.FlagSet, args []string) (wrapperFlags []string, command []string, err error) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return args[:i], args[i+1:], nil
+		}
+		if len(arg) < 2 || arg[0] != '-' {
+			return args[:i], args[i:], nil
+		}
+		name := strings.TrimPrefix(arg[1:], "-")
+		name, _, hasValue := strings.Cut(name, "=")
+		f := fs.Lookup(name)
+		if f == nil {
+			if name == "h" || name == "help" {
+				// Left for fs.Parse to report as flag.ErrHelp
+				continue
+			}
+			return nil, nil, fmt.Errorf("flag provided but not defined: -%s", name)
+		}
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); hasValue || (ok && b.IsBoolFlag()) {
+			continue
+		}
+		if i+1 == len(args) {
+			return nil, nil, fmt.Errorf("flag needs an argument: -%s", name)
+		}
+		i++
+	}
+	return args, nil, nil
+}
+
+// flagPassed reports whether any of names was set on the command line.
+func flagPassed(fs *flag.FlagSet, names ...string) bool {
+	passed := false
+	fs.Visit(func(f *flag.Flag) {
+		for _, name := range names {
+			if f.Name == name {
+				passed = true
+			}
+		}
+	})
+	return passed
+}
+
+// checkDir makes sure dir exists and is a directory, so a typo in a flag
+// like --workdir is caught before anything is started.
+func checkDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	return nil
+}
+
+// checkFontPath makes sure each directory in a comma-separated font path
+// exists. Other entries, such as font servers, are left to Xvfb.
+func checkFontPath(path string) error {
+	if path == "" {
+		return nil
+	}
+	for _, entry := range strings.Split(path, ",") {
+		if strings.HasPrefix(entry, "/") {
+			if err := checkDir(entry); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// notFoundExitCode is what shells exit with for a missing command.
+const notFoundExitCode = 127
+
+// checkCommand makes sure name can be run before Xvfb is started for it.
+// A bare name is looked up in PATH; a path, relative to workdir if set, only
+// has to exist.
+func checkCommand(name, workdir string) error {
+	if strings.Contains(name, "/") {
+		path := name
+		if workdir != "" && !filepath.IsAbs(path) {
+			path = filepath.Join(workdir, path)
+		}
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("command not found: %s", name)
 		}
+		return nil
 	}
-	return cleanedArgs
+	if _, err := exec.LookPath(name); err != nil {
+		return fmt.Errorf("command not found: %s", name)
+	}
+	return nil
 }
 
+// commandDir is where --cwd-from-arg runs name: the directory it is in if
+// it is a path, or "" to leave a command found in PATH in the wrapper's
+// working directory.
+func commandDir(name string) string {
+	if !strings.Contains(name, "/") {
+		return ""
+	}
+	return filepath.Dir(name)
+}
 
 func main() {
-	args := os.Args[1:]
+	if len(os.Args) > 1 && os.Args[1] == rlimitExecArg {
+		plainOutput = plainStatus(os.Stderr)
+		os.Exit(execWithRlimits(os.Args[2:], os.Stderr))
+	}
+	os.Exit(dispatch(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run is the whole wrapper: it parses args, starts Xvfb, runs the command
+// and returns the status to exit with. The command's output and the
+// wrapper's messages go to stdout and stderr.
+func run(args []string, stdout, stderr io.Writer) int {
+	return wrap(args, stdout, stderr, false)
+}
+
+// wrap is run, except that with detach and --print-display it leaves Xvfb
+// running for "xvfb-run start" instead of holding the display.
+func wrap(args []string, stdout, stderr io.Writer, detach bool) int {
+	started := time.Now()
+	logOutput, plainOutput = stderr, plainStatus(stderr)
 
 	if len(args) == 0 {
-		fmt.Fprintln(os.Stderr, "❌ No command specified to run under Xvfb")
-		os.Exit(1)
+		logError("usage", "No command specified to run under Xvfb")
+		return 1
+	}
+
+	fs := flag.NewFlagSet("xvfb-run", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	autoDisplay := fs.Bool("a", false, "use the first free display number instead of :99")
+	displayBase := fs.Int("display-base", 99, "with -a, the first display number to try")
+	displayMax := fs.Int("display-max", 0, "with -a, the last display number to try (default 99 above --display-base)")
+	serverNum := 99
+	fs.IntVar(&serverNum, "n", 99, "display number to start Xvfb on")
+	fs.IntVar(&serverNum, "server-num", 99, "same as -n")
+	fs.BoolVar(&verbose, "v", false, "print status messages on stderr")
+	fs.BoolVar(&verbose, "verbose", false, "same as -v")
+	fs.StringVar(&logFormat, "log-format", "human", "how to write status messages: human, text or json (key=value or JSON lines from log/slog)")
+	fs.StringVar(&logLevel, "log-level", "", "least severe status messages to show: debug, info, warn or error (default info with -v, warn otherwise)")
+	quiet := false
+	fs.BoolVar(&quiet, "q", false, "don't report a failing command on stderr")
+	fs.BoolVar(&quiet, "quiet", false, "same as -q")
+	serverArgs := ""
+	fs.StringVar(&serverArgs, "s", "", "arguments for Xvfb, replacing the default \"-screen 0 1280x1024x24\"")
+	fs.StringVar(&serverArgs, "server-args", "", "same as -s")
+	serverArgsFile := fs.String("server-args-file", "", "read more Xvfb arguments from this file, one or more per line, # for comments; they go after -s")
+	errorFile := ""
+	fs.StringVar(&errorFile, "e", "", "append Xvfb's output to this file instead of showing it only when Xvfb fails to start")
+	fs.StringVar(&errorFile, "error-file", "", "same as -e")
+	backend := fs.String("backend", xvfb.BackendXvfb, "X server to run: xvfb, or xwayland for Xwayland on a headless Weston (sets WAYLAND_DISPLAY too)")
+	xvfbPath := fs.String("xvfb-path", "", "Xvfb binary to run instead of looking in PATH (default $XVFB_BINARY)")
+	serverBinary := fs.String("server-binary", "", "X server to use when Xvfb isn't installed, e.g. Xephyr or Xvnc")
+	screenshot := fs.String("screenshot-on-failure", "", "if the command fails, save a PNG of the display here")
+	record := fs.String("record", "", "record the session to this video file with ffmpeg")
+	recordFramerate := fs.Int("record-framerate", xvfb.DefaultRecordFramerate, "frames per second for --record")
+	listenTCP := fs.Bool("listen-tcp", false, "let Xvfb accept TCP connections (off by default)")
+	noAccessControl := fs.Bool("disable-access-control", false, "start Xvfb with -ac and no Xauthority cookie, so any client can connect (insecure)")
+	pidFile := fs.String("pidfile", "", "write the wrapper's PID to this file, so a supervisor can signal it; removed on exit")
+	forcePidFile := fs.Bool("force", false, "with --pidfile, take the file over even if its PID is still running")
+	statusFile := fs.String("status-file", "", "once Xvfb is ready, write DISPLAY and XVFB_PID to this file; it is removed on cleanup")
+	dryRun := fs.Bool("dry-run", false, "print the display, Xvfb command line and command that would run, then exit without starting anything")
+	before := fs.String("before", "", "once the display is up, run this shell command and wait for it before the command; if it fails the command isn't run")
+	after := fs.String("after", "", "after the command, run this shell command on the display even if the command failed; its failure is only a warning")
+	afterMustPass := fs.Bool("after-must-pass", false, "with --after, exit with the hook's status if it fails and the command didn't")
+	wm := fs.String("wm", "", "once the display is up, start this window manager, e.g. fluxbox or openbox, or a command running one, before the command; it is stopped at cleanup")
+	mapExit := fs.String("map-exit", "", "rewrite the command's exit code before exiting, as comma-separated OLD:NEW pairs, e.g. 77:0 to count skipped tests as passed")
+	onReady := fs.String("on-ready", "", "once the display is up, start this shell command in the background before the command, e.g. a window manager; it is stopped at cleanup")
+	probe := fs.Bool("probe", false, "before running the command, check with xdpyinfo that the display answers")
+	socketDir := fs.String("socket-dir", "", "directory the X server keeps its lock files and .X11-unix in, instead of /tmp (set as XDG_RUNTIME_DIR for the server)")
+	fontPath := fs.String("fontpath", "", "Xvfb's font path (Xvfb -fp), a directory or comma-separated list, for images whose fonts aren't where Xvfb looks")
+	fbdir := fs.String("fbdir", "", "have Xvfb keep its framebuffers as files in this directory (Xvfb -fbdir)")
+	uid := fs.Int("uid", noID, "run the command as this user ID; Xvfb stays as the wrapper's user (needs root)")
+	gid := fs.Int("gid", noID, "run the command with this group ID (default the --uid user's primary group)")
+	workdir := fs.String("workdir", "", "run the command in this directory")
+	isolateTmp := fs.Bool("isolate-tmp", false, "give the command a private temporary directory as TMPDIR, and --socket-dir as XDG_RUNTIME_DIR; removed on cleanup")
+	cwdFromArg := fs.Bool("cwd-from-arg", false, "run a command given as a path, e.g. ./build/app, in that path's directory; commands from PATH and --workdir are unaffected")
+	rlimitAS := fs.String("rlimit-as", "", "cap the command's address space, e.g. 4G (Linux only)")
+	rlimitNofile := fs.String("rlimit-nofile", "", "cap how many files the command can have open (Linux only)")
+	cpuAffinity := fs.String("cpu-affinity", "", "pin the command to these CPUs, a list like 0,2-3 (Linux only)")
+	stdinMode := fs.String("stdin", "auto", "what the command reads: inherit (the wrapper's stdin), null, or auto (stdin only if it is a terminal)")
+	retries := fs.Int("retries", 0, "if the command fails, run it again on the same display up to this many times (--screenshot-on-failure captures the last attempt)")
+	failFast := fs.Bool("fail-fast", false, "with several commands separated by ---, stop at the first that fails instead of running them all")
+	parallel := fs.Int("parallel", 0, "run the commands separated by --- and from --batch-file up to this many at a time, each on a display of its own, tagging their output lines with [N]")
+	commandFile := fs.String("command-file", "", "read the command from this file instead of the command line: a JSON array of strings, or one argument per line")
+	batchFile := fs.String("batch-file", "", "read more commands from this file, one per line, # for comments")
+	shell := fs.Bool("shell", false, "with no command, read a shell script from stdin and run it with sh -c")
+	outputPath := fs.String("output-file", "", "also copy the command's stdout and stderr, interleaved, into this file")
+	outputAppend := fs.Bool("output-append", false, "with --output-file, append to the file instead of truncating it")
+	noPassthrough := fs.Bool("no-passthrough", false, "with --output-file, send the command's output only to the file")
+	quietOnSuccess := fs.Bool("quiet-child-on-success", false, "hold back the command's output and only show it if the command fails")
+	prefix := fs.String("prefix", "", "start every line the command prints with this tag, e.g. \"[child] \"")
+	var keepOnFailure keepFlag
+	fs.Var(&keepOnFailure, "keep-on-failure", "if the command fails, keep Xvfb up for inspection, for 10m or for =DURATION, or until interrupted")
+	noCleanup := fs.Bool("no-cleanup", false, "for debugging: leave Xvfb running after the command exits (leaks the process)")
+	waitForFree := fs.Duration("wait-for-free", 0, "if the -n display is in use, wait up to this long for it to be free instead of failing")
+	cleanStale := fs.Bool("clean-stale", false, "remove the display's lock file and socket first if the server that made them is gone")
+	showTimings := fs.Bool("timings", false, "when done, print how long Xvfb took to spawn and be ready and how long the command ran (also added to --json)")
+	jsonOutput := fs.Bool("json", false, "when done, print a JSON summary of the run on stderr")
+	reuse := fs.Bool("reuse", false, "if DISPLAY is set, run the command on that display instead of starting Xvfb")
+	preferExisting := fs.Bool("prefer-existing", false, "start Xvfb, but if DISPLAY is set let the command keep it and its XAUTHORITY")
+	mergeAuth := fs.Bool("merge-auth", false, "also add Xvfb's cookie to $XAUTHORITY (default ~/.Xauthority), leaving its other entries alone, and remove it at cleanup; for --prefer-existing")
+	printDisplay := fs.Bool("print-display", false, "instead of running a command, print the display and its Xauthority file and keep Xvfb up until interrupted")
+	healthPort := fs.Int("health-port", 0, "with --print-display, serve /healthz on this port: 200 and the display as JSON while Xvfb is running, 503 once it isn't")
+	var extraEnv envFlag
+	fs.Var(&extraEnv, "env", "set KEY=VALUE in the command's environment (repeatable)")
+	envFile := fs.String("env-file", "", "set the KEY=VALUE lines of this dotenv file in the command's environment (--env still wins)")
+	exportEnv := fs.Bool("export-env", false, "also give the command XVFB_DISPLAY_NUM, XVFB_SOCKET and XAUTHORITY for the display (--env still wins)")
+	glx := fs.Bool("glx", false, "turn on Xvfb's GLX and RENDER extensions, for OpenGL and WebGL")
+	renderNode := fs.String("render-node", "", "point the command's EGL and Mesa at the GPU of this DRI render node, e.g. /dev/dri/renderD128")
+	argb := fs.Bool("argb", false, "give every screen depth 32 with the Composite extension, for ARGB visuals")
+	dpi := fs.Int("dpi", 0, "DPI for Xvfb to report, from 48 to 300 (default: Xvfb's own)")
+	var screenFlags screenFlag
+	fs.Var(&screenFlags, "screen", "screen geometry as [INDEX=]WIDTHxHEIGHTxDEPTH, repeat for more screens (default $XVFB_SCREEN_GEOMETRY or 1280x1024x24)")
+	timeout := fs.Duration("timeout", 0, "kill the command if one attempt at it runs longer than this and exit with 124")
+	maxRuntime := fs.Duration("max-runtime", 0, "budget for the whole run, Xvfb startup and every command and retry included; once used up the command is killed and the wrapper exits with 124")
+	waitTimeout := fs.Duration("wait-timeout", 10*time.Second, "how long to wait for Xvfb to create its display socket")
+	xvfbNice := fs.Int("xvfb-nice", 0, "run Xvfb at this niceness, from -20 to 19 (Linux only; below the wrapper's own needs CAP_SYS_NICE)")
+	childNice := fs.Int("child-nice", 0, "run the command at this niceness, from -20 to 19 (Linux only; below the wrapper's own needs CAP_SYS_NICE)")
+	shutdownTimeout := fs.Duration("shutdown-timeout", 0, "at cleanup, how long Xvfb and the command's processes get to exit after SIGTERM before SIGKILL (default 3s for Xvfb, 2s for the command)")
+	readyCheck := fs.String("ready-check", xvfb.ReadyCheckSocket, "how to tell Xvfb is up: socket (its socket exists) or connect (it answers an X11 handshake)")
+	configFile := fs.String("config", "", "read default flags from this YAML file; flags on the command line and in XVFB_RUN_ARGS win")
+	startAttempts := fs.Int("start-attempts", xvfb.DefaultStartAttempts, "with -a, how many displays to try when another server grabs the chosen one first")
+	showVersion := fs.Bool("version", false, "print the wrapper's version, git commit and build date, then exit")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: xvfb-run [flags] [--] command [args...]")
+		fmt.Fprintln(stderr, "       xvfb-run [flags] [--] command [args...] --- command [args...] ...")
+		fmt.Fprintln(stderr, "       xvfb-run start [flags]")
+		fmt.Fprintln(stderr, "       xvfb-run run :N [flags] [--] command [args...]")
+		fmt.Fprintln(stderr, "       xvfb-run stop :N")
+		fmt.Fprintln(stderr, "       xvfb-run list [--json] [--socket-dir DIR] [DIR]")
+		fmt.Fprintln(stderr, "       xvfb-run clean [--force] [--socket-dir DIR]")
+		fmt.Fprintln(stderr, "       xvfb-run version")
+		fmt.Fprintln(stderr, "\nDefault flags can be put in XVFB_RUN_ARGS. Flags on the command line")
+		fmt.Fprintln(stderr, "override XVFB_RUN_ARGS, which overrides --config, which overrides the")
+		fmt.Fprintln(stderr, "built-in defaults.")
+		fmt.Fprintln(stderr, "\nMessages start with an emoji only when stderr is a terminal and NO_COLOR")
+		fmt.Fprintln(stderr, "isn't set.")
+		fmt.Fprintln(stderr, "\n--timeout bounds each attempt at a command on its own, so with --retries")
+		fmt.Fprintln(stderr, "every attempt gets the full --timeout. --max-runtime bounds the whole run,")
+		fmt.Fprintln(stderr, "counted from when the wrapper starts, and cuts short whichever attempt is")
+		fmt.Fprintln(stderr, "running when it is used up. Both exit with 124.")
+		fmt.Fprintln(stderr, "\nExit status is the command's own, or 128+N if it was killed by signal N,")
+		fmt.Fprintln(stderr, "except when the wrapper itself fails:")
+		fmt.Fprintln(stderr, "  1    anything not listed below")
+		fmt.Fprintln(stderr, "  2    invalid flags or arguments")
+		fmt.Fprintln(stderr, "  3    the X server isn't installed; the message says how to install it,")
+		fmt.Fprintln(stderr, "       and XVFB_RUN_INSTALL_HINT replaces the suggested command")
+		fmt.Fprintln(stderr, "  4    the X server failed to start")
+		fmt.Fprintln(stderr, "  5    the display wasn't ready within --wait-timeout")
+		fmt.Fprintln(stderr, "  124  --timeout or --max-runtime ran out")
+		fmt.Fprintln(stderr, "  127  the command wasn't found")
+		fmt.Fprintln(stderr)
+		fs.PrintDefaults()
+	}
+
+	// Defaults from the environment go first so the command line wins
+	if err := fs.Parse(argsFromEnv()); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		logError("usage", "Invalid XVFB_RUN_ARGS: "+err.Error(), "error", err.Error())
+		return 2
+	}
+	if fs.NArg() > 0 {
+		logError("usage", fmt.Sprintf("XVFB_RUN_ARGS can only contain flags, found %q", fs.Arg(0)))
+		return 2
+	}
+	envScreens := len(screenFlags)
+	wrapperFlags, cleanedArgs, err := splitArgs(fs, args)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		fs.Usage()
+		return 2
+	}
+	if err := fs.Parse(wrapperFlags); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	if *showVersion {
+		writeVersion(stdout, buildInfo())
+		return 0
+	}
+	if len(screenFlags) > envScreens {
+		// Screens given on the command line replace those from the
+		// environment rather than adding to them
+		screenFlags = screenFlags[envScreens:]
+	}
+	if *configFile != "" {
+		opts, err := loadConfig(*configFile)
+		if err == nil {
+			err = applyConfig(fs, opts)
+		}
+		if err != nil {
+			logError("usage", "Invalid --config: "+err.Error(), "error", err.Error())
+			return 2
+		}
+	}
+
+	if *commandFile != "" {
+		if len(cleanedArgs) > 0 {
+			logError("usage", "--command-file can't be used with a command on the command line")
+			return 2
+		}
+		if cleanedArgs, err = readCommandFile(*commandFile); err != nil {
+			logError("usage", "Can't read --command-file: "+err.Error(), "error", err.Error())
+			return 2
+		}
+	}
+	if *printDisplay {
+		if len(cleanedArgs) > 0 && detach {
+			logError("usage", "xvfb-run start doesn't take a command, use xvfb-run run :N -- command")
+			return 2
+		}
+		if len(cleanedArgs) > 0 {
+			logError("usage", "--print-display doesn't take a command")
+			return 2
+		}
+	}
+	if *healthPort != 0 && (!*printDisplay || detach) {
+		logError("usage", "--health-port only works while --print-display holds the display")
+		return 2
+	}
+	if *healthPort < 0 || *healthPort > 65535 {
+		logError("usage", "--health-port must be a port from 1 to 65535")
+		return 2
+	}
+	if !*printDisplay && len(cleanedArgs) == 0 && !*shell && *batchFile == "" {
+		logError("usage", "No valid command after removing flags")
+		return 1
+	}
+	if *parallel < 0 {
+		logError("usage", "--parallel must be at least 1")
+		return 2
+	}
+	if *parallel > 0 {
+		for _, name := range batchIncompatible {
+			if flagPassed(fs, name) {
+				logError("usage", fmt.Sprintf("--parallel can't be used with -%s", name))
+				return 2
+			}
+		}
+		// Every command gets a display of its own
+		*autoDisplay = true
+	}
+
+	if _, err := newLogger(); err != nil {
+		logError("usage", err.Error())
+		return 2
+	}
+	if *backend != xvfb.BackendXvfb && *backend != xvfb.BackendXwayland {
+		logError("usage", fmt.Sprintf("invalid --backend %q, want xvfb or xwayland", *backend))
+		return 2
+	}
+	if *readyCheck != xvfb.ReadyCheckSocket && *readyCheck != xvfb.ReadyCheckConnect {
+		logError("usage", fmt.Sprintf("invalid --ready-check %q, want socket or connect", *readyCheck))
+		return 2
+	}
+	if err := checkStdinMode(*stdinMode); err != nil {
+		logError("usage", err.Error())
+		return 2
+	}
+	if *autoDisplay && flagPassed(fs, "n", "server-num") {
+		logError("usage", "-a and -n can't be used together")
+		return 2
+	}
+	if *autoDisplay && *waitForFree != 0 {
+		logError("usage", "--wait-for-free needs a fixed display, not -a")
+		return 2
+	}
+	if *maxRuntime < 0 {
+		logError("usage", "--max-runtime can't be negative")
+		return 2
+	}
+	if *outputPath == "" && (*outputAppend || *noPassthrough) {
+		logError("usage", "--output-append and --no-passthrough need --output-file")
+		return 2
+	}
+	if *afterMustPass && *after == "" {
+		logError("usage", "--after-must-pass needs --after")
+		return 2
+	}
+	if *retries < 0 {
+		logError("usage", "--retries can't be negative")
+		return 2
+	}
+	if *waitForFree < 0 {
+		logError("usage", "--wait-for-free can't be negative")
+		return 2
+	}
+	if !*autoDisplay && flagPassed(fs, "display-base", "display-max") {
+		logError("usage", "--display-base and --display-max need -a")
+		return 2
+	}
+	if *displayBase < 1 {
+		logError("usage", "--display-base must be at least 1")
+		return 2
+	}
+	if *noAccessControl {
+		if *listenTCP {
+			logWarning("usage", "--disable-access-control with --listen-tcp: anyone who can reach this host over the network can connect to the display")
+		} else {
+			logWarning("usage", "--disable-access-control: any local client can connect to the display")
+		}
+	}
+	if flagPassed(fs, "display-max") && *displayMax < *displayBase {
+		logError("usage", "--display-max can't be below --display-base")
+		return 2
+	}
+	if *startAttempts < 1 {
+		logError("usage", "--start-attempts must be at least 1")
+		return 2
+	}
+	if *shutdownTimeout < 0 {
+		logError("usage", "--shutdown-timeout can't be negative")
+		return 2
+	}
+	if *timeout < 0 {
+		logError("usage", "--timeout can't be negative")
+		return 2
+	}
+	if *recordFramerate < 1 {
+		logError("usage", "--record-framerate must be at least 1")
+		return 2
+	}
+	if flagPassed(fs, "dpi") && (*dpi < minDPI || *dpi > maxDPI) {
+		logError("usage", fmt.Sprintf("--dpi must be between %d and %d", minDPI, maxDPI))
+		return 2
+	}
+	for _, nice := range []struct {
+		name  string
+		value int
+	}{{"xvfb-nice", *xvfbNice}, {"child-nice", *childNice}} {
+		if nice.value < minNice || nice.value > maxNice {
+			logError("usage", fmt.Sprintf("--%s must be between %d and %d", nice.name, minNice, maxNice))
+			return 2
+		}
+		if nice.value != 0 && runtime.GOOS != "linux" {
+			logError("usage", fmt.Sprintf("--%s is only supported on Linux", nice.name))
+			return 2
+		}
+	}
+	if serverNum < 0 {
+		logError("usage", fmt.Sprintf("Invalid display number: %v", serverNum))
+		return 2
 	}
 
-	// Remove the -a flag if present
-	cleanedArgs := filterArgs(args)
+	cred, err := credential(*uid, *gid)
+	if err != nil {
+		logError("usage", err.Error())
+		return 2
+	}
 
-	if len(cleanedArgs) == 0 {
-		fmt.Fprintln(os.Stderr, "❌ No valid command after removing flags")
-		os.Exit(1)
+	screens, err := parseScreens(screenFlags)
+	if err != nil {
+		logError("usage", "Invalid --screen: "+err.Error(), "error", err.Error())
+		return 2
 	}
 
-	// Start Xvfb on display :99
-	display := ":99"
-	xvfbCmd := exec.Command("Xvfb", display, "-screen", "0", "1280x1024x24")
-	xvfbCmd.Stdout = os.Stdout
-	xvfbCmd.Stderr = os.Stderr
+	if *workdir != "" {
+		if err := checkDir(*workdir); err != nil {
+			logError("usage", "Invalid --workdir: "+err.Error(), "error", err.Error())
+			return 2
+		}
+	}
 
-	fmt.Println("🎬 Starting Xvfb on", display)
-	if err := xvfbCmd.Start(); err != nil {
-		fmt.Fprintln(os.Stderr, "❌ Failed to start Xvfb:", err)
-		os.Exit(1)
+	// The script uses up stdin, so the command gets none
+	commandStdin := resolveStdin(*stdinMode)
+	if *shell && len(cleanedArgs) == 0 && !*printDisplay {
+		script, err := readScript(stdin)
+		if err != nil {
+			logError("usage", "--shell: "+err.Error(), "error", err.Error())
+			return 1
+		}
+		cleanedArgs, commandStdin = []string{"sh", "-c", script}, nil
 	}
-	defer xvfbCmd.Process.Kill()
 
-	// Prepare the command to run
-	fmt.Println("🚀 Running command:", strings.Join(cleanedArgs, " "))
-	cmd := exec.Command(cleanedArgs[0], cleanedArgs[1:]...)
-	cmd.Env = append(os.Environ(), "DISPLAY="+display)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Stdin = os.Stdin
+	var fileServerArgs []string
+	if *serverArgsFile != "" {
+		fileServerArgs, err = readArgsFile(*serverArgsFile)
+		if err != nil {
+			logError("usage", "Can't read --server-args-file: "+err.Error(), "error", err.Error())
+			return 2
+		}
+	}
 
-	if err := cmd.Run(); err != nil {
-		fmt.Fprintln(os.Stderr, "❌ Command failed:", err)
-		os.Exit(1)
+	if *socketDir != "" {
+		if err := checkDir(*socketDir); err != nil {
+			logError("usage", "Invalid --socket-dir: "+err.Error(), "error", err.Error())
+			return 2
+		}
+	}
+	if err := checkFontPath(*fontPath); err != nil {
+		logError("usage", "Invalid --fontpath: "+err.Error(), "error", err.Error())
+		return 2
+	}
+	if *fbdir != "" {
+		if err := checkDir(*fbdir); err != nil {
+			logError("usage", "Invalid --fbdir: "+err.Error(), "error", err.Error())
+			return 2
+		}
+	}
+
+	var commands [][]string
+	if len(cleanedArgs) > 0 {
+		if commands, err = splitCommands(cleanedArgs); err != nil {
+			logError("usage", err.Error())
+			return 2
+		}
+	}
+	if *batchFile != "" {
+		more, err := readCommandsFile(*batchFile)
+		if err != nil {
+			logError("usage", "Can't read --batch-file: "+err.Error(), "error", err.Error())
+			return 2
+		}
+		if len(more) == 0 && len(commands) == 0 {
+			logError("usage", "No commands in --batch-file")
+			return 1
+		}
+		commands = append(commands, more...)
+	}
+	for _, command := range commands {
+		if err := checkCommand(command[0], *workdir); err != nil {
+			logError("usage", err.Error())
+			return notFoundExitCode
+		}
+	}
+	if *workdir != "" {
+		*cwdFromArg = false
+	}
+	if *cwdFromArg {
+		// A relative path would otherwise be looked up again from its
+		// own directory
+		for _, command := range commands {
+			if commandDir(command[0]) != "" {
+				if command[0], err = filepath.Abs(command[0]); err != nil {
+					logError("usage", err.Error())
+					return 1
+				}
+			}
+		}
+	}
+	limits, err := parseRlimits(*rlimitAS, *rlimitNofile)
+	if err != nil {
+		logError("usage", err.Error())
+		return 2
+	}
+	if len(limits) > 0 && runtime.GOOS != "linux" {
+		logError("usage", "--rlimit-as and --rlimit-nofile are only supported on Linux")
+		return 2
+	}
+	var cpus []int
+	if *cpuAffinity != "" {
+		if runtime.GOOS != "linux" {
+			logError("usage", "--cpu-affinity is only supported on Linux")
+			return 2
+		}
+		if cpus, err = parseCPUList(*cpuAffinity); err != nil {
+			logError("usage", "Invalid --cpu-affinity: "+err.Error(), "error", err.Error())
+			return 2
+		}
+	}
+	var exitMap map[int]int
+	if *mapExit != "" {
+		if exitMap, err = parseExitMap(*mapExit); err != nil {
+			logError("usage", "Invalid --map-exit: "+err.Error(), "error", err.Error())
+			return 2
+		}
+	}
+	var mergeAuthFile string
+	if *mergeAuth {
+		if mergeAuthFile, err = userAuthFile(); err != nil {
+			logError("usage", "--merge-auth: "+err.Error(), "error", err.Error())
+			return 2
+		}
+	}
+	if *wm != "" {
+		if err := checkWindowManager(*wm); err != nil {
+			logError("usage", "Invalid --wm: "+err.Error(), "error", err.Error())
+			return 2
+		}
+	}
+	if *envFile != "" {
+		fileEnv, err := parseEnvFile(*envFile)
+		if err != nil {
+			logError("usage", "Can't read --env-file: "+err.Error(), "error", err.Error())
+			return 2
+		}
+		extraEnv = append(fileEnv, extraEnv...)
+	}
+	if *renderNode != "" {
+		if err := checkRenderNode(*renderNode); err != nil {
+			logError("usage", "Invalid --render-node: "+err.Error(), "error", err.Error())
+			return 2
+		}
+		// --env still wins
+		extraEnv = append(renderNodeEnv(*renderNode), extraEnv...)
+	}
+
+	// Resolved once, so --parallel warns about a bad XVFB_SCREEN_GEOMETRY
+	// once rather than for every command
+	geometry := ""
+	if serverArgs == "" && len(fileServerArgs) == 0 && len(screens) == 0 {
+		geometry = resolveGeometry("", os.Getenv("XVFB_SCREEN_GEOMETRY"))
+	}
+
+	// Start Xvfb on the requested display, or the first free one with -a.
+	// --parallel makes one Runner like this for every command.
+	newRunner := func() *xvfb.Runner {
+		runner := &xvfb.Runner{
+			Stdin:                commandStdin,
+			Stdout:               stdout,
+			Stderr:               stderr,
+			Dir:                  *workdir,
+			Backend:              *backend,
+			XvfbPath:             resolveXvfbPath(*xvfbPath),
+			ServerBinary:         *serverBinary,
+			ListenTCP:            *listenTCP,
+			DisableAccessControl: *noAccessControl,
+			DPI:                  *dpi,
+			FontPath:             *fontPath,
+			FramebufferDir:       *fbdir,
+			SocketDir:            *socketDir,
+			DisplayBase:          *displayBase,
+			DisplayMax:           *displayMax,
+			Credential:           cred,
+			CleanStale:           *cleanStale,
+			WaitForFree:          *waitForFree,
+			Screens:              screens,
+			ScreenGeometry:       geometry,
+			Timeout:              *timeout,
+			ReadyTimeout:         *waitTimeout,
+			ShutdownTimeout:      *shutdownTimeout,
+			ServerNice:           *xvfbNice,
+			CommandNice:          *childNice,
+			GLX:                  *glx,
+			ReadyCheck:           *readyCheck,
+			KeepDisplay:          *preferExisting,
+			MergeAuthFile:        mergeAuthFile,
+			StartAttempts:        *startAttempts,
+			Logf: func(format string, args ...any) {
+				logEvent("xvfb_start", fmt.Sprintf(format, args...))
+			},
+		}
+		if *argb {
+			runner.Depth = xvfb.ARGBDepth
+		}
+		if len(extraEnv) > 0 {
+			runner.Env = mergeEnv(os.Environ(), extraEnv)
+		}
+		if !*autoDisplay {
+			runner.Display = xvfb.DisplayString(serverNum)
+		}
+		if serverArgs != "" || len(fileServerArgs) > 0 {
+			runner.ServerArgs = append(parseServerArgs(serverArgs), fileServerArgs...)
+		}
+		return runner
+	}
+	runner := newRunner()
+	childOut, childErr := stdout, stderr
+	var heldOutput *bufferedPassthrough
+	if *quietOnSuccess {
+		heldOutput = newBufferedPassthrough(quietOutputLimit)
+		childOut, childErr = heldOutput.writer(stdout), heldOutput.writer(stderr)
+		runner.Stdout, runner.Stderr = childOut, childErr
+	}
+	flushOutput := func() {}
+	if *prefix != "" {
+		prefixedOut, prefixedErr := newPrefixWriter(childOut, *prefix), newPrefixWriter(childErr, *prefix)
+		runner.Stdout, runner.Stderr = prefixedOut, prefixedErr
+		flushOutput = func() {
+			prefixedOut.Flush()
+			prefixedErr.Flush()
+		}
+	}
+
+	if *dryRun {
+		// With --reuse and DISPLAY set there is no server to start
+		display, server := os.Getenv("DISPLAY"), []string(nil)
+		if !*reuse || display == "" {
+			display, server, err = runner.Plan()
+		}
+		if err != nil {
+			logError("setup", "Can't plan the run: "+err.Error(), "error", err.Error())
+			return 1
+		}
+		printDryRun(stdout, display, server, extraEnv, commands...)
+		return 0
+	}
+
+	if *isolateTmp {
+		dir, cleanup, err := setupIsolatedTmp()
+		if err != nil {
+			logError("setup", "Can't create --isolate-tmp directory: "+err.Error(), "error", err.Error())
+			return 1
+		}
+		if cred != nil {
+			if err := os.Chown(dir, int(cred.Uid), int(cred.Gid)); err != nil {
+				cleanup()
+				logError("setup", "Can't give the --isolate-tmp directory to --uid: "+err.Error(), "error", err.Error())
+				return 1
+			}
+		}
+		defer func() {
+			// --no-cleanup leaves the command's files for debugging too
+			if !*noCleanup {
+				cleanup()
+			}
+		}()
+		// --env still wins
+		extraEnv = append(isolatedTmpEnv(dir, *socketDir), extraEnv...)
+		runner.Env = mergeEnv(os.Environ(), extraEnv)
+	}
+
+	if *pidFile != "" {
+		if err := writePidFile(*pidFile, os.Getpid(), *forcePidFile); err != nil {
+			logError("setup", "Can't write --pidfile: "+err.Error(), "error", err.Error())
+			return 1
+		}
+		defer removePidFile(*pidFile, os.Getpid())
+	}
+
+	xvfbLog, closeXvfbLog, err := newXvfbLogWriter(errorFile)
+	if err != nil {
+		logError("setup", "Can't open --error-file: "+err.Error(), "error", err.Error())
+		return 2
+	}
+	runner.ServerOutput = xvfbLog
+
+	if *parallel > 0 {
+		// Commands and their Runners write from several goroutines at once
+		out, errOut := &lockedWriter{w: stdout}, &lockedWriter{w: stderr}
+		logOutput = errOut
+		code := runBatch(commands, *parallel, func() *xvfb.Runner {
+			r := newRunner()
+			r.Stdout, r.Stderr, r.ServerOutput = out, errOut, xvfbLog
+			return r
+		}, func(r *xvfb.Runner, command []string) error {
+			dir := r.Dir
+			if *cwdFromArg {
+				dir = commandDir(command[0])
+			}
+			args := command
+			if len(limits) > 0 || len(cpus) > 0 {
+				var err error
+				if args, err = rlimitCommand(limits, cpus, command); err != nil {
+					return err
+				}
+			}
+			_, err := runWithRetries(context.Background(), r, dir, args, *retries)
+			return err
+		}, exitMap, quiet)
+		closeXvfbLog()
+		return code
+	}
+
+	if *outputPath != "" {
+		output, err := openOutputFile(*outputPath, *outputAppend)
+		if err != nil {
+			closeXvfbLog()
+			logError("setup", "Can't open --output-file: "+err.Error(), "error", err.Error())
+			return 2
+		}
+		defer func() {
+			if err := output.Close(); err != nil {
+				logWarning("cleanup", "Couldn't write --output-file: "+err.Error(), "error", err.Error())
+			}
+		}()
+		if *noPassthrough {
+			runner.Stdout, runner.Stderr = output.tee(nil), output.tee(nil)
+		} else {
+			runner.Stdout, runner.Stderr = output.tee(runner.Stdout), output.tee(runner.Stderr)
+		}
+	}
+
+	var res result
+	var timer phaseTimer
+	report := func(code int) int {
+		if *showTimings {
+			timer.write(stderr)
+			res.Timings = timer.milliseconds()
+		}
+		if *jsonOutput {
+			res.ExitCode = code
+			writeResult(stderr, res, started)
+		}
+		return code
+	}
+	// A server that can't be stopped may be left running, which is worth
+	// a warning and a count in --json rather than silence
+	stopServer := func() error {
+		err := runner.Stop()
+		if err != nil {
+			res.TeardownErrors++
+			logWarning("cleanup", fmt.Sprintf("Couldn't stop Xvfb on %s: %v", res.Display, err), "display", res.Display, "error", err.Error())
+		}
+		return err
+	}
+
+	// --max-runtime counts Xvfb's startup too
+	ctx := context.Background()
+	if *maxRuntime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, started.Add(*maxRuntime))
+		defer cancel()
+	}
+
+	// Signals are handled from before Xvfb starts until teardown is done,
+	// so one that comes at any point still cleans up after the wrapper
+	startCtx, cancelStart := context.WithCancel(ctx)
+	defer cancelStart()
+	stopSignals := setupSignalHandling(startingSession{runner, cancelStart}, signalGracePeriod)
+	defer stopSignals()
+
+	if display := os.Getenv("DISPLAY"); *reuse && display != "" {
+		// Someone else's server: Stop below leaves it running
+		if err := runner.Attach(display, os.Getenv("XAUTHORITY")); err != nil {
+			logError("display_reuse", "Can't reuse display: "+err.Error(), "error", err.Error())
+			res.Error = err.Error()
+			return report(1)
+		}
+		logEvent("display_reuse", fmt.Sprintf("Reusing display %s", display), "display", display)
+	} else if err := runner.StartContext(startCtx); err != nil {
+		closeXvfbLog()
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("%w: %w", errMaxRuntime, err)
+		}
+		res.Error = err.Error()
+		if sig := caughtSignal.Load(); sig != 0 {
+			return report(128 + int(sig))
+		}
+		// Start checks for the binary once the display is settled, so a
+		// display already in use is still reported as such
+		if errors.Is(err, xvfb.ErrServerNotFound) {
+			reportMissingServer(*backend, err)
+			return report(exitCode(err))
+		}
+		msg := "Failed to start Xvfb: " + err.Error()
+		if errorFile != "" {
+			msg += "\nXvfb's full output is in " + errorFile
+		}
+		logError("xvfb_start", msg, "error", err.Error())
+		return report(exitCode(err))
+	}
+	res.Display, res.XvfbPID = runner.ActiveDisplay(), runner.ServerPID()
+	if display := os.Getenv("DISPLAY"); *preferExisting && display != "" && res.XvfbPID != 0 {
+		logEvent("display_reuse", fmt.Sprintf("Keeping DISPLAY=%s for the command, Xvfb is on %s", display, res.Display), "display", display)
+	}
+	if res.XvfbPID != 0 {
+		timings := runner.StartTimings()
+		timer.record("xvfb_spawn_ms", timings.Spawn)
+		timer.record("display_ready_ms", timings.Ready)
+		logEvent("display_ready", fmt.Sprintf("Display %s is ready (Xvfb PID %d)", res.Display, res.XvfbPID), "display", res.Display, "xvfb_pid", res.XvfbPID)
+	}
+	if *exportEnv {
+		runner.Env = mergeEnv(childEnv(os.Environ(), newSession(runner), *preferExisting), extraEnv)
+	}
+	res.Framebuffers = runner.FramebufferFiles()
+	for _, path := range res.Framebuffers {
+		logEvent("framebuffer", fmt.Sprintf("Framebuffer in %s", path), "path", path)
+	}
+
+	if *probe {
+		if err := runner.Probe(0, 0); err != nil {
+			logError("display_probe", "Display isn't usable: "+err.Error(), "error", err.Error())
+			stopServer()
+			closeXvfbLog()
+			res.Error = err.Error()
+			return report(1)
+		}
+		logEvent("display_probe", fmt.Sprintf("Display %s answers X requests", res.Display), "display", res.Display)
+	}
+	if *argb {
+		// Not every X server build can do depth 32
+		if depths, err := runner.Depths(); err != nil {
+			logWarning("display_ready", "--argb: couldn't check the display's depths: "+err.Error(), "error", err.Error())
+		} else if !slices.Contains(depths, xvfb.ARGBDepth) {
+			logWarning("display_ready", fmt.Sprintf("--argb: the display has no depth %d, only %v", xvfb.ARGBDepth, depths))
+		}
+	}
+
+	removeStatusFile := func() {}
+	if *statusFile != "" {
+		if err := writeStatusFile(*statusFile, res.Display, res.XvfbPID); err != nil {
+			logError("setup", "Can't write --status-file: "+err.Error(), "error", err.Error())
+			stopServer()
+			closeXvfbLog()
+			res.Error = err.Error()
+			return report(1)
+		}
+		removeStatusFile = func() { os.Remove(*statusFile) }
+	}
+
+	if *printDisplay && detach {
+		if err := detachDisplay(stdout, runner); err != nil {
+			logError("setup", "Can't record the display for xvfb-run stop: "+err.Error(), "error", err.Error())
+			stopServer()
+			closeXvfbLog()
+			res.Error = err.Error()
+			return report(1)
+		}
+		return report(0)
+	}
+	if *printDisplay {
+		stopHealth := func() {}
+		if *healthPort != 0 {
+			pid, socket := runner.ServerPID(), runner.SocketPath()
+			handler := healthHandler(runner.ActiveDisplay(), pid, func() bool { return serverAlive(pid, socket) })
+			if stopHealth, err = startHealthServer(*healthPort, handler); err != nil {
+				logError("health", "Can't serve --health-port: "+err.Error(), "error", err.Error())
+				stopServer()
+				removeStatusFile()
+				closeXvfbLog()
+				res.Error = err.Error()
+				return report(1)
+			}
+			logEvent("health", fmt.Sprintf("Serving /healthz on port %d", *healthPort), "port", *healthPort)
+		}
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+		holdDisplay(stdout, runner.ActiveDisplay(), runner.AuthFile(), sigs)
+		stopHealth()
+		stopServer()
+		removeStatusFile()
+		closeXvfbLog()
+		return report(0)
+	}
+
+	// Hooks get the command's environment, DISPLAY aside
+	hookBase := runner.Env
+	if hookBase == nil {
+		hookBase = os.Environ()
+	}
+	stopWM := func() {}
+	if *wm != "" {
+		manager, err := startWindowManager(newSession(runner), *wm, hookBase, stderr)
+		if err != nil {
+			logError("wm_start", "Can't start --wm: "+err.Error(), "error", err.Error())
+			stopServer()
+			removeStatusFile()
+			closeXvfbLog()
+			res.Error = err.Error()
+			return report(1)
+		}
+		stopWM = manager.stop
+	}
+	stopHook := func() {}
+	if *onReady != "" {
+		hook, err := runReadyHook(newSession(runner), *onReady, hookBase, stderr)
+		if err != nil {
+			logError("ready_hook", "Can't run --on-ready: "+err.Error(), "error", err.Error())
+			stopWM()
+			stopServer()
+			removeStatusFile()
+			closeXvfbLog()
+			res.Error = err.Error()
+			return report(1)
+		}
+		stopHook = func() { stopReadyHook(hook) }
+	}
+
+	stopRecording := func() error { return nil }
+	if *record != "" {
+		stop, err := runner.Record(*record, *recordFramerate)
+		if err != nil {
+			logWarning("recording_start", "Couldn't start recording: "+err.Error(), "error", err.Error())
+		} else {
+			logEvent("recording_start", fmt.Sprintf("Recording to %s", *record), "path", *record)
+			stopRecording = stop
+			res.Recording = *record
+		}
+	}
+
+	var beforeErr error
+	if *before != "" && caughtSignal.Load() == 0 {
+		beforeErr = runHook("before", newSession(runner), *before, hookBase, runner.Stdout, runner.Stderr)
+	}
+	commandStarted := time.Now()
+	attempts, err := 0, beforeErr
+	if err == nil {
+		attempts, err = runSequence(ctx, runner, commands, *failFast, *retries, limits, cpus, *cwdFromArg)
+	}
+	if *retries > 0 {
+		res.Attempts = attempts
+	}
+	timer.record("command_ms", time.Since(commandStarted))
+	if *after != "" && caughtSignal.Load() == 0 {
+		// The command failing is no reason to skip teardown
+		if afterErr := runHook("after", newSession(runner), *after, hookBase, runner.Stdout, runner.Stderr); afterErr != nil {
+			if *afterMustPass && err == nil {
+				err = afterErr
+			} else {
+				logWarning("hook_run", afterErr.Error())
+			}
+		}
+	}
+	flushOutput()
+	if heldOutput != nil {
+		if err != nil || caughtSignal.Load() != 0 {
+			heldOutput.Flush()
+		} else {
+			heldOutput.Discard()
+		}
+	}
+	if err := stopRecording(); err != nil {
+		logWarning("recording_stop", "Recording failed: "+err.Error(), "error", err.Error())
+		res.Recording = ""
+	}
+	if err != nil && *screenshot != "" && caughtSignal.Load() == 0 {
+		if err := runner.Screenshot(*screenshot); err != nil {
+			logWarning("screenshot", "Couldn't take a screenshot: "+err.Error(), "error", err.Error())
+		} else {
+			logEvent("screenshot", fmt.Sprintf("Saved screenshot to %s", *screenshot), "path", *screenshot)
+			res.Screenshot = *screenshot
+		}
+	}
+	if err != nil && keepOnFailure != 0 && caughtSignal.Load() == 0 && runner.ServerPID() != 0 {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+		holdForInspection(stderr, runner.ActiveDisplay(), runner.AuthFile(), time.Duration(keepOnFailure), sigs)
+		signal.Stop(sigs)
+	}
+	if *noCleanup && caughtSignal.Load() == 0 && runner.ServerPID() != 0 {
+		// The window manager and --on-ready hook stay up with the display
+		// they were started on
+		display, pid, authFile := runner.ActiveDisplay(), runner.ServerPID(), runner.AuthFile()
+		runner.Detach()
+		logWarning("cleanup", fmt.Sprintf("--no-cleanup: Xvfb is still running on %s (PID %d) and is not cleaned up.\n"+
+			"   Connect with DISPLAY=%s XAUTHORITY=%s, and kill %d when done.", display, pid, display, authFile, pid), "display", display, "xvfb_pid", pid)
+	} else {
+		stopHook()
+		stopWM()
+		stopped := stopServer() == nil
+		removeStatusFile()
+		if stopped && res.XvfbPID != 0 {
+			logEvent("cleanup", fmt.Sprintf("Stopped Xvfb on %s", res.Display), "display", res.Display)
+		}
+	}
+	closeXvfbLog()
+
+	if sig := caughtSignal.Load(); sig != 0 {
+		return report(128 + int(sig))
+	}
+	// A failure mapped to 0 is a success for whoever reads the exit code
+	code := mapExitCode(exitMap, exitCode(err))
+	if err != nil {
+		if !quiet && code != 0 {
+			if errors.Is(err, errMaxRuntime) {
+				logError("command_failed", fmt.Sprintf("--max-runtime of %s used up, the command was killed", *maxRuntime))
+			} else if errors.Is(err, errHook) {
+				logError("command_failed", err.Error())
+			} else if errors.Is(err, xvfb.ErrTimeout) {
+				logError("command_failed", fmt.Sprintf("Command timed out after %s and was killed", *timeout))
+			} else {
+				logError("command_failed", "Command failed: "+err.Error(), "error", err.Error())
+			}
+		}
+		res.Error = err.Error()
 	}
+	return report(code)
 }
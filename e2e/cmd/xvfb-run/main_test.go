@@ -1,29 +1,42 @@
  package main
 
  import (
+ 	"encoding/json"
+ 	"flag"
+ 	"fmt"
  	"os"
  	"os/exec"
  	"path/filepath"
+ 	"runtime"
+ 	"slices"
+ 	"strconv"
  	"strings"
  	"testing"
+ 	"time"
  )
 
- func TestFilterArgsWithOnlyDashA(t *testing.T) {
+ func TestSplitArgsWithOnlyDashA(t *testing.T) {
  	args := []string{"-a"}
  	expected := []string{}
 
- 	cleaned := filterArgs(args)
+ 	_, cleaned, err := splitArgs(splitFlagSet(), args)
+ 	if err != nil {
+ 		t.Fatal(err)
+ 	}
 
  	if len(cleaned) != len(expected) {
  		t.Fatalf("expected %d args, got %d", len(expected), len(cleaned))
  	}
  }
 
- func TestRemovesDashAFlag(t *testing.T) {
+ func TestSplitArgsRemovesDashAFlag(t *testing.T) {
  	args := []string{"-a", "echo", "Hello"}
  	expected := []string{"echo", "Hello"}
 
- 	cleaned := filterArgs(args)
+ 	_, cleaned, err := splitArgs(splitFlagSet(), args)
+ 	if err != nil {
+ 		t.Fatal(err)
+ 	}
 
  	if len(cleaned) != len(expected) {
  		t.Fatalf("expected %d args, got %d", len(expected), len(cleaned))
@@ -36,7 +49,7 @@
  }
 
  func TestNoCommandProvided(t *testing.T) {
- 	cmd := exec.Command("go", "run", "main.go")
+ 	cmd := exec.Command("go", "run", ".")
  	output, err := cmd.CombinedOutput()
 
  	if err == nil {
@@ -48,7 +61,7 @@
  }
 
  func TestOnlyDashAProvided(t *testing.T) {
- 	cmd := exec.Command("go", "run", "main.go", "-a")
+ 	cmd := exec.Command("go", "run", ".", "-a")
  	output, err := cmd.CombinedOutput()
 
  	if err == nil {
@@ -60,10 +73,13 @@
  }
 
  func TestMultipleDashAFlags(t *testing.T) {
- 	args := []string{"-a", "echo", "-a", "Hello", "-a"}
- 	expected := []string{"echo", "Hello"}
+ 	args := []string{"-a", "-a", "echo", "-a", "Hello", "-a"}
+ 	expected := []string{"echo", "-a", "Hello", "-a"}
 
- 	cleaned := filterArgs(args)
+ 	_, cleaned, err := splitArgs(splitFlagSet(), args)
+ 	if err != nil {
+ 		t.Fatal(err)
+ 	}
 
  	if len(cleaned) != len(expected) {
  		t.Fatalf("expected %d args, got %d", len(expected), len(cleaned))
@@ -75,6 +91,98 @@
  	}
  }
 
+func TestKeepsDashAAfterCommand(t *testing.T) {
+	args := []string{"-a", "mytool", "-a", "x"}
+	expected := []string{"mytool", "-a", "x"}
+
+	_, cleaned, err := splitArgs(splitFlagSet(), args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cleaned) != len(expected) {
+		t.Fatalf("expected %d args, got %d", len(expected), len(cleaned))
+	}
+	for i, v := range expected {
+		if cleaned[i] != v {
+			t.Errorf("expected %s at position %d, got %s", v, i, cleaned[i])
+		}
+	}
+}
+
+func splitFlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("xvfb-run", flag.ContinueOnError)
+	fs.Bool("a", false, "")
+	fs.Bool("verbose", false, "")
+	fs.String("s", "", "")
+	fs.Duration("timeout", 0, "")
+	return fs
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		args     []string
+		wantFlag []string
+		wantCmd  []string
+	}{
+		{[]string{"mytool", "--verbose"}, nil, []string{"mytool", "--verbose"}},
+		{[]string{"--verbose", "mytool", "--verbose"}, []string{"--verbose"}, []string{"mytool", "--verbose"}},
+		{[]string{"-s", "-dpi 96", "mytool", "-s"}, []string{"-s", "-dpi 96"}, []string{"mytool", "-s"}},
+		{[]string{"--timeout=5s", "-a", "mytool"}, []string{"--timeout=5s", "-a"}, []string{"mytool"}},
+		{[]string{"-verbose=false", "mytool"}, []string{"-verbose=false"}, []string{"mytool"}},
+		{[]string{"-a", "--", "-s", "echo"}, []string{"-a"}, []string{"-s", "echo"}},
+		{[]string{"--", "-a", "echo"}, nil, []string{"-a", "echo"}},
+		{[]string{"-a", "--", "--", "x"}, []string{"-a"}, []string{"--", "x"}},
+		{[]string{"-a", "echo", "--", "x"}, []string{"-a"}, []string{"echo", "--", "x"}},
+		{[]string{"-s", "--", "echo"}, []string{"-s", "--"}, []string{"echo"}},
+		{[]string{"-", "x"}, nil, []string{"-", "x"}},
+		{[]string{"-a"}, []string{"-a"}, nil},
+	}
+	for _, tt := range tests {
+		flags, command, err := splitArgs(splitFlagSet(), tt.args)
+		if err != nil {
+			t.Errorf("splitArgs(%q): unexpected error: %v", tt.args, err)
+			continue
+		}
+		if !slices.Equal(flags, tt.wantFlag) || !slices.Equal(command, tt.wantCmd) {
+			t.Errorf("splitArgs(%q) = %q, %q, want %q, %q", tt.args, flags, command, tt.wantFlag, tt.wantCmd)
+		}
+	}
+}
+
+func TestSplitArgsErrors(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--bogus", "mytool"}, "flag provided but not defined: -bogus"},
+		{[]string{"-a", "-s"}, "flag needs an argument: -s"},
+	}
+	for _, tt := range tests {
+		_, _, err := splitArgs(splitFlagSet(), tt.args)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("splitArgs(%q) error = %v, want %q", tt.args, err, tt.want)
+		}
+	}
+}
+
+func TestRunPassesDashedArgsToCommand(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+
+	var stdout, stderr strings.Builder
+	code := run([]string{"--reuse", "echo", "--verbose", "-s", "x"}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if got := stdout.String(); got != "--verbose -s x\n" {
+		t.Errorf("expected the flags to reach the command, got %q", got)
+	}
+	if verbose {
+		t.Error("expected --verbose after the command not to turn on the wrapper's verbose mode")
+	}
+}
+
  func TestCommandExecutionWithArgs(t *testing.T) {
  	// Skip this test if we're running in a CI environment without X
  	if os.Getenv("CI") != "" {
@@ -117,7 +225,7 @@
  	}
 
  	// Run the main program with the test helper
- 	cmd := exec.Command("go", "run", "main.go", helperBin, "arg1", "arg2")
+ 	cmd := exec.Command("go", "run", ".", "-v", helperBin, "arg1", "arg2")
  	output, err := cmd.CombinedOutput()
  	outputStr := string(output)
 
@@ -140,22 +248,982 @@
 
  func TestNonExistentCommand(t *testing.T) {
  	// Run the main program with a non-existent command
- 	cmd := exec.Command("go", "run", "main.go", "non_existent_command")
+ 	cmd := exec.Command("go", "run", ".", "-v", "non_existent_command")
  	output, err := cmd.CombinedOutput()
  	outputStr := string(output)
 
- 	// The program should try to start Xvfb
- 	if !strings.Contains(outputStr, "Starting Xvfb on :99") {
- 		t.Errorf("Expected to start Xvfb, got: %s", outputStr)
- 	}
-
- 	// The program should exit with an error when the command fails
+ 	// The program should exit with an error when the command doesn't exist
  	if err == nil {
  		t.Fatal("Expected an error when command doesn't exist")
  	}
 
- 	// Check for the error message - either Xvfb failed to start or the command failed
- 	if !strings.Contains(outputStr, "Command failed") && !strings.Contains(outputStr, "Failed to start Xvfb") {
- 		t.Errorf("Expected error about command failing or Xvfb failing, got: %s", outputStr)
+ 	// It should find out before spending time on Xvfb
+ 	if strings.Contains(outputStr, "Starting Xvfb") {
+ 		t.Errorf("Expected not to start Xvfb, got: %s", outputStr)
+ 	}
+ 	if !strings.Contains(outputStr, "command not found: non_existent_command") {
+ 		t.Errorf("Expected a command not found error, got: %s", outputStr)
+ 	}
+ 	if !strings.Contains(outputStr, "exit status 127") {
+ 		t.Errorf("Expected exit status 127, got: %s", outputStr)
  	}
  }
+
+func TestAutoAndServerNumAreMutuallyExclusive(t *testing.T) {
+	cmd := exec.Command("go", "run", ".", "-a", "-n", "5", "echo")
+	output, err := cmd.CombinedOutput()
+
+	if err == nil {
+		t.Fatal("expected an error when both -a and -n are given")
+	}
+	if !strings.Contains(string(output), "-a and -n can't be used together") {
+		t.Errorf("expected error about -a and -n, got: %s", string(output))
+	}
+}
+
+func TestServerNumAlreadyInUse(t *testing.T) {
+	lock := "/tmp/.X31337-lock"
+	if _, err := os.Stat(lock); err == nil {
+		t.Skip("display :31337 is really in use")
+	}
+	if err := os.WriteFile(lock, []byte("1\n"), 0644); err != nil {
+		t.Skipf("can't create lock file: %v", err)
+	}
+	defer os.Remove(lock)
+
+	cmd := exec.Command("go", "run", ".", "-n", "31337", "echo")
+	output, err := cmd.CombinedOutput()
+
+	if err == nil {
+		t.Fatal("expected an error when the display is in use")
+	}
+	if !strings.Contains(string(output), "display :31337 is already in use") {
+		t.Errorf("expected error about display in use, got: %s", string(output))
+	}
+	if strings.Contains(string(output), "Starting Xvfb") {
+		t.Errorf("expected to fail before starting Xvfb, got: %s", string(output))
+	}
+}
+
+func TestNoStatusOutputWithoutVerbose(t *testing.T) {
+	cmd := exec.Command("go", "run", ".", "true")
+	output, _ := cmd.CombinedOutput()
+
+	if strings.Contains(string(output), "Starting Xvfb") {
+		t.Errorf("expected no status messages without -v, got: %s", string(output))
+	}
+}
+
+func TestUnwritableErrorFile(t *testing.T) {
+	cmd := exec.Command("go", "run", ".", "--error-file", filepath.Join(t.TempDir(), "missing", "xvfb.log"), "echo", "hi")
+	output, err := cmd.CombinedOutput()
+
+	if err == nil {
+		t.Fatal("expected an error for an unwritable --error-file")
+	}
+	if !strings.Contains(string(output), "Can't open --error-file") {
+		t.Errorf("expected error about --error-file, got: %s", string(output))
+	}
+}
+
+func TestMalformedEnvFlag(t *testing.T) {
+	cmd := exec.Command("go", "run", ".", "--env", "NOEQUALS", "echo", "hi")
+	output, err := cmd.CombinedOutput()
+
+	if err == nil {
+		t.Fatal("expected an error for --env without =")
+	}
+	if !strings.Contains(string(output), "expected KEY=VALUE") {
+		t.Errorf("expected error about KEY=VALUE, got: %s", string(output))
+	}
+}
+
+func TestCheckDir(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkDir(dir); err != nil {
+		t.Errorf("expected %s to be accepted, got %v", dir, err)
+	}
+	if err := checkDir(filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected an error for a missing directory")
+	}
+	if err := checkDir(file); err == nil || !strings.Contains(err.Error(), "not a directory") {
+		t.Errorf("expected a not-a-directory error, got %v", err)
+	}
+}
+
+func TestMissingWorkdir(t *testing.T) {
+	cmd := exec.Command("go", "run", ".", "-v", "--workdir", filepath.Join(t.TempDir(), "missing"), "pwd")
+	output, err := cmd.CombinedOutput()
+
+	if err == nil {
+		t.Fatal("expected an error for a missing --workdir")
+	}
+	if !strings.Contains(string(output), "Invalid --workdir") {
+		t.Errorf("expected error about --workdir, got: %s", string(output))
+	}
+	if strings.Contains(string(output), "Starting Xvfb") {
+		t.Errorf("expected to fail before starting Xvfb, got: %s", string(output))
+	}
+}
+
+func TestPrintDisplayRejectsCommand(t *testing.T) {
+	cmd := exec.Command("go", "run", ".", "--print-display", "echo", "hi")
+	output, err := cmd.CombinedOutput()
+
+	if err == nil {
+		t.Fatal("expected an error for --print-display with a command")
+	}
+	if !strings.Contains(string(output), "--print-display doesn't take a command") {
+		t.Errorf("expected error about --print-display, got: %s", string(output))
+	}
+}
+
+// restoreLogging undoes run's changes to the logging globals.
+func restoreLogging(t *testing.T) {
+	t.Cleanup(func() {
+		logOutput = os.Stderr
+		verbose = false
+		logFormat, logLevel = "human", ""
+	})
+}
+
+func TestRunUsageErrors(t *testing.T) {
+	restoreLogging(t)
+	tests := []struct {
+		name string
+		args []string
+		code int
+		msg  string
+	}{
+		{"no command", nil, 1, "No command specified to run under Xvfb"},
+		{"only -a", []string{"-a"}, 1, "No valid command after removing flags"},
+		{"only flags", []string{"-a", "-v"}, 1, "No valid command after removing flags"},
+		{"-a with -n", []string{"-a", "-n", "5", "true"}, 2, "-a and -n can't be used together"},
+		{"bad screen", []string{"--screen", "big", "true"}, 2, "Invalid --screen"},
+		{"negative display", []string{"-n", "-1", "true"}, 2, "Invalid display number"},
+		{"dpi too low", []string{"--dpi", "47", "true"}, 2, "--dpi must be between 48 and 300"},
+		{"dpi too high", []string{"--dpi", "301", "true"}, 2, "--dpi must be between 48 and 300"},
+		{"dpi zero", []string{"--dpi", "0", "true"}, 2, "--dpi must be between 48 and 300"},
+		{"missing fbdir", []string{"--fbdir", "/nonexistent/fbdir", "true"}, 2, "Invalid --fbdir"},
+		{"unknown flag", []string{"--bogus", "true"}, 2, "flag provided but not defined"},
+		{"bad log format", []string{"--log-format", "xml", "true"}, 2, "invalid --log-format"},
+		{"bad log level", []string{"--log-level", "loud", "true"}, 2, "invalid --log-level"},
+		{"display base without -a", []string{"--display-base", "200", "true"}, 2, "--display-base and --display-max need -a"},
+		{"display base zero", []string{"-a", "--display-base", "0", "true"}, 2, "--display-base must be at least 1"},
+		{"display max below base", []string{"-a", "--display-base", "200", "--display-max", "150", "true"}, 2, "--display-max can't be below --display-base"},
+		{"wait for free with -a", []string{"-a", "--wait-for-free", "1m", "true"}, 2, "--wait-for-free needs a fixed display"},
+		{"negative wait for free", []string{"--wait-for-free", "-1s", "true"}, 2, "--wait-for-free can't be negative"},
+		{"bad rlimit", []string{"--rlimit-nofile", "many", "true"}, 2, "--rlimit-nofile: invalid count"},
+		{"bad stdin mode", []string{"--stdin", "tty", "true"}, 2, "invalid --stdin"},
+		{"negative uid", []string{"--uid", "-2", "true"}, 2, "--uid must be between 0 and"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr strings.Builder
+			if code := run(tt.args, &stdout, &stderr); code != tt.code {
+				t.Errorf("expected exit code %d, got %d", tt.code, code)
+			}
+			if !strings.Contains(stderr.String(), tt.msg) {
+				t.Errorf("expected %q on stderr, got: %s", tt.msg, stderr.String())
+			}
+			if stdout.Len() != 0 {
+				t.Errorf("expected nothing on stdout, got: %s", stdout.String())
+			}
+		})
+	}
+}
+
+func TestRunHelp(t *testing.T) {
+	restoreLogging(t)
+	var stdout, stderr strings.Builder
+	if code := run([]string{"-h"}, &stdout, &stderr); code != 0 {
+		t.Errorf("expected exit code 0 for -h, got %d", code)
+	}
+	if !strings.Contains(stderr.String(), "-screen") {
+		t.Errorf("expected the flag list on stderr, got: %s", stderr.String())
+	}
+}
+
+func TestRunReusesExistingDisplay(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+
+	var stdout, stderr strings.Builder
+	code := run([]string{"-v", "--reuse", "sh", "-c", `echo "$DISPLAY"`}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if got := stdout.String(); got != ":42\n" {
+		t.Errorf("expected the command to run on :42, got %q", got)
+	}
+	if strings.Contains(stderr.String(), "Starting Xvfb") {
+		t.Errorf("expected Xvfb not to be started, got: %s", stderr.String())
+	}
+}
+
+func TestRunJSONReportsResult(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+
+	var stdout, stderr strings.Builder
+	if code := run([]string{"--json", "--reuse", "sh", "-c", "exit 3"}, &stdout, &stderr); code != 3 {
+		t.Fatalf("expected exit code 3, got %d", code)
+	}
+	lines := strings.Split(strings.TrimSpace(stderr.String()), "\n")
+	var res result
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &res); err != nil {
+		t.Fatalf("expected JSON as the last line of stderr, got %q: %v", stderr.String(), err)
+	}
+	if res.Display != ":42" || res.ExitCode != 3 || res.XvfbPID != 0 || res.Error == "" {
+		t.Errorf("unexpected result %+v", res)
+	}
+}
+
+func TestRunCommandLineOverridesXvfbRunArgs(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+	t.Setenv("XVFB_RUN_ARGS", `--reuse --env "GREETING=from env" --env OTHER=env`)
+
+	var stdout, stderr strings.Builder
+	code := run([]string{"--env", "GREETING=from flags", "sh", "-c", `echo "$GREETING/$OTHER"`}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if got := stdout.String(); got != "from flags/env\n" {
+		t.Errorf("unexpected output %q", got)
+	}
+}
+
+func TestRunRejectsCommandInXvfbRunArgs(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("XVFB_RUN_ARGS", "-a echo")
+
+	var stdout, stderr strings.Builder
+	if code := run([]string{"true"}, &stdout, &stderr); code != 2 {
+		t.Errorf("expected exit code 2, got %d", code)
+	}
+	if !strings.Contains(stderr.String(), "XVFB_RUN_ARGS can only contain flags") {
+		t.Errorf("unexpected stderr: %s", stderr.String())
+	}
+}
+
+func TestRunInvalidXvfbRunArgs(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("XVFB_RUN_ARGS", "--bogus")
+
+	var stdout, stderr strings.Builder
+	if code := run([]string{"true"}, &stdout, &stderr); code != 2 {
+		t.Errorf("expected exit code 2, got %d", code)
+	}
+	if !strings.Contains(stderr.String(), "Invalid XVFB_RUN_ARGS") {
+		t.Errorf("unexpected stderr: %s", stderr.String())
+	}
+}
+
+func TestRunPrefixesCommandOutput(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+
+	var stdout, stderr strings.Builder
+	code := run([]string{"--reuse", "--prefix", "[child] ", "sh", "-c", `echo out; echo err >&2; printf partial`}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if got := stdout.String(); got != "[child] out\n[child] partial" {
+		t.Errorf("unexpected stdout %q", got)
+	}
+	if got := stderr.String(); got != "[child] err\n" {
+		t.Errorf("unexpected stderr %q", got)
+	}
+}
+
+func TestRunShellScriptFromStdin(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+	old := stdin
+	stdin = strings.NewReader(`echo "script on $DISPLAY"; cat`)
+	t.Cleanup(func() { stdin = old })
+
+	var stdout, stderr strings.Builder
+	if code := run([]string{"--reuse", "--shell"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	// cat sees no input: the script used it up
+	if got := stdout.String(); got != "script on :42\n" {
+		t.Errorf("unexpected output %q", got)
+	}
+}
+
+func TestRunShellWithEmptyStdin(t *testing.T) {
+	restoreLogging(t)
+	old := stdin
+	stdin = strings.NewReader("")
+	t.Cleanup(func() { stdin = old })
+
+	var stdout, stderr strings.Builder
+	if code := run([]string{"--shell"}, &stdout, &stderr); code != 1 {
+		t.Errorf("expected exit code 1, got %d", code)
+	}
+	if !strings.Contains(stderr.String(), "no script on stdin") {
+		t.Errorf("unexpected stderr: %s", stderr.String())
+	}
+}
+
+func TestRunPassesStdinToCommandWithInherit(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+	old := stdin
+	stdin = strings.NewReader("for the command\n")
+	t.Cleanup(func() { stdin = old })
+
+	var stdout, stderr strings.Builder
+	if code := run([]string{"--reuse", "--stdin", "inherit", "cat"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if got := stdout.String(); got != "for the command\n" {
+		t.Errorf("unexpected output %q", got)
+	}
+}
+
+func TestRunDoesNotPassPipedStdinByDefault(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+	old := stdin
+	stdin = strings.NewReader("not for the command\n")
+	t.Cleanup(func() { stdin = old })
+
+	var stdout, stderr strings.Builder
+	if code := run([]string{"--reuse", "cat"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if got := stdout.String(); got != "" {
+		t.Errorf("expected cat to see no input, got %q", got)
+	}
+}
+
+func TestCheckCommand(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "script.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name, workdir string
+		ok            bool
+	}{
+		{"sh", "", true},
+		{"non_existent_command", "", false},
+		{script, "", true},
+		{filepath.Join(dir, "missing.sh"), "", false},
+		{"./script.sh", dir, true},
+		{"./missing.sh", dir, false},
+	} {
+		err := checkCommand(tt.name, tt.workdir)
+		if tt.ok && err != nil {
+			t.Errorf("checkCommand(%q, %q): unexpected error: %v", tt.name, tt.workdir, err)
+		}
+		if !tt.ok && (err == nil || !strings.Contains(err.Error(), "command not found: "+tt.name)) {
+			t.Errorf("checkCommand(%q, %q): expected command not found, got %v", tt.name, tt.workdir, err)
+		}
+	}
+}
+
+func TestCommandDir(t *testing.T) {
+	for _, tt := range []struct{ name, want string }{
+		{"/opt/app/bin/app", "/opt/app/bin"},
+		{"./build/app", "build"},
+		{"bin/app", "bin"},
+		{"app", ""},
+		{"sh", ""},
+	} {
+		if got := commandDir(tt.name); got != tt.want {
+			t.Errorf("commandDir(%q): expected %q, got %q", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestRunCwdFromArg(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+	t.Setenv("XVFB_RUN_ARGS", "")
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tool := filepath.Join(dir, "bin", "tool")
+	if err := os.MkdirAll(filepath.Dir(tool), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(tool, []byte("#!/bin/sh\npwd\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	for _, tt := range []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"absolute path", []string{"--cwd-from-arg", tool}, filepath.Dir(tool)},
+		{"relative path", []string{"--cwd-from-arg", "./bin/tool"}, filepath.Dir(tool)},
+		{"bare name", []string{"--cwd-from-arg", "pwd"}, dir},
+		{"workdir wins", []string{"--cwd-from-arg", "--workdir", "/", tool}, "/"},
+		{"without the flag", []string{tool}, dir},
+		{"each command", []string{"--cwd-from-arg", tool, "---", "pwd"}, filepath.Dir(tool) + "\n" + dir},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr strings.Builder
+			if code := run(append([]string{"--reuse"}, tt.args...), &stdout, &stderr); code != 0 {
+				t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+			}
+			if got := strings.TrimSpace(stdout.String()); got != tt.want {
+				t.Errorf("expected the command to run in %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRunMissingCommandExits127(t *testing.T) {
+	restoreLogging(t)
+
+	var stdout, stderr strings.Builder
+	if code := run([]string{"-v", "non_existent_command"}, &stdout, &stderr); code != 127 {
+		t.Errorf("expected exit code 127, got %d", code)
+	}
+	if strings.Contains(stderr.String(), "Starting Xvfb") {
+		t.Errorf("expected not to start Xvfb, got: %s", stderr.String())
+	}
+}
+
+func TestRunStatusFileLifecycle(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+	path := filepath.Join(t.TempDir(), "xvfb.status")
+
+	var stdout, stderr strings.Builder
+	code := run([]string{"--reuse", "--status-file", path, "cat", path}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if got := stdout.String(); got != "DISPLAY=:42\n" {
+		t.Errorf("expected the command to see the status file, got %q", got)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected the status file to be removed, got %v", err)
+	}
+}
+
+func TestRunDryRun(t *testing.T) {
+	restoreLogging(t)
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "mytool"), []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin)
+	t.Setenv("XVFB_RUN_ARGS", "")
+	t.Setenv("XVFB_SCREEN_GEOMETRY", "")
+	errorFile := filepath.Join(t.TempDir(), "xvfb.log")
+
+	var stdout, stderr strings.Builder
+	code := run([]string{"--dry-run", "-n", "5", "--dpi", "96", "-e", errorFile, "mytool", "--flag", "a b"}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	want := "DISPLAY=:5\n" +
+		"XVFB=Xvfb :5 -auth '$XAUTHORITY' -nolisten tcp -screen 0 1280x1024x24 -dpi 96\n" +
+		"COMMAND=mytool --flag 'a b'\n"
+	if got := stdout.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if _, err := os.Stat(errorFile); !os.IsNotExist(err) {
+		t.Errorf("expected --dry-run not to create the error file, got %v", err)
+	}
+}
+
+func TestRunDryRunDisableAccessControl(t *testing.T) {
+	restoreLogging(t)
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "mytool"), []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin)
+	t.Setenv("XVFB_RUN_ARGS", "")
+	t.Setenv("XVFB_SCREEN_GEOMETRY", "")
+
+	var stdout, stderr strings.Builder
+	code := run([]string{"--dry-run", "-n", "5", "--disable-access-control", "mytool"}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "XVFB=Xvfb :5 -ac -nolisten tcp -screen 0 1280x1024x24\n") {
+		t.Errorf("expected -ac and no -auth, got:\n%s", stdout.String())
+	}
+	if !strings.Contains(stderr.String(), "--disable-access-control: any local client") {
+		t.Errorf("expected a security warning, got %q", stderr.String())
+	}
+}
+
+func TestRunDryRunFontPath(t *testing.T) {
+	restoreLogging(t)
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "mytool"), []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin)
+	t.Setenv("XVFB_RUN_ARGS", "")
+	t.Setenv("XVFB_SCREEN_GEOMETRY", "")
+	fonts := t.TempDir()
+
+	var stdout, stderr strings.Builder
+	if code := run([]string{"--dry-run", "-n", "5", "--fontpath", fonts + ",unix/:7100", "mytool"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if want := " -fp " + fonts + ",unix/:7100\n"; !strings.Contains(stdout.String(), want) {
+		t.Errorf("expected %q in the Xvfb command line, got:\n%s", want, stdout.String())
+	}
+
+	stderr.Reset()
+	if code := run([]string{"--dry-run", "--fontpath", filepath.Join(fonts, "missing"), "mytool"}, &stdout, &stderr); code != 2 {
+		t.Errorf("expected exit code 2 for a missing font directory, got %d", code)
+	}
+	if !strings.Contains(stderr.String(), "Invalid --fontpath") {
+		t.Errorf("unexpected stderr %q", stderr.String())
+	}
+}
+
+func TestRunDryRunXvfbPath(t *testing.T) {
+	restoreLogging(t)
+	bin := t.TempDir()
+	for _, name := range []string{"mytool", "Xvfb-custom"} {
+		if err := os.WriteFile(filepath.Join(bin, name), []byte("#!/bin/sh\n"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", bin)
+	t.Setenv("XVFB_RUN_ARGS", "")
+	t.Setenv("XVFB_SCREEN_GEOMETRY", "")
+	t.Setenv("XVFB_BINARY", filepath.Join(bin, "Xvfb-custom"))
+
+	var stdout, stderr strings.Builder
+	if code := run([]string{"--dry-run", "-n", "5", "mytool"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if want := "XVFB=" + filepath.Join(bin, "Xvfb-custom") + " :5 "; !strings.Contains(stdout.String(), want) {
+		t.Errorf("expected %q, got:\n%s", want, stdout.String())
+	}
+
+	stdout.Reset()
+	stderr.Reset()
+	if code := run([]string{"--dry-run", "-n", "5", "--xvfb-path", filepath.Join(bin, "mytool-missing"), "mytool"}, &stdout, &stderr); code != 1 {
+		t.Errorf("expected exit code 1 for a missing --xvfb-path, got %d", code)
+	}
+	if !strings.Contains(stderr.String(), "mytool-missing") {
+		t.Errorf("expected the bad path in the error, got %q", stderr.String())
+	}
+}
+
+func TestRunDryRunXwaylandBackend(t *testing.T) {
+	restoreLogging(t)
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "mytool"), []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin)
+	t.Setenv("XVFB_RUN_ARGS", "")
+	t.Setenv("XVFB_SCREEN_GEOMETRY", "")
+
+	var stdout, stderr strings.Builder
+	code := run([]string{"--dry-run", "-n", "5", "--backend", "xwayland", "mytool"}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "XVFB=Xwayland :5 -auth '$XAUTHORITY' -nolisten tcp\n") {
+		t.Errorf("expected an Xwayland command line, got:\n%s", stdout.String())
+	}
+}
+
+func TestRunRejectsUnknownReadyCheck(t *testing.T) {
+	restoreLogging(t)
+
+	var stdout, stderr strings.Builder
+	if code := run([]string{"--ready-check", "ping", "true"}, &stdout, &stderr); code != 2 {
+		t.Fatalf("expected exit code 2, got %d", code)
+	}
+	if !strings.Contains(stderr.String(), `invalid --ready-check "ping"`) {
+		t.Errorf("unexpected stderr %q", stderr.String())
+	}
+}
+
+func TestRunRejectsUnknownBackend(t *testing.T) {
+	restoreLogging(t)
+
+	var stdout, stderr strings.Builder
+	if code := run([]string{"--backend", "xquartz", "true"}, &stdout, &stderr); code != 2 {
+		t.Fatalf("expected exit code 2, got %d", code)
+	}
+	if !strings.Contains(stderr.String(), `invalid --backend "xquartz"`) {
+		t.Errorf("unexpected stderr %q", stderr.String())
+	}
+}
+
+func TestRunShutdownTimeout(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+
+	var stdout, stderr strings.Builder
+	if code := run([]string{"--shutdown-timeout", "-1s", "true"}, &stdout, &stderr); code != 2 {
+		t.Errorf("expected exit code 2 for a negative --shutdown-timeout, got %d", code)
+	}
+
+	began := time.Now()
+	code := run([]string{"--reuse", "--timeout", "100ms", "--shutdown-timeout", "100ms", "sh", "-c", `trap "" TERM; sleep 30`}, &stdout, &stderr)
+	if code != timeoutExitCode {
+		t.Errorf("expected exit code %d, got %d: %s", timeoutExitCode, code, stderr.String())
+	}
+	if elapsed := time.Since(began); elapsed > 2*time.Second {
+		t.Errorf("expected the command to be killed after --shutdown-timeout, took %s", elapsed)
+	}
+}
+
+func TestRunNiceness(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+
+	var stdout, stderr strings.Builder
+	for _, args := range [][]string{{"--xvfb-nice", "20"}, {"--child-nice", "-21"}} {
+		if code := run(append(args, "true"), &stdout, &stderr); code != 2 {
+			t.Errorf("expected exit code 2 for %v, got %d", args, code)
+		}
+	}
+	if runtime.GOOS != "linux" {
+		t.Skip("niceness is only supported on Linux")
+	}
+	if code := run([]string{"--reuse", "--child-nice", "19", "sh", "-c", "cut -d' ' -f19 /proc/$$/stat"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if got := stdout.String(); got != "19\n" {
+		t.Errorf("expected the command at niceness 19, got %q", got)
+	}
+}
+
+func TestRunTimings(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+
+	var stdout, stderr strings.Builder
+	if code := run([]string{"--reuse", "--timings", "--json", "true"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	got := stderr.String()
+	if !strings.Contains(got, "Timings:\n   command_ms") {
+		t.Errorf("expected a timings breakdown, got %q", got)
+	}
+	if strings.Contains(got, "xvfb_spawn_ms") {
+		t.Errorf("expected no Xvfb phases on a reused display, got %q", got)
+	}
+	if !strings.Contains(got, `"timings":{"command_ms":`) {
+		t.Errorf("expected timings in the JSON summary, got %q", got)
+	}
+}
+
+func TestRunDryRunWithReuse(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+
+	var stdout, stderr strings.Builder
+	code := run([]string{"--dry-run", "--reuse", "true"}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if got := stdout.String(); got != "DISPLAY=:42\nCOMMAND=true\n" {
+		t.Errorf("unexpected output %q", got)
+	}
+}
+
+func TestRunServerArgsFileGoesAfterInlineArgs(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("PATH", "/nonexistent")
+	t.Setenv("XVFB_RUN_ARGS", "")
+	argsFile := filepath.Join(t.TempDir(), "xvfb.args")
+	if err := os.WriteFile(argsFile, []byte("# fonts\n-fp built-ins\n-nocursor\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr strings.Builder
+	code := run([]string{"--dry-run", "-n", "5", "-s", "-screen 0 800x600x16", "--server-args-file", argsFile, "/bin/true"}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	want := "XVFB=Xvfb :5 -auth '$XAUTHORITY' -nolisten tcp -screen 0 800x600x16 -fp built-ins -nocursor\n"
+	if !strings.Contains(stdout.String(), want) {
+		t.Errorf("expected %q in:\n%s", want, stdout.String())
+	}
+}
+
+func TestRunServerArgsFileMissing(t *testing.T) {
+	restoreLogging(t)
+
+	var stdout, stderr strings.Builder
+	code := run([]string{"--server-args-file", filepath.Join(t.TempDir(), "missing"), "true"}, &stdout, &stderr)
+	if code != 2 {
+		t.Errorf("expected exit code 2, got %d", code)
+	}
+	if !strings.Contains(stderr.String(), "Can't read --server-args-file") {
+		t.Errorf("unexpected stderr: %s", stderr.String())
+	}
+}
+
+func TestRunArgbSetsDepth32(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("PATH", "/nonexistent")
+	t.Setenv("XVFB_RUN_ARGS", "")
+	t.Setenv("XVFB_SCREEN_GEOMETRY", "")
+
+	var stdout, stderr strings.Builder
+	code := run([]string{"--dry-run", "-n", "5", "--argb", "--screen", "800x600x24", "--screen", "1=640x480x16", "/bin/true"}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	want := "XVFB=Xvfb :5 -auth '$XAUTHORITY' -nolisten tcp -screen 0 800x600x32 -screen 1 640x480x32 +extension Composite\n"
+	if !strings.Contains(stdout.String(), want) {
+		t.Errorf("expected %q in:\n%s", want, stdout.String())
+	}
+}
+
+func TestRunArgbWarnsWhenDepthIsMissing(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+	bin := t.TempDir()
+	xdpyinfo := "#!/bin/sh\necho '  depths (2):    24, 1'\n"
+	if err := os.WriteFile(filepath.Join(bin, "xdpyinfo"), []byte(xdpyinfo), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	var stdout, stderr strings.Builder
+	code := run([]string{"--reuse", "--argb", "true"}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if !strings.Contains(stderr.String(), "--argb: the display has no depth 32, only [24 1]") {
+		t.Errorf("expected a warning, got: %s", stderr.String())
+	}
+}
+
+func TestRunDryRunScansSocketDir(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("XVFB_RUN_ARGS", "")
+	socketDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(socketDir, ".X99-lock"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr strings.Builder
+	code := run([]string{"--dry-run", "-a", "--socket-dir", socketDir, "true"}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if !strings.HasPrefix(stdout.String(), "DISPLAY=:100\n") {
+		t.Errorf("expected :99 to be seen as taken in the socket dir, got:\n%s", stdout.String())
+	}
+}
+
+func TestRunRejectsMissingSocketDir(t *testing.T) {
+	restoreLogging(t)
+
+	var stdout, stderr strings.Builder
+	code := run([]string{"--socket-dir", filepath.Join(t.TempDir(), "missing"), "true"}, &stdout, &stderr)
+	if code != 2 {
+		t.Errorf("expected exit code 2, got %d", code)
+	}
+	if !strings.Contains(stderr.String(), "Invalid --socket-dir") {
+		t.Errorf("unexpected stderr: %s", stderr.String())
+	}
+}
+
+func TestRunLogsJSONEvents(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+
+	var stdout, stderr strings.Builder
+	code := run([]string{"--reuse", "--log-format", "json", "--log-level", "info", "sh", "-c", "exit 3"}, &stdout, &stderr)
+	if code != 3 {
+		t.Fatalf("expected exit code 3, got %d: %s", code, stderr.String())
+	}
+
+	events := map[string]map[string]any{}
+	for _, line := range strings.Split(strings.TrimSpace(stderr.String()), "\n") {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Errorf("expected only JSON on stderr, got %q", line)
+			continue
+		}
+		events[record["event"].(string)] = record
+	}
+	if _, ok := events["display_reuse"]; !ok {
+		t.Errorf("expected a display_reuse event, got: %s", stderr.String())
+	}
+	if got := events["command_start"]["command"]; fmt.Sprint(got) != "[sh -c exit 3]" {
+		t.Errorf("expected the command as an attribute, got %v", got)
+	}
+	if got := events["command_exit"]["exit_code"]; got != float64(3) {
+		t.Errorf("expected exit_code 3, got %v", got)
+	}
+	if got := events["command_failed"]["level"]; got != "ERROR" {
+		t.Errorf("expected the failure logged at error level, got %v", got)
+	}
+}
+
+func TestRunAsUID(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("needs root to switch users")
+	}
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+
+	var stdout, stderr strings.Builder
+	code := run([]string{"--reuse", "--uid", "65534", "--gid", "65534", "sh", "-c", "id -u; id -g"}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if got := stdout.String(); got != "65534\n65534\n" {
+		t.Errorf("expected the command to run as 65534:65534, got %q", got)
+	}
+}
+
+func TestRunDisplayBaseAndMax(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("XVFB_RUN_ARGS", "")
+	socketDir := t.TempDir()
+	for _, n := range []string{"500", "501"} {
+		if err := os.WriteFile(filepath.Join(socketDir, ".X"+n+"-lock"), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var stdout, stderr strings.Builder
+	code := run([]string{"--dry-run", "-a", "--socket-dir", socketDir, "--display-base", "500", "--display-max", "502", "true"}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if !strings.HasPrefix(stdout.String(), "DISPLAY=:502\n") {
+		t.Errorf("expected :502, got:\n%s", stdout.String())
+	}
+
+	stdout.Reset()
+	stderr.Reset()
+	code = run([]string{"--dry-run", "-a", "--socket-dir", socketDir, "--display-base", "500", "--display-max", "501", "true"}, &stdout, &stderr)
+	if code != 1 || !strings.Contains(stderr.String(), "no free display between :500 and :501") {
+		t.Errorf("expected a clean failure for a full range, got %d: %s", code, stderr.String())
+	}
+}
+
+func TestRunShowsXvfbOutputWhenStartFails(t *testing.T) {
+	if _, err := exec.LookPath("xauth"); err != nil {
+		t.Skip("xauth not installed")
+	}
+	restoreLogging(t)
+	t.Setenv("XVFB_RUN_ARGS", "")
+	bin := t.TempDir()
+	xvfb := "#!/bin/sh\necho '(EE) Fatal server error:' >&2\necho '(EE) Unrecognized option: -bogus' >&2\nexit 1\n"
+	if err := os.WriteFile(filepath.Join(bin, "Xvfb"), []byte(xvfb), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	var stdout, stderr strings.Builder
+	code := run([]string{"-a", "-s", "-bogus", "true"}, &stdout, &stderr)
+	if code != serverStartExitCode {
+		t.Errorf("expected exit code %d, got %d", serverStartExitCode, code)
+	}
+	if !strings.Contains(stderr.String(), "Failed to start Xvfb:") || !strings.Contains(stderr.String(), "(EE) Unrecognized option: -bogus") {
+		t.Errorf("expected Xvfb's own error, got: %s", stderr.String())
+	}
+}
+
+func TestRunQuietChildOnSuccess(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+
+	var stdout, stderr strings.Builder
+	code := run([]string{"--reuse", "--quiet-child-on-success", "sh", "-c", "echo out; echo err >&2"}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if stdout.Len() != 0 || stderr.Len() != 0 {
+		t.Errorf("expected no output from a passing command, got %q and %q", stdout.String(), stderr.String())
+	}
+}
+
+func TestRunQuietChildOnSuccessShowsFailures(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+
+	var stdout, stderr strings.Builder
+	code := run([]string{"--reuse", "--quiet-child-on-success", "--prefix", "[child] ", "sh", "-c", "echo out; echo err >&2; exit 4"}, &stdout, &stderr)
+	if code != 4 {
+		t.Fatalf("expected exit code 4, got %d: %s", code, stderr.String())
+	}
+	if got := stdout.String(); got != "[child] out\n" {
+		t.Errorf("expected the held stdout, got %q", got)
+	}
+	if !strings.HasPrefix(stderr.String(), "[child] err\n") {
+		t.Errorf("expected the held stderr before the wrapper's messages, got %q", stderr.String())
+	}
+}
+
+func TestRunPidFile(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+	path := filepath.Join(t.TempDir(), "xvfb-run.pid")
+
+	var stdout, stderr strings.Builder
+	code := run([]string{"--reuse", "--pidfile", path, "cat", path}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if want := strconv.Itoa(os.Getpid()) + "\n"; stdout.String() != want {
+		t.Errorf("expected the wrapper's PID %q in the file, got %q", want, stdout.String())
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected the pid file to be removed, got %v", err)
+	}
+}
+
+func TestRunPidFileOwnedByLiveProcess(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+	path := filepath.Join(t.TempDir(), "xvfb-run.pid")
+	sleeper := exec.Command("sleep", "30")
+	if err := sleeper.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sleeper.Process.Kill()
+	os.WriteFile(path, []byte(strconv.Itoa(sleeper.Process.Pid)+"\n"), 0o644)
+
+	var stdout, stderr strings.Builder
+	if code := run([]string{"--reuse", "--pidfile", path, "true"}, &stdout, &stderr); code != 1 {
+		t.Errorf("expected exit code 1, got %d", code)
+	}
+	if !strings.Contains(stderr.String(), "Can't write --pidfile") {
+		t.Errorf("unexpected stderr: %s", stderr.String())
+	}
+
+	stderr.Reset()
+	if code := run([]string{"--reuse", "--pidfile", path, "--force", "true"}, &stdout, &stderr); code != 0 {
+		t.Errorf("expected --force to take over, got %d: %s", code, stderr.String())
+	}
+}
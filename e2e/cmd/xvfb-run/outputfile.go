@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+// outputFile is --output-file: one file both of the command's streams are
+// copied into, in the order they were written. A failed write is kept for
+// Close to report rather than returned, so a full disk never stops the
+// command's output from reaching the real streams.
+type outputFile struct {
+	mu  sync.Mutex
+	f   *os.File
+	err error
+}
+
+// openOutputFile opens path for --output-file, appending to it with
+// appendTo and truncating it otherwise.
+func openOutputFile(path string, appendTo bool) (*outputFile, error) {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if appendTo {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(path, flags, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &outputFile{f: f}, nil
+}
+
+func (o *outputFile) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.err == nil {
+		_, o.err = o.f.Write(p)
+	}
+	return len(p), nil
+}
+
+// tee returns a writer that copies to the file on the way to w, or only to
+// the file if w is nil.
+func (o *outputFile) tee(w io.Writer) io.Writer {
+	if w == nil {
+		return o
+	}
+	return io.MultiWriter(w, o)
+}
+
+// Close flushes and closes the file, returning the first error writing it.
+func (o *outputFile) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return errors.Join(o.err, o.f.Sync(), o.f.Close())
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOutputFileTruncatesOrAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.log")
+	for _, tt := range []struct {
+		appendTo bool
+		want     string
+	}{
+		{true, "old\nnew\n"},
+		{false, "new\n"},
+	} {
+		if err := os.WriteFile(path, []byte("old\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		o, err := openOutputFile(path, tt.appendTo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		o.tee(nil).Write([]byte("new\n"))
+		if err := o.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if data, _ := os.ReadFile(path); string(data) != tt.want {
+			t.Errorf("append %v: got %q, want %q", tt.appendTo, data, tt.want)
+		}
+	}
+}
+
+func TestOutputFileKeepsWriteErrorsFromTheCommand(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.log")
+	o, err := openOutputFile(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.f.Close()
+
+	var real strings.Builder
+	if n, err := o.tee(&real).Write([]byte("hello\n")); n != 6 || err != nil {
+		t.Errorf("expected the write to succeed, got %d, %v", n, err)
+	}
+	if real.String() != "hello\n" {
+		t.Errorf("expected the output to reach the real stream, got %q", real.String())
+	}
+	if err := o.Close(); err == nil {
+		t.Error("expected Close to report the failed write")
+	}
+}
+
+func TestRunOutputFile(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+	path := filepath.Join(t.TempDir(), "out.log")
+
+	var stdout, stderr strings.Builder
+	if code := run([]string{"--reuse", "--output-file", path, "sh", "-c", "echo out; echo err >&2"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if stdout.String() != "out\n" || !strings.Contains(stderr.String(), "err\n") {
+		t.Errorf("expected the output to pass through, got %q and %q", stdout.String(), stderr.String())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data); !strings.Contains(got, "out\n") || !strings.Contains(got, "err\n") {
+		t.Errorf("expected both streams in the file, got %q", got)
+	}
+}
+
+func TestRunOutputFileNoPassthrough(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+	path := filepath.Join(t.TempDir(), "out.log")
+
+	var stdout, stderr strings.Builder
+	if code := run([]string{"--reuse", "-q", "--output-file", path, "--no-passthrough", "--output-append", "echo", "only in the file"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("expected no output on stdout, got %q", stdout.String())
+	}
+	if data, _ := os.ReadFile(path); string(data) != "only in the file\n" {
+		t.Errorf("unexpected file contents %q", data)
+	}
+
+	if code := run([]string{"--no-passthrough", "true"}, &stdout, &stderr); code != 2 {
+		t.Errorf("expected exit code 2 without --output-file, got %d", code)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io"
+	"sync"
+)
+
+// quietOutputLimit is how much of the command's output
+// --quiet-child-on-success holds back before giving up and passing it
+// through.
+const quietOutputLimit = 8 << 20
+
+// bufferedPassthrough holds back what the command writes to stdout and
+// stderr until it is known whether it failed; Flush replays it in the order
+// it was written and Discard drops it. Once more than limit bytes are held,
+// they are flushed and later writes go straight through, so a chatty
+// command can't exhaust memory.
+type bufferedPassthrough struct {
+	mu     sync.Mutex
+	limit  int
+	size   int
+	chunks []heldChunk
+	direct bool
+}
+
+// heldChunk is one write waiting for its stream.
+type heldChunk struct {
+	w    io.Writer
+	data []byte
+}
+
+func newBufferedPassthrough(limit int) *bufferedPassthrough {
+	return &bufferedPassthrough{limit: limit}
+}
+
+// writer returns a writer whose output is held back on its way to w.
+func (b *bufferedPassthrough) writer(w io.Writer) io.Writer {
+	return &heldWriter{b: b, w: w}
+}
+
+func (b *bufferedPassthrough) write(w io.Writer, p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.direct {
+		return w.Write(p)
+	}
+	b.chunks = append(b.chunks, heldChunk{w: w, data: append([]byte(nil), p...)})
+	b.size += len(p)
+	if b.size > b.limit {
+		b.direct = true
+		if err := b.flush(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush writes out everything held back.
+func (b *bufferedPassthrough) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.flush()
+}
+
+func (b *bufferedPassthrough) flush() error {
+	chunks := b.chunks
+	b.chunks, b.size = nil, 0
+	for _, c := range chunks {
+		if _, err := c.w.Write(c.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Discard drops everything held back.
+func (b *bufferedPassthrough) Discard() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.chunks, b.size = nil, 0
+}
+
+// heldWriter is one stream of a bufferedPassthrough.
+type heldWriter struct {
+	b *bufferedPassthrough
+	w io.Writer
+}
+
+func (h *heldWriter) Write(p []byte) (int, error) {
+	return h.b.write(h.w, p)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBufferedPassthroughFlushKeepsOrder(t *testing.T) {
+	var combined strings.Builder
+	var stdout, stderr strings.Builder
+	b := newBufferedPassthrough(1024)
+	out := b.writer(teeBuilder{&stdout, &combined})
+	errw := b.writer(teeBuilder{&stderr, &combined})
+
+	out.Write([]byte("one\n"))
+	errw.Write([]byte("two\n"))
+	out.Write([]byte("three\n"))
+	if combined.Len() != 0 {
+		t.Fatalf("expected nothing before Flush, got %q", combined.String())
+	}
+
+	if err := b.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if got := combined.String(); got != "one\ntwo\nthree\n" {
+		t.Errorf("expected the writes in order, got %q", got)
+	}
+	if stdout.String() != "one\nthree\n" || stderr.String() != "two\n" {
+		t.Errorf("expected each write on its own stream, got %q and %q", stdout.String(), stderr.String())
+	}
+}
+
+func TestBufferedPassthroughDiscard(t *testing.T) {
+	var stdout strings.Builder
+	b := newBufferedPassthrough(1024)
+	b.writer(&stdout).Write([]byte("noise\n"))
+
+	b.Discard()
+	b.Flush()
+	if stdout.Len() != 0 {
+		t.Errorf("expected discarded output to stay gone, got %q", stdout.String())
+	}
+}
+
+func TestBufferedPassthroughSwitchesToDirectPastLimit(t *testing.T) {
+	var stdout strings.Builder
+	b := newBufferedPassthrough(8)
+	w := b.writer(&stdout)
+
+	w.Write([]byte("12345"))
+	if stdout.Len() != 0 {
+		t.Fatalf("expected output under the limit to be held, got %q", stdout.String())
+	}
+	if n, err := w.Write([]byte("6789")); n != 4 || err != nil {
+		t.Fatalf("Write = %d, %v", n, err)
+	}
+	if got := stdout.String(); got != "123456789" {
+		t.Errorf("expected everything held to be flushed at the limit, got %q", got)
+	}
+	w.Write([]byte("!"))
+	b.Discard()
+	if got := stdout.String(); got != "123456789!" {
+		t.Errorf("expected later writes to go straight through, got %q", got)
+	}
+}
+
+// teeBuilder writes to a stream and to a record of all streams together.
+type teeBuilder struct {
+	stream, combined *strings.Builder
+}
+
+func (t teeBuilder) Write(p []byte) (int, error) {
+	t.combined.Write(p)
+	return t.stream.Write(p)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// writePidFile writes pid to path so a supervisor can signal the wrapper.
+// If path names another process that is still running, it is left alone
+// and an error returned, unless force is set.
+func writePidFile(path string, pid int, force bool) error {
+	if owner, err := readPidFile(path); err == nil && owner != pid && !force && processAlive(owner) {
+		return fmt.Errorf("%s belongs to running process %d (use --force to take it over)", path, owner)
+	}
+	return writeFileAtomic(path, strconv.Itoa(pid)+"\n")
+}
+
+// removePidFile removes path if it still holds pid, so a wrapper that was
+// forced out doesn't delete its successor's file.
+func removePidFile(path string, pid int) error {
+	owner, err := readPidFile(path)
+	if err != nil || owner != pid {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func readPidFile(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("malformed pid file %s", path)
+	}
+	return pid, nil
+}
+
+// processAlive reports whether pid is running; one we may not signal
+// still counts.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func exitedPID(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return cmd.Process.Pid
+}
+
+func TestWritePidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "xvfb-run.pid")
+
+	if err := writePidFile(path, 4242, false); err != nil {
+		t.Fatalf("writePidFile: %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "4242\n" {
+		t.Errorf("unexpected pid file %q", data)
+	}
+	if err := removePidFile(path, 4242); err != nil {
+		t.Fatalf("removePidFile: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected the pid file to be removed, got %v", err)
+	}
+}
+
+func TestWritePidFileRefusesLiveOwner(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "xvfb-run.pid")
+	// The test process stands in for another running wrapper
+	if err := writePidFile(path, os.Getpid(), false); err != nil {
+		t.Fatal(err)
+	}
+
+	err := writePidFile(path, 4242, false)
+	if err == nil || !strings.Contains(err.Error(), "use --force") {
+		t.Fatalf("expected a live owner to be refused, got %v", err)
+	}
+	if err := writePidFile(path, 4242, true); err != nil {
+		t.Fatalf("expected --force to take over, got %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "4242\n" {
+		t.Errorf("unexpected pid file %q", data)
+	}
+}
+
+func TestWritePidFileReplacesDeadOwnerAndGarbage(t *testing.T) {
+	dir := t.TempDir()
+	dead := filepath.Join(dir, "dead.pid")
+	garbage := filepath.Join(dir, "garbage.pid")
+	os.WriteFile(dead, []byte(strconv.Itoa(exitedPID(t))+"\n"), 0o644)
+	os.WriteFile(garbage, []byte("not a pid"), 0o644)
+
+	for _, path := range []string{dead, garbage} {
+		if err := writePidFile(path, 4242, false); err != nil {
+			t.Errorf("writePidFile(%s): %v", filepath.Base(path), err)
+		}
+	}
+}
+
+func TestRemovePidFileLeavesOthers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "xvfb-run.pid")
+	if err := writePidFile(path, 4242, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := removePidFile(path, 1); err != nil {
+		t.Fatalf("removePidFile: %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("expected another wrapper's pid file to stay, got %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package xvfb
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// createAuthFile writes a fresh MIT-MAGIC-COOKIE-1 for display to a new
+// temporary Xauthority file and returns its path. The caller removes it.
+func createAuthFile(display string) (string, error) {
+	// 128 random bits in hex, the same format mcookie produces
+	cookie := make([]byte, 16)
+	if _, err := rand.Read(cookie); err != nil {
+		return "", fmt.Errorf("generating auth cookie: %w", err)
+	}
+
+	f, err := os.CreateTemp("", "xvfb-run.*.Xauthority")
+	if err != nil {
+		return "", err
+	}
+	path := f.Name()
+	f.Close()
+
+	if _, err := xauth(path, "", "add", display, ".", hex.EncodeToString(cookie)); err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
+
+// mergeAuthEntry copies display's entry in the Xauthority file from into
+// into, replacing any it has for display, e.g. one left by an earlier run.
+// Entries for other displays in into are left alone; into is created if
+// it doesn't exist.
+func mergeAuthEntry(display, from, into string) error {
+	entry, err := xauth(from, "", "nlist", display)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(entry) == "" {
+		return fmt.Errorf("%s has no entry for %s", from, display)
+	}
+	_, err = xauth(into, entry, "nmerge", "-")
+	return err
+}
+
+// removeAuthEntry removes display's entry from the Xauthority file
+// authFile, leaving those for other displays.
+func removeAuthEntry(display, authFile string) error {
+	_, err := xauth(authFile, "", "remove", display)
+	return err
+}
+
+// xauth runs xauth's command args on the Xauthority file authFile, with
+// input on its stdin, and returns what it prints.
+func xauth(authFile, input string, args ...string) (string, error) {
+	cmd := exec.Command("xauth", append([]string{"-q", "-f", authFile}, args...)...)
+	cmd.Stdin = strings.NewReader(input)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("xauth %s: %w: %s", args[0], err, msg)
+		}
+		return "", fmt.Errorf("xauth %s: %w", args[0], err)
+	}
+	return string(out), nil
+}
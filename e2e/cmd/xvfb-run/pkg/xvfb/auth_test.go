@@ -0,0 +1,118 @@
+package xvfb
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCreateAuthFile(t *testing.T) {
+	if _, err := exec.LookPath("xauth"); err != nil {
+		t.Skip("xauth not installed")
+	}
+
+	path, err := createAuthFile(":4242")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.Remove(path)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("auth file missing: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm&0o077 != 0 {
+		t.Errorf("expected auth file to be private, got mode %v", perm)
+	}
+
+	out, err := exec.Command("xauth", "-f", path, "list").Output()
+	if err != nil {
+		t.Fatalf("xauth list failed: %v", err)
+	}
+	if !strings.Contains(string(out), ":4242") || !strings.Contains(string(out), "MIT-MAGIC-COOKIE-1") {
+		t.Errorf("expected a cookie for :4242, got: %s", out)
+	}
+}
+
+func TestCreateAuthFileUsesAFreshCookieEachTime(t *testing.T) {
+	if _, err := exec.LookPath("xauth"); err != nil {
+		t.Skip("xauth not installed")
+	}
+
+	var cookies []string
+	for i := 0; i < 2; i++ {
+		path, err := createAuthFile(":4242")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer os.Remove(path)
+		out, err := exec.Command("xauth", "-f", path, "list").Output()
+		if err != nil {
+			t.Fatalf("xauth list failed: %v", err)
+		}
+		fields := strings.Fields(string(out))
+		cookies = append(cookies, fields[len(fields)-1])
+	}
+	if cookies[0] == cookies[1] {
+		t.Errorf("expected distinct cookies, both were %s", cookies[0])
+	}
+}
+
+// authEntries lists the entries of the Xauthority file path by display
+// number, e.g. ":4242", and cookie.
+func authEntries(t *testing.T, path string) map[string]string {
+	t.Helper()
+	out, err := exec.Command("xauth", "-f", path, "list").Output()
+	if err != nil {
+		t.Fatalf("xauth list failed: %v", err)
+	}
+	entries := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if fields := strings.Fields(line); len(fields) == 3 {
+			entries[fields[0][strings.LastIndex(fields[0], ":"):]] = fields[2]
+		}
+	}
+	return entries
+}
+
+func TestMergeAndRemoveAuthEntry(t *testing.T) {
+	if _, err := exec.LookPath("xauth"); err != nil {
+		t.Skip("xauth not installed")
+	}
+	user := filepath.Join(t.TempDir(), "Xauthority")
+	for display, cookie := range map[string]string{":1": "11111111111111111111111111111111", ":4242": "00000000000000000000000000000000"} {
+		if err := exec.Command("xauth", "-q", "-f", user, "add", display, ".", cookie).Run(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ours, err := createAuthFile(":4242")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(ours)
+
+	if err := mergeAuthEntry(":4242", ours, user); err != nil {
+		t.Fatal(err)
+	}
+	entries := authEntries(t, user)
+	if entries[":1"] != "11111111111111111111111111111111" {
+		t.Errorf("expected the entry for :1 to be kept, got %v", entries)
+	}
+	if want := authEntries(t, ours)[":4242"]; entries[":4242"] != want {
+		t.Errorf("expected the stale cookie for :4242 to be replaced with %s, got %v", want, entries)
+	}
+
+	if err := removeAuthEntry(":4242", user); err != nil {
+		t.Fatal(err)
+	}
+	entries = authEntries(t, user)
+	if _, ok := entries[":4242"]; ok || entries[":1"] == "" {
+		t.Errorf("expected only the entry for :1 to be left, got %v", entries)
+	}
+
+	if err := mergeAuthEntry(":7", ours, user); err == nil {
+		t.Error("expected an error merging a display the file has no entry for")
+	}
+}
@@ -0,0 +1,237 @@
+package xvfb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Server is an X server a Runner starts for its commands. Start launches
+// it, Ready waits until clients can connect to Display, the server gives
+// up or ctx is done, and Stop shuts it down and removes what it left
+// behind. PID is the process that serves the display.
+type Server interface {
+	Start() error
+	Ready(ctx context.Context, timeout time.Duration) error
+	Stop() error
+	Display() string
+	PID() int
+}
+
+// Backends for Runner.Backend.
+const (
+	// BackendXvfb runs Xvfb, or ServerBinary if Xvfb isn't installed.
+	BackendXvfb = "xvfb"
+	// BackendXwayland runs Xwayland on a headless Weston compositor, for
+	// images that ship Xwayland rather than Xvfb. Commands get
+	// WAYLAND_DISPLAY as well as DISPLAY.
+	BackendXwayland = "xwayland"
+)
+
+// compositorStartTimeout bounds how long an xwaylandServer waits for
+// Weston's socket before starting Xwayland.
+const compositorStartTimeout = 10 * time.Second
+
+// xwaylandServer is Xwayland on display, run like Xvfb, plus the headless
+// Weston it is a client of.
+type xwaylandServer struct {
+	xvfbServer
+	compositor *exec.Cmd
+	// compositorExited is monitorXvfb's channel for compositor
+	compositorExited <-chan error
+	runtimeDir       string
+	wayland          string
+}
+
+// Start launches Weston and, once its Wayland socket is up, Xwayland.
+func (s *xwaylandServer) Start() error {
+	if err := s.compositor.Start(); err != nil {
+		return fmt.Errorf("starting weston: %w", err)
+	}
+	s.compositorExited = monitorXvfb(s.compositor)
+	if err := s.waitForCompositor(compositorStartTimeout); err != nil {
+		s.stopCompositor()
+		return err
+	}
+	if err := s.xvfbServer.Start(); err != nil {
+		s.stopCompositor()
+		return err
+	}
+	return nil
+}
+
+// waitForCompositor blocks until Weston's socket exists, Weston exits or
+// timeout elapses.
+func (s *xwaylandServer) waitForCompositor(timeout time.Duration) error {
+	path := filepath.Join(s.runtimeDir, s.wayland)
+	deadline := time.Now().Add(timeout)
+	ticker := time.NewTicker(displayPollInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("weston's socket %s did not appear within %s", path, timeout)
+		}
+		select {
+		case err := <-s.compositorExited:
+			s.compositorExited = nil
+			if err == nil {
+				return errors.New("weston exited during startup")
+			}
+			return fmt.Errorf("weston exited during startup: %w", err)
+		case <-ticker.C:
+		}
+	}
+}
+
+// Stop terminates Xwayland, then Weston.
+func (s *xwaylandServer) Stop() error {
+	return errors.Join(s.xvfbServer.Stop(), s.stopCompositor())
+}
+
+func (s *xwaylandServer) stopCompositor() error {
+	if s.compositorExited == nil {
+		return nil
+	}
+	err := stopXvfb(s.compositor.Process, s.compositorExited, s.grace)
+	s.compositorExited = nil
+	return err
+}
+
+// newServer returns the Server for r.Backend on display, not yet started.
+// Its output, and Weston's, goes to out.
+func (r *Runner) newServer(display, authFile, geometry, fontPath string, out io.Writer) (Server, error) {
+	opts := r.serverOptions(display, authFile, geometry, fontPath)
+	if r.ReadyCheck != "" && r.ReadyCheck != ReadyCheckSocket && r.ReadyCheck != ReadyCheckConnect {
+		return nil, fmt.Errorf("xvfb: unknown ready check %q", r.ReadyCheck)
+	}
+	connect := r.ReadyCheck == ReadyCheckConnect
+	switch r.Backend {
+	case "", BackendXvfb:
+		bin, err := locateServer(r.XvfbPath, r.ServerBinary)
+		if err != nil {
+			return nil, err
+		}
+		if r.XvfbPath == "" && filepath.Base(bin) != "Xvfb" {
+			r.logf("Xvfb not found, using %s", bin)
+		}
+		cmd := exec.Command(bin, buildXvfbArgs(opts)...)
+		cmd.Stdout, cmd.Stderr = out, out
+		if r.SocketDir != "" {
+			cmd.Env = append(os.Environ(), "XDG_RUNTIME_DIR="+r.SocketDir)
+		}
+		return &xvfbServer{cmd: cmd, dir: r.x11Dir(), display: display, connect: connect, logf: r.Logf, grace: r.serverGrace(), nice: r.ServerNice}, nil
+	case BackendXwayland:
+		return r.newXwaylandServer(opts, connect, out)
+	}
+	return nil, fmt.Errorf("xvfb: unknown backend %q", r.Backend)
+}
+
+func (r *Runner) newXwaylandServer(opts options, connect bool, out io.Writer) (Server, error) {
+	if r.FramebufferDir != "" || len(r.Screens) > 1 {
+		return nil, errors.New("xvfb: the xwayland backend has one screen and no framebuffer files")
+	}
+	runtimeDir := r.SocketDir
+	if runtimeDir == "" {
+		runtimeDir = os.Getenv("XDG_RUNTIME_DIR")
+	}
+	if runtimeDir == "" {
+		return nil, errors.New("xvfb: the xwayland backend needs XDG_RUNTIME_DIR for Weston's socket")
+	}
+	xwayland, weston, err := lookupXwayland()
+	if err != nil {
+		return nil, err
+	}
+
+	wayland := "wayland-xvfb-run-" + strings.TrimPrefix(opts.display, ":")
+	env := append(os.Environ(), "XDG_RUNTIME_DIR="+runtimeDir)
+	compositor := exec.Command(weston, westonArgs(wayland, opts)...)
+	compositor.Env = env
+	compositor.Stdout, compositor.Stderr = out, out
+	cmd := exec.Command(xwayland, buildXwaylandArgs(opts)...)
+	cmd.Env = append(env, "WAYLAND_DISPLAY="+wayland)
+	cmd.Stdout, cmd.Stderr = out, out
+	return &xwaylandServer{
+		xvfbServer: xvfbServer{cmd: cmd, dir: r.x11Dir(), display: opts.display, connect: connect, logf: r.Logf, grace: r.serverGrace(), nice: r.ServerNice},
+		compositor: compositor,
+		runtimeDir: runtimeDir,
+		wayland:    wayland,
+	}, nil
+}
+
+// lookupXwayland finds Xwayland and the Weston it runs on in PATH.
+func lookupXwayland() (xwayland, weston string, err error) {
+	if xwayland, err = exec.LookPath("Xwayland"); err != nil {
+		return "", "", fmt.Errorf("%w: Xwayland isn't in PATH", ErrServerNotFound)
+	}
+	if weston, err = exec.LookPath("weston"); err != nil {
+		return "", "", fmt.Errorf("%w: weston isn't in PATH, Xwayland needs it as its compositor", ErrServerNotFound)
+	}
+	return xwayland, weston, nil
+}
+
+// serverOptions is what r puts on the server's command line for display.
+func (r *Runner) serverOptions(display, authFile, geometry, fontPath string) options {
+	return options{
+		display:         display,
+		authFile:        authFile,
+		fontPath:        fontPath,
+		noAccessControl: r.DisableAccessControl,
+		screenGeometry:  geometry,
+		screens:         r.Screens,
+		listenTCP:       r.ListenTCP,
+		depth:           r.Depth,
+		dpi:             r.DPI,
+		fbdir:           r.FramebufferDir,
+		glx:             r.GLX,
+		serverArgs:      r.ServerArgs,
+	}
+}
+
+// westonArgs runs Weston without any outputs but a virtual one the size of
+// the screen, listening on socket.
+func westonArgs(socket string, opts options) []string {
+	args := []string{"--backend=headless-backend.so", "--socket=" + socket, "--idle-time=0"}
+	geometry := opts.screenGeometry
+	if len(opts.screens) == 1 {
+		geometry = opts.screens[0].Geometry
+	}
+	if geometry == "" {
+		geometry = DefaultScreenGeometry
+	}
+	if w, rest, ok := strings.Cut(geometry, "x"); ok {
+		h, _, _ := strings.Cut(rest, "x")
+		args = append(args, "--width="+w, "--height="+h)
+	}
+	return args
+}
+
+// buildXwaylandArgs is buildXvfbArgs for Xwayland, which takes its screen
+// from the compositor and so has no -screen, -dpi or -fbdir. It always
+// has GLX.
+func buildXwaylandArgs(opts options) []string {
+	args := []string{opts.display}
+	if opts.authFile != "" {
+		args = append(args, "-auth", opts.authFile)
+	}
+	if opts.noAccessControl {
+		args = append(args, "-ac")
+	}
+	if opts.listenTCP {
+		args = append(args, "-listen", "tcp")
+	} else {
+		args = append(args, "-nolisten", "tcp")
+	}
+	if opts.fontPath != "" {
+		args = append(args, "-fp", opts.fontPath)
+	}
+	return append(args, opts.serverArgs...)
+}
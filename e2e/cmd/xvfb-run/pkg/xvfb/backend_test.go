@@ -0,0 +1,146 @@
+package xvfb
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fakeWeston stands in for a headless Weston: it creates the --socket
+// named on its command line in $XDG_RUNTIME_DIR and removes it on SIGTERM.
+const fakeWeston = `#!/bin/sh
+for arg; do
+	case $arg in --socket=*) socket=$XDG_RUNTIME_DIR/${arg#--socket=} ;; esac
+done
+[ -n "$FAKE_WESTON_FAIL" ] && { echo "$FAKE_WESTON_FAIL" >&2; exit 1; }
+trap 'rm -f "$socket"; exit 0' TERM
+touch "$socket"
+while :; do sleep 0.05; done
+`
+
+func fakeXwayland(args []string) int {
+	socket := filepath.Join(os.Getenv("XDG_RUNTIME_DIR"), os.Getenv("WAYLAND_DISPLAY"))
+	if _, err := os.Stat(socket); err != nil {
+		fmt.Fprintln(os.Stderr, "fake Xwayland: no compositor:", err)
+		return 1
+	}
+	return fakeXvfb(args)
+}
+
+// useFakeXwayland is useFakeXvfb for the xwayland backend. It returns the
+// directory to use as the Runner's SocketDir, which Weston's socket and
+// the X11 files share.
+func useFakeXwayland(t *testing.T) string {
+	t.Helper()
+	dir := useFakeXvfb(t)
+	bin := t.TempDir()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(exe, filepath.Join(bin, "Xwayland")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(bin, "weston"), []byte(fakeWeston), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return dir
+}
+
+func TestRunnerXwaylandBackend(t *testing.T) {
+	dir := useFakeXwayland(t)
+
+	var out bytes.Buffer
+	r := &Runner{Backend: BackendXwayland, SocketDir: dir, Display: ":8", Stdout: &out}
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if err := r.Run([]string{"sh", "-c", `echo "$DISPLAY $WAYLAND_DISPLAY"`}); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if got := out.String(); got != ":8 wayland-xvfb-run-8\n" {
+		t.Errorf("unexpected environment %q", got)
+	}
+	if err := r.Stop(); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	for _, path := range []string{filepath.Join(dir, "wayland-xvfb-run-8"), lockPath(dir, 8)} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed, got %v", path, err)
+		}
+	}
+}
+
+func TestRunnerXwaylandReportsCompositorFailure(t *testing.T) {
+	dir := useFakeXwayland(t)
+	t.Setenv("FAKE_WESTON_FAIL", "fatal: no backend")
+
+	var output bytes.Buffer
+	r := &Runner{Backend: BackendXwayland, SocketDir: dir, ServerOutput: &output}
+	err := r.Start()
+	if err == nil {
+		r.Stop()
+		t.Fatal("expected Start to fail without a compositor")
+	}
+	if !strings.Contains(err.Error(), "weston exited during startup") {
+		t.Errorf("unexpected error %v", err)
+	}
+	if !strings.Contains(output.String(), "fatal: no backend") {
+		t.Errorf("expected weston's output, got %q", output.String())
+	}
+}
+
+func TestRunnerXwaylandNeedsRuntimeDir(t *testing.T) {
+	useFakeXwayland(t)
+
+	r := &Runner{Backend: BackendXwayland}
+	if err := r.Start(); err == nil || !strings.Contains(err.Error(), "XDG_RUNTIME_DIR") {
+		r.Stop()
+		t.Errorf("expected an XDG_RUNTIME_DIR error, got %v", err)
+	}
+}
+
+func TestRunnerRejectsUnknownBackend(t *testing.T) {
+	useFakeXvfb(t)
+
+	r := &Runner{Backend: "xquartz"}
+	if err := r.Start(); err == nil || !strings.Contains(err.Error(), "unknown backend") {
+		r.Stop()
+		t.Errorf("expected an unknown backend error, got %v", err)
+	}
+}
+
+func TestWestonArgs(t *testing.T) {
+	got := westonArgs("wayland-1", options{screenGeometry: "800x600x24"})
+	want := []string{"--backend=headless-backend.so", "--socket=wayland-1", "--idle-time=0", "--width=800", "--height=600"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := westonArgs("wayland-1", options{}); !slices.Contains(got, "--width=1280") {
+		t.Errorf("expected the default geometry, got %q", got)
+	}
+}
+
+func TestBuildXwaylandArgs(t *testing.T) {
+	got := buildXwaylandArgs(options{display: ":5", authFile: "/tmp/auth", dpi: 96, serverArgs: []string{"-nocursor"}})
+	want := []string{":5", "-auth", "/tmp/auth", "-nolisten", "tcp", "-nocursor"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestPlanXwayland(t *testing.T) {
+	t.Setenv("PATH", "/nonexistent")
+	_, server, err := (&Runner{Backend: BackendXwayland, Display: ":5"}).Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Xwayland", ":5", "-auth", PlannedAuthFile, "-nolisten", "tcp"}; !slices.Equal(server, want) {
+		t.Errorf("got %q, want %q", server, want)
+	}
+}
@@ -0,0 +1,380 @@
+package xvfb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// x11TmpDir is where Xvfb keeps its lock files and .X11-unix sockets
+// unless a Runner's SocketDir says otherwise. The helpers below take that
+// directory as dir.
+var x11TmpDir = "/tmp"
+
+// maxDisplayScan is how many display numbers findFreeDisplay tries when
+// no upper bound is given.
+const maxDisplayScan = 100
+
+// The registry holds the displays Runners in this process have reserved,
+// so two of them started from different goroutines never pick the same
+// one. Lock files and claim files do the same between processes. Each
+// entry is keyed by claimPath and, for an auto-allocated display, holds
+// the claim file backing it; a pinned display has none.
+var (
+	registryMu sync.Mutex
+	registry   = map[string]*os.File{}
+)
+
+// findFreeDisplay returns the first display number from first to last,
+// inclusive, with neither an X socket nor a lock file, the same way
+// xvfb-run -a does, and claims it. Displays reserved in this process or
+// claimed by another are skipped.
+func findFreeDisplay(dir string, first, last int) (int, error) {
+	for n := first; n <= last; n++ {
+		if displayInUse(dir, n) {
+			continue
+		}
+		// Two invocations can see the same free number at once; only the
+		// one that wins the claim gets to use it.
+		if !claimDisplay(dir, n) {
+			continue
+		}
+		if displayInUse(dir, n) {
+			releaseDisplay(dir, n)
+			continue
+		}
+		return n, nil
+	}
+	return 0, fmt.Errorf("no free display between :%d and :%d", first, last)
+}
+
+// DisplayString is the DISPLAY value for display number n.
+func DisplayString(n int) string {
+	return fmt.Sprintf(":%d", n)
+}
+
+// DisplayNumber returns N for a display written as "N", ":N", ":N.S" or
+// "host:N.S".
+func DisplayNumber(display string) (int, error) {
+	_, n, err := normalizeDisplay(display)
+	return n, err
+}
+
+// normalizeDisplay parses a display as users write it: "99", ":99",
+// ":99.0" or "localhost:99", and returns the canonical ":N" used to find
+// its lock file and socket along with N. Any host prefix and screen suffix
+// are dropped, so callers that hand the display to a client should keep
+// the original string.
+func normalizeDisplay(s string) (display string, num int, err error) {
+	rest := s
+	if i := strings.LastIndex(rest, ":"); i >= 0 {
+		rest = rest[i+1:]
+	} else if strings.Contains(rest, ".") {
+		// A bare number has no screen
+		return "", 0, fmt.Errorf("invalid display %q", s)
+	}
+	if number, screen, ok := strings.Cut(rest, "."); ok {
+		if _, err := strconv.ParseUint(screen, 10, 32); err != nil {
+			return "", 0, fmt.Errorf("invalid display %q: bad screen number", s)
+		}
+		rest = number
+	}
+	n, err := strconv.ParseUint(rest, 10, 31)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid display %q", s)
+	}
+	return DisplayString(int(n)), int(n), nil
+}
+
+// SocketPath is the socket of display n in socketDir, /tmp if empty, as
+// Runner.SocketDir and --socket-dir set it.
+func SocketPath(socketDir string, n int) string {
+	if socketDir == "" {
+		socketDir = x11TmpDir
+	}
+	return socketPath(socketDir, n)
+}
+
+func socketPath(dir string, n int) string {
+	return filepath.Join(dir, ".X11-unix", fmt.Sprintf("X%d", n))
+}
+
+func lockPath(dir string, n int) string {
+	return filepath.Join(dir, fmt.Sprintf(".X%d-lock", n))
+}
+
+// errDisplayTaken means another X server holds the display's lock.
+var errDisplayTaken = errors.New("server already active for this display")
+
+// errServerExited means Xvfb went away before its display was ready.
+var errServerExited = errors.New("Xvfb exited during startup")
+
+// LockOwner returns the PID recorded in display's lock file in socketDir,
+// /tmp if empty, so a caller holding a PID from earlier can tell whether
+// that server still has the display. A missing lock file is an error
+// satisfying errors.Is(err, os.ErrNotExist).
+func LockOwner(socketDir, display string) (int, error) {
+	n, err := DisplayNumber(display)
+	if err != nil {
+		return 0, err
+	}
+	if socketDir == "" {
+		socketDir = x11TmpDir
+	}
+	return lockOwner(socketDir, n)
+}
+
+// lockOwner returns the PID recorded in the lock file for display n.
+func lockOwner(dir string, n int) (int, error) {
+	data, err := os.ReadFile(lockPath(dir, n))
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("malformed lock file %s", lockPath(dir, n))
+	}
+	return pid, nil
+}
+
+// ownsLock reports whether pid holds the lock for display n. A missing or
+// unreadable lock file gives no evidence of another owner.
+func ownsLock(dir string, n, pid int) bool {
+	owner, err := lockOwner(dir, n)
+	return err != nil || owner == pid
+}
+
+// isStaleLock reports whether display's lock file was left behind by an X
+// server that is no longer running. A missing lock is not stale, and a lock
+// whose owner can't be signalled for lack of permission is alive.
+func isStaleLock(dir string, display string) (bool, error) {
+	n, err := DisplayNumber(display)
+	if err != nil {
+		return false, err
+	}
+	pid, err := lockOwner(dir, n)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if pid <= 0 {
+		return false, fmt.Errorf("malformed lock file %s", lockPath(dir, n))
+	}
+	return errors.Is(syscall.Kill(pid, 0), syscall.ESRCH), nil
+}
+
+// removeStaleLock deletes the lock file and socket of display n. Callers
+// must have checked isStaleLock first.
+func removeStaleLock(dir string, n int) error {
+	var errs []error
+	for _, path := range []string{lockPath(dir, n), socketPath(dir, n)} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// cleanupDisplayFiles removes the socket and lock file that Xvfb with the
+// given pid left behind for display, e.g. when a container kills it before
+// it tidies up. It must only be called once that server has exited. Files
+// locked by any other process, such as a server that took the display
+// since, are left alone.
+func cleanupDisplayFiles(dir string, display string, pid int) error {
+	n, err := DisplayNumber(display)
+	if err != nil {
+		return err
+	}
+	owner, err := lockOwner(dir, n)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		// Xvfb removed its lock but maybe not its socket
+	case err != nil || owner != pid:
+		return nil
+	}
+	return removeStaleLock(dir, n)
+}
+
+// freeDisplayPollInterval is how often waitForFreeDisplay checks whether
+// the display's server has gone.
+const freeDisplayPollInterval = 200 * time.Millisecond
+
+// waitForFreeDisplay blocks until display (":N") has neither a lock file nor
+// a socket, or timeout elapses. A lock left by a server that died is removed
+// rather than waited on.
+func waitForFreeDisplay(dir string, display string, timeout time.Duration) error {
+	n, err := DisplayNumber(display)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		if stale, err := isStaleLock(dir, display); err == nil && stale {
+			if err := removeStaleLock(dir, n); err != nil {
+				return err
+			}
+		}
+		if !displayInUse(dir, n) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("display %s is still in use after %s (found %s or %s)", display, timeout, lockPath(dir, n), socketPath(dir, n))
+		}
+		time.Sleep(freeDisplayPollInterval)
+	}
+}
+
+func displayInUse(dir string, n int) bool {
+	for _, path := range []string{socketPath(dir, n), lockPath(dir, n)} {
+		if _, err := os.Lstat(path); err == nil || !errors.Is(err, os.ErrNotExist) {
+			return true
+		}
+	}
+	return false
+}
+
+// reserveDisplay reserves display n in dir for the calling Runner, or
+// reports false if another Runner in this process has it.
+func reserveDisplay(dir string, n int) bool {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	path := claimPath(dir, n)
+	if _, ok := registry[path]; ok {
+		return false
+	}
+	registry[path] = nil
+	return true
+}
+
+// releaseDisplay gives up a reservation taken by reserveDisplay or
+// claimDisplay, along with its claim file's lock.
+func releaseDisplay(dir string, n int) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	path := claimPath(dir, n)
+	if f, ok := registry[path]; ok {
+		if f != nil {
+			f.Close()
+		}
+		delete(registry, path)
+	}
+}
+
+// claimDisplay reserves display n and takes an exclusive flock on its
+// claim file, so other processes skip it too. The lock is held until
+// releaseDisplay or until the process exits, when the kernel drops it, so
+// a crashed run never leaves a display claimed. The file is opened read
+// only, which is all flock needs, so claim files another user created
+// can be locked too.
+func claimDisplay(dir string, n int) bool {
+	if !reserveDisplay(dir, n) {
+		return false
+	}
+	f, err := os.OpenFile(claimPath(dir, n), os.O_CREATE|os.O_RDONLY, 0o444)
+	if err != nil {
+		releaseDisplay(dir, n)
+		return false
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		releaseDisplay(dir, n)
+		return false
+	}
+	registryMu.Lock()
+	registry[claimPath(dir, n)] = f
+	registryMu.Unlock()
+	return true
+}
+
+func claimPath(dir string, n int) string {
+	return filepath.Join(dir, fmt.Sprintf(".xvfb-run-%d.claim", n))
+}
+
+// displayPollInterval is how often waitForDisplay checks for the socket.
+// With watchSocket it only checks every displayWatchPollInterval, in case
+// the watch misses the socket, e.g. on a file system without inotify
+// support.
+const (
+	displayPollInterval      = 50 * time.Millisecond
+	displayWatchPollInterval = time.Second
+)
+
+// displayProgressInterval is how often waiting for a display says it is
+// still waiting.
+var displayProgressInterval = 2 * time.Second
+
+// waitForDisplay blocks until the X socket for display (":N") exists,
+// timeout elapses or ctx is done, in which case it returns ctx.Err().
+func waitForDisplay(ctx context.Context, dir string, display string, timeout time.Duration) error {
+	return waitForDisplayOrExit(ctx, dir, display, timeout, nil, nil)
+}
+
+// waitForDisplayOrExit is waitForDisplay that also gives up as soon as
+// exited delivers, returning errServerExited wrapped around its value. If
+// logf is set, it is told every displayProgressInterval that the display
+// isn't up yet.
+func waitForDisplayOrExit(ctx context.Context, dir string, display string, timeout time.Duration, exited <-chan error, logf func(string, ...any)) error {
+	n, err := DisplayNumber(display)
+	if err != nil {
+		return err
+	}
+	path := socketPath(dir, n)
+	began := time.Now()
+	deadline := began.Add(timeout)
+	interval := displayPollInterval
+	changed, stopWatch, err := watchSocket(path)
+	if err == nil {
+		defer stopWatch()
+		interval = displayWatchPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	expired := time.NewTimer(timeout)
+	defer expired.Stop()
+	progress, stopProgress := progressTicker(logf)
+	defer stopProgress()
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return withKind(ErrDisplayTimeout, fmt.Errorf("%s did not appear within %s", path, timeout))
+		}
+		select {
+		case err := <-exited:
+			if err == nil {
+				return errServerExited
+			}
+			return fmt.Errorf("%w: %w", errServerExited, err)
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-progress:
+			logf("Still waiting for display %s (%s so far)", display, time.Since(began).Round(time.Second))
+		case <-changed:
+		case <-expired.C:
+		case <-ticker.C:
+		}
+	}
+}
+
+// progressTicker returns a channel that ticks every
+// displayProgressInterval, or nil, which never delivers, if there is no
+// logf to tell.
+func progressTicker(logf func(string, ...any)) (<-chan time.Time, func()) {
+	if logf == nil {
+		return nil, func() {}
+	}
+	t := time.NewTicker(displayProgressInterval)
+	return t.C, t.Stop
+}
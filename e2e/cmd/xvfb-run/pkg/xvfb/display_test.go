@@ -0,0 +1,424 @@
+package xvfb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// useTmpDir points the X11 file lookups at a fresh directory for one test.
+func useTmpDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, ".X11-unix"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	old := x11TmpDir
+	x11TmpDir = dir
+	t.Cleanup(func() { x11TmpDir = old })
+	return dir
+}
+
+func touch(t *testing.T, path string) {
+	t.Helper()
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindFreeDisplaySkipsLockAndSocket(t *testing.T) {
+	dir := useTmpDir(t)
+	touch(t, filepath.Join(dir, ".X1000-lock"))
+	touch(t, filepath.Join(dir, ".X11-unix", "X1001"))
+
+	n, err := findFreeDisplay(dir, 1000, 1099)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != 1002 {
+		t.Errorf("expected display 1002, got %d", n)
+	}
+}
+
+func TestFindFreeDisplayNeverHandsOutTheSameNumberTwice(t *testing.T) {
+	dir := useTmpDir(t)
+
+	first, err := findFreeDisplay(dir, 2000, 2099)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second, err := findFreeDisplay(dir, 2000, 2099)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if first == second {
+		t.Errorf("expected distinct displays, both got %d", first)
+	}
+}
+
+func TestFindFreeDisplaySkipsDisplayClaimedByAnotherProcess(t *testing.T) {
+	dir := useTmpDir(t)
+
+	// Simulate another xvfb-run that picked :3000 but has not started Xvfb yet.
+	f, err := os.Create(filepath.Join(dir, ".xvfb-run-3000.claim"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := findFreeDisplay(dir, 3000, 3099)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != 3001 {
+		t.Errorf("expected display 3001, got %d", n)
+	}
+}
+
+func TestFindFreeDisplayClaimsReadOnlyClaimFile(t *testing.T) {
+	dir := useTmpDir(t)
+
+	// Left behind by another user's run, which this one can't write to.
+	path := filepath.Join(dir, ".xvfb-run-3100.claim")
+	if err := os.WriteFile(path, nil, 0o444); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := findFreeDisplay(dir, 3100, 3199)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer releaseDisplay(dir, n)
+	if n != 3100 {
+		t.Errorf("expected display 3100, got %d", n)
+	}
+}
+
+func TestReserveDisplay(t *testing.T) {
+	dir := useTmpDir(t)
+
+	if !reserveDisplay(dir, 3500) {
+		t.Fatal("expected to reserve a free display")
+	}
+	if reserveDisplay(dir, 3500) {
+		t.Error("expected a reserved display to be refused")
+	}
+	if n, err := findFreeDisplay(dir, 3500, 3501); err != nil || n != 3501 {
+		t.Errorf("findFreeDisplay(3500, 3501) = %d, %v; want 3501 with 3500 reserved", n, err)
+	}
+	releaseDisplay(dir, 3500)
+	releaseDisplay(dir, 3501)
+	if !reserveDisplay(dir, 3500) {
+		t.Error("expected a released display to be free again")
+	}
+	releaseDisplay(dir, 3500)
+}
+
+func TestFindFreeDisplayErrorsWhenRangeIsFull(t *testing.T) {
+	dir := useTmpDir(t)
+	for n := 4000; n < 4000+maxDisplayScan; n++ {
+		touch(t, filepath.Join(dir, fmt.Sprintf(".X%d-lock", n)))
+	}
+
+	if _, err := findFreeDisplay(dir, 4000, 4099); err == nil {
+		t.Fatal("expected an error when every display is taken")
+	}
+}
+
+func TestFindFreeDisplayStaysInRange(t *testing.T) {
+	dir := useTmpDir(t)
+	for _, n := range []int{4500, 4501, 4503} {
+		touch(t, filepath.Join(dir, fmt.Sprintf(".X%d-lock", n)))
+	}
+
+	n, err := findFreeDisplay(dir, 4500, 4503)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != 4502 {
+		t.Errorf("expected display 4502, got %d", n)
+	}
+
+	_, err = findFreeDisplay(dir, 4503, 4503)
+	if err == nil || err.Error() != "no free display between :4503 and :4503" {
+		t.Errorf("expected the range to be full, got %v", err)
+	}
+	if n, err := findFreeDisplay(dir, 4501, 4504); err != nil || n != 4504 {
+		t.Errorf("findFreeDisplay(4501, 4504) = %d, %v; want 4504 with 4502 claimed", n, err)
+	}
+}
+
+func TestWaitForDisplayReturnsOnceSocketAppears(t *testing.T) {
+	dir := useTmpDir(t)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		os.WriteFile(filepath.Join(dir, ".X11-unix", "X5000"), nil, 0o644)
+	}()
+
+	if err := waitForDisplay(context.Background(), dir, ":5000", 2*time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestWaitForDisplayTimesOut(t *testing.T) {
+	dir := useTmpDir(t)
+
+	err := waitForDisplay(context.Background(), dir, ":5001", 100*time.Millisecond)
+	if err == nil {
+		t.Fatal("expected a timeout error")
+	}
+	if !strings.Contains(err.Error(), "X5001") {
+		t.Errorf("expected error to name the socket, got: %v", err)
+	}
+}
+
+func TestWaitForDisplayStopsWhenContextIsDone(t *testing.T) {
+	dir := useTmpDir(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	began := time.Now()
+	err := waitForDisplay(ctx, dir, ":5002", 10*time.Second)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected ctx.Err(), got %v", err)
+	}
+	if waited := time.Since(began); waited > 2*time.Second {
+		t.Errorf("expected to stop with ctx, waited %s", waited)
+	}
+}
+
+func TestWaitForDisplayReportsProgress(t *testing.T) {
+	dir := useTmpDir(t)
+	old := displayProgressInterval
+	displayProgressInterval = 20 * time.Millisecond
+	t.Cleanup(func() { displayProgressInterval = old })
+
+	var messages []string
+	logf := func(format string, args ...any) {
+		messages = append(messages, fmt.Sprintf(format, args...))
+	}
+	waitForDisplayOrExit(context.Background(), dir, ":5003", 150*time.Millisecond, nil, logf)
+
+	if len(messages) == 0 || !strings.HasPrefix(messages[0], "Still waiting for display :5003") {
+		t.Errorf("expected progress messages, got %q", messages)
+	}
+}
+
+func TestWaitForDisplayRejectsInvalidDisplay(t *testing.T) {
+	if err := waitForDisplay(context.Background(), t.TempDir(), "bogus", time.Second); err == nil {
+		t.Fatal("expected an error for an invalid display")
+	}
+}
+
+func TestDisplayString(t *testing.T) {
+	for n, want := range map[int]string{0: ":0", 99: ":99", 1024: ":1024"} {
+		if got := DisplayString(n); got != want {
+			t.Errorf("DisplayString(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestDisplayNumber(t *testing.T) {
+	if n, err := DisplayNumber(":42"); err != nil || n != 42 {
+		t.Errorf("DisplayNumber(\":42\") = %d, %v; want 42, nil", n, err)
+	}
+	for _, bad := range []string{"", ":", ":x", ":-1"} {
+		if _, err := DisplayNumber(bad); err == nil {
+			t.Errorf("DisplayNumber(%q): expected an error", bad)
+		}
+	}
+}
+
+func TestNormalizeDisplay(t *testing.T) {
+	for _, tt := range []struct {
+		in      string
+		display string
+		num     int
+	}{
+		{":99", ":99", 99},
+		{"99", ":99", 99},
+		{":99.0", ":99", 99},
+		{":0.1", ":0", 0},
+		{"localhost:99", ":99", 99},
+		{"localhost:99.0", ":99", 99},
+		{"unix:7", ":7", 7},
+		{"127.0.0.1:10", ":10", 10},
+	} {
+		display, num, err := normalizeDisplay(tt.in)
+		if err != nil || display != tt.display || num != tt.num {
+			t.Errorf("normalizeDisplay(%q) = %q, %d, %v; want %q, %d", tt.in, display, num, err, tt.display, tt.num)
+		}
+	}
+	for _, bad := range []string{"", ":", "localhost:", ":x", ":-1", "-1", ":99.", ":99.x", ":99.0.0", "99.0", "host", ":+5", ":99999999999"} {
+		if _, _, err := normalizeDisplay(bad); err == nil {
+			t.Errorf("normalizeDisplay(%q): expected an error", bad)
+		}
+	}
+}
+
+func TestLockOwner(t *testing.T) {
+	dir := useTmpDir(t)
+	if err := os.WriteFile(filepath.Join(dir, ".X6000-lock"), []byte("      4242\n"), 0o444); err != nil {
+		t.Fatal(err)
+	}
+	touch(t, filepath.Join(dir, ".X6001-lock"))
+
+	if pid, err := lockOwner(dir, 6000); err != nil || pid != 4242 {
+		t.Errorf("lockOwner(6000) = %d, %v; want 4242, nil", pid, err)
+	}
+	if _, err := lockOwner(dir, 6001); err == nil {
+		t.Error("expected an error for an empty lock file")
+	}
+	if _, err := lockOwner(dir, 6002); err == nil {
+		t.Error("expected an error for a missing lock file")
+	}
+	if !ownsLock(dir, 6000, 4242) || ownsLock(dir, 6000, 1) {
+		t.Error("ownsLock disagrees with the lock file")
+	}
+	if !ownsLock(dir, 6002, 1) {
+		t.Error("expected a missing lock file not to count against the caller")
+	}
+	if pid, err := LockOwner("", ":6000"); err != nil || pid != 4242 {
+		t.Errorf("LockOwner(:6000) = %d, %v; want 4242, nil", pid, err)
+	}
+	if _, err := LockOwner(dir, ":6002"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected a missing lock file to be os.ErrNotExist, got %v", err)
+	}
+}
+
+// deadPID returns the PID of a process that has already exited.
+func deadPID(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return cmd.Process.Pid
+}
+
+func writeLock(t *testing.T, dir string, n, pid int) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf(".X%d-lock", n)), []byte(fmt.Sprintf("%10d\n", pid)), 0o444); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIsStaleLock(t *testing.T) {
+	dir := useTmpDir(t)
+	writeLock(t, dir, 7000, deadPID(t))
+	writeLock(t, dir, 7001, os.Getpid())
+	touch(t, filepath.Join(dir, ".X7002-lock"))
+
+	if stale, err := isStaleLock(dir, ":7000"); err != nil || !stale {
+		t.Errorf("isStaleLock(:7000) = %v, %v; want true for a dead owner", stale, err)
+	}
+	if stale, err := isStaleLock(dir, ":7001"); err != nil || stale {
+		t.Errorf("isStaleLock(:7001) = %v, %v; want false for a live owner", stale, err)
+	}
+	if _, err := isStaleLock(dir, ":7002"); err == nil {
+		t.Error("expected an error for a malformed lock file")
+	}
+	if stale, err := isStaleLock(dir, ":7003"); err != nil || stale {
+		t.Errorf("isStaleLock(:7003) = %v, %v; want false without a lock", stale, err)
+	}
+	if _, err := isStaleLock(dir, ":x7000"); err == nil {
+		t.Error("expected an error for an invalid display")
+	}
+}
+
+func TestWaitForFreeDisplay(t *testing.T) {
+	dir := useTmpDir(t)
+	writeLock(t, dir, 7000, os.Getpid())
+	time.AfterFunc(300*time.Millisecond, func() { os.Remove(lockPath(dir, 7000)) })
+
+	if err := waitForFreeDisplay(dir, ":7000", 5*time.Second); err != nil {
+		t.Fatalf("waitForFreeDisplay: %v", err)
+	}
+}
+
+func TestWaitForFreeDisplayTimesOut(t *testing.T) {
+	dir := useTmpDir(t)
+	writeLock(t, dir, 7000, os.Getpid())
+
+	start := time.Now()
+	err := waitForFreeDisplay(dir, ":7000", 300*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "still in use") {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("gave up after only %s", elapsed)
+	}
+}
+
+func TestWaitForFreeDisplayRemovesStaleLock(t *testing.T) {
+	dir := useTmpDir(t)
+	writeLock(t, dir, 7000, deadPID(t))
+	touch(t, socketPath(dir, 7000))
+
+	start := time.Now()
+	if err := waitForFreeDisplay(dir, ":7000", 5*time.Second); err != nil {
+		t.Fatalf("waitForFreeDisplay: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected no wait on a dead server's lock, took %s", elapsed)
+	}
+	if displayInUse(dir, 7000) {
+		t.Error("expected the stale lock and socket to be removed")
+	}
+}
+
+func TestRemoveStaleLock(t *testing.T) {
+	dir := useTmpDir(t)
+	writeLock(t, dir, 7000, deadPID(t))
+	touch(t, filepath.Join(dir, ".X11-unix", "X7000"))
+
+	if err := removeStaleLock(dir, 7000); err != nil {
+		t.Fatalf("removeStaleLock: %v", err)
+	}
+	if displayInUse(dir, 7000) {
+		t.Error("expected the lock and socket to be gone")
+	}
+}
+
+func TestCleanupDisplayFiles(t *testing.T) {
+	dir := useTmpDir(t)
+	pid := deadPID(t)
+	writeLock(t, dir, 7000, pid)
+	touch(t, filepath.Join(dir, ".X11-unix", "X7000"))
+	// Xvfb removed its lock but not the socket
+	touch(t, filepath.Join(dir, ".X11-unix", "X7001"))
+	// Another server took the display since
+	writeLock(t, dir, 7002, os.Getpid())
+	touch(t, filepath.Join(dir, ".X11-unix", "X7002"))
+
+	for _, display := range []string{":7000", ":7001", ":7002"} {
+		if err := cleanupDisplayFiles(dir, display, pid); err != nil {
+			t.Fatalf("cleanupDisplayFiles(%s): %v", display, err)
+		}
+	}
+	if displayInUse(dir, 7000) || displayInUse(dir, 7001) {
+		t.Error("expected the server's leftover files to be removed")
+	}
+	if _, err := os.Stat(lockPath(dir, 7002)); err != nil {
+		t.Errorf("expected another server's lock to stay, got %v", err)
+	}
+	if _, err := os.Stat(socketPath(dir, 7002)); err != nil {
+		t.Errorf("expected another server's socket to stay, got %v", err)
+	}
+}
+
+func TestCleanupDisplayFilesInvalidDisplay(t *testing.T) {
+	if err := cleanupDisplayFiles(t.TempDir(), ":seven", 1); err == nil {
+		t.Error("expected an error for an invalid display")
+	}
+}
@@ -0,0 +1,60 @@
+package xvfb
+
+import (
+	"errors"
+	"io/fs"
+	"os/exec"
+)
+
+// What can go wrong, for callers to tell apart with errors.Is. Errors from
+// Start and Run wrap at most one of these on top of the underlying cause,
+// whose message they keep.
+var (
+	// ErrServerNotFound means the X server the Runner needs isn't
+	// installed. Start and CheckServer return it.
+	ErrServerNotFound = errors.New("xvfb: no X server found")
+	// ErrXvfbStart means the X server couldn't be launched or exited
+	// before its display was ready, e.g. over bad arguments or because
+	// another server took the display.
+	ErrXvfbStart = errors.New("xvfb: X server failed to start")
+	// ErrDisplayTimeout means the X server started but its display wasn't
+	// ready within ReadyTimeout.
+	ErrDisplayTimeout = errors.New("xvfb: display not ready in time")
+	// ErrCommandNotFound means Run couldn't find the command to run.
+	ErrCommandNotFound = errors.New("xvfb: command not found")
+	// ErrCommandFailed means the command ran and exited non-zero or was
+	// killed. The error also wraps its *exec.ExitError.
+	ErrCommandFailed = errors.New("xvfb: command failed")
+	// ErrTimeout means Run killed the command for outliving Timeout.
+	ErrTimeout = errors.New("xvfb: command timed out")
+)
+
+// kindError is err classified as kind without changing its message.
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string   { return e.err.Error() }
+func (e *kindError) Unwrap() []error { return []error{e.kind, e.err} }
+
+// withKind returns err classified as kind, or nil if err is nil.
+func withKind(kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &kindError{kind: kind, err: err}
+}
+
+// commandError classifies an error from starting or waiting for a
+// command.
+func commandError(err error) error {
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		return withKind(ErrCommandFailed, err)
+	case errors.Is(err, exec.ErrNotFound), errors.Is(err, fs.ErrNotExist):
+		return withKind(ErrCommandNotFound, err)
+	}
+	return err
+}
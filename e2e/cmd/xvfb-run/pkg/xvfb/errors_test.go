@@ -0,0 +1,86 @@
+package xvfb
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestWithKindKeepsMessage(t *testing.T) {
+	cause := errors.New("exit status 3")
+	err := withKind(ErrCommandFailed, cause)
+
+	if err.Error() != "exit status 3" {
+		t.Errorf("expected the cause's message, got %q", err)
+	}
+	if !errors.Is(err, ErrCommandFailed) || !errors.Is(err, cause) {
+		t.Errorf("expected %v to be both the kind and the cause", err)
+	}
+	if withKind(ErrCommandFailed, nil) != nil {
+		t.Error("expected no error for a nil cause")
+	}
+}
+
+func TestStartErrorsAreClassified(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		r    *Runner
+		want error
+	}{
+		{"server exits", map[string]string{"FAKE_XVFB_FAIL": "(EE) Unrecognized option: -bogus"}, &Runner{}, ErrXvfbStart},
+		{"display taken", map[string]string{"FAKE_XVFB_TAKEN": "all"}, &Runner{Display: ":57"}, ErrXvfbStart},
+		{"never answers", map[string]string{"FAKE_XVFB_MUTE": "1"}, &Runner{ReadyCheck: ReadyCheckConnect, ReadyTimeout: 300 * time.Millisecond}, ErrDisplayTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFakeXvfb(t)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			err := tt.r.Start()
+			if err == nil {
+				tt.r.Stop()
+				t.Fatal("expected Start to fail")
+			}
+			if !errors.Is(err, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, err)
+			}
+			for _, other := range []error{ErrXvfbStart, ErrDisplayTimeout, ErrServerNotFound} {
+				if other != tt.want && errors.Is(err, other) {
+					t.Errorf("expected only %v, but %v is also %v", tt.want, err, other)
+				}
+			}
+		})
+	}
+}
+
+func TestRunErrorsAreClassified(t *testing.T) {
+	useFakeXvfb(t)
+
+	r := &Runner{}
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer r.Stop()
+
+	if err := r.Run([]string{"no-such-command-here"}); !errors.Is(err, ErrCommandNotFound) {
+		t.Errorf("expected ErrCommandNotFound, got %v", err)
+	}
+	if err := r.RunCmd(exec.Command("/no/such/command")); !errors.Is(err, ErrCommandNotFound) {
+		t.Errorf("expected ErrCommandNotFound from RunCmd, got %v", err)
+	}
+
+	err := r.Run([]string{"sh", "-c", "exit 3"})
+	var exitErr *exec.ExitError
+	if !errors.Is(err, ErrCommandFailed) || !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+		t.Errorf("expected ErrCommandFailed with exit status 3, got %v", err)
+	}
+	if err := r.RunCmd(exec.Command("sh", "-c", "exit 1")); !errors.Is(err, ErrCommandFailed) {
+		t.Errorf("expected ErrCommandFailed from RunCmd, got %v", err)
+	}
+	if err := r.Run([]string{"true"}); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
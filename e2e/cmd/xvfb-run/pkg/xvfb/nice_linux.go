@@ -0,0 +1,40 @@
+package xvfb
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"syscall"
+)
+
+// startNiced starts cmd with niceness nice, from -20 (most favorable) to
+// 19, or inherits the caller's if nice is 0. Go can't run code in the
+// child between fork and exec, but on Linux niceness belongs to a thread
+// and a forked process gets that of the thread that forked it, so cmd is
+// started from a thread of its own that is niced first and thrown away
+// after. Going below the caller's niceness needs CAP_SYS_NICE, or a high
+// enough RLIMIT_NICE.
+func startNiced(cmd *exec.Cmd, nice int) error {
+	if nice == 0 {
+		return cmd.Start()
+	}
+	done := make(chan error, 1)
+	go func() {
+		// Never unlocked, so the thread exits with the goroutine
+		runtime.LockOSThread()
+		if syscall.Gettid() == syscall.Getpid() {
+			// The main thread is kept rather than thrown away, and is
+			// what the process's niceness is read from. Holding it
+			// makes the retry run on another.
+			defer runtime.UnlockOSThread()
+			done <- startNiced(cmd, nice)
+			return
+		}
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, 0, nice); err != nil {
+			done <- fmt.Errorf("xvfb: setting niceness %d: %w", nice, err)
+			return
+		}
+		done <- cmd.Start()
+	}()
+	return <-done
+}
@@ -0,0 +1,72 @@
+package xvfb
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// niceOf reads the niceness of process pid from /proc.
+func niceOf(t *testing.T, pid int) int {
+	t.Helper()
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The fields after the command name, which is in parentheses, start
+	// with the state; niceness is the 19th field of all
+	fields := strings.Fields(string(data[strings.LastIndexByte(string(data), ')')+1:]))
+	nice, err := strconv.Atoi(fields[16])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return nice
+}
+
+func TestStartNiced(t *testing.T) {
+	own := niceOf(t, os.Getpid())
+	for _, nice := range []int{own + 5, -5} {
+		cmd := exec.Command("sleep", "30")
+		if err := startNiced(cmd, nice); errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EACCES) {
+			t.Logf("not permitted to set niceness %d: %v", nice, err)
+			continue
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		got := niceOf(t, cmd.Process.Pid)
+		cmd.Process.Kill()
+		cmd.Wait()
+		if got != nice {
+			t.Errorf("expected niceness %d, got %d", nice, got)
+		}
+	}
+	if got := niceOf(t, os.Getpid()); got != own {
+		t.Errorf("expected the caller to keep niceness %d, got %d", own, got)
+	}
+}
+
+func TestRunnerNiceness(t *testing.T) {
+	useFakeXvfb(t)
+	own := niceOf(t, os.Getpid())
+
+	var out strings.Builder
+	r := &Runner{ServerNice: own + 7, CommandNice: own + 3, ReadyTimeout: 5 * time.Second, Stdout: &out}
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer r.Stop()
+	if got := niceOf(t, r.ServerPID()); got != own+7 {
+		t.Errorf("expected Xvfb at niceness %d, got %d", own+7, got)
+	}
+	if err := r.Run([]string{"sh", "-c", "cut -d' ' -f19 /proc/$$/stat"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(out.String()); got != strconv.Itoa(own+3) {
+		t.Errorf("expected the command at niceness %d, got %s", own+3, got)
+	}
+}
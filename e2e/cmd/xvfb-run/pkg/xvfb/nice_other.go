@@ -0,0 +1,17 @@
+//go:build !linux
+
+package xvfb
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// startNiced starts cmd. Elsewhere niceness belongs to the whole process,
+// so a nonzero nice, which only Linux can give cmd alone, is an error.
+func startNiced(cmd *exec.Cmd, nice int) error {
+	if nice != 0 {
+		return errors.New("xvfb: setting niceness is only supported on Linux")
+	}
+	return cmd.Start()
+}
@@ -0,0 +1,84 @@
+package xvfb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultProbeAttempts and DefaultProbeInterval are what Probe uses when
+// given zero.
+const (
+	DefaultProbeAttempts = 5
+	DefaultProbeInterval = 500 * time.Millisecond
+)
+
+// probeDisplay checks that the server on display answers X requests by
+// running xdpyinfo, up to attempts times, interval apart. A socket can
+// exist before the server is really usable.
+func probeDisplay(display, authFile string, attempts int, interval time.Duration) error {
+	xdpyinfo, err := exec.LookPath("xdpyinfo")
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(interval)
+		}
+		var stderr bytes.Buffer
+		cmd := exec.Command(xdpyinfo, "-display", display)
+		cmd.Env = displayEnv(os.Environ(), display, authFile)
+		cmd.Stderr = &stderr
+		if lastErr = cmd.Run(); lastErr == nil {
+			return nil
+		}
+		if msg := bytes.TrimSpace(stderr.Bytes()); len(msg) > 0 {
+			lastErr = fmt.Errorf("%w: %s", lastErr, msg)
+		}
+	}
+	return fmt.Errorf("display %s didn't answer after %d attempts: %w", display, attempts, lastErr)
+}
+
+// displayDepths returns the screen depths xdpyinfo lists for display, one
+// entry per depth however many screens support it.
+func displayDepths(display, authFile string) ([]int, error) {
+	xdpyinfo, err := exec.LookPath("xdpyinfo")
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(xdpyinfo, "-display", display)
+	cmd.Env = displayEnv(os.Environ(), display, authFile)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("xdpyinfo: %w", err)
+	}
+
+	var depths []int
+	for _, line := range strings.Split(string(out), "\n") {
+		// e.g. "  depths (7):    24, 1, 4, 8, 15, 16, 32"
+		label, list, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok || !strings.HasPrefix(label, "depths") {
+			continue
+		}
+		for _, field := range strings.Split(list, ",") {
+			depth, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil {
+				return nil, fmt.Errorf("unexpected xdpyinfo output %q", line)
+			}
+			if !slices.Contains(depths, depth) {
+				depths = append(depths, depth)
+			}
+		}
+	}
+	if depths == nil {
+		return nil, errors.New("xdpyinfo listed no depths")
+	}
+	return depths, nil
+}
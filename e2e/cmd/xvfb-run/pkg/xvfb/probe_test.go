@@ -0,0 +1,100 @@
+package xvfb
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeXdpyinfo puts an xdpyinfo in PATH that fails the first failures
+// times it is run.
+func fakeXdpyinfo(t *testing.T, failures int) {
+	t.Helper()
+	bin := t.TempDir()
+	count := filepath.Join(bin, "count")
+	fakeTool(t, bin, "xdpyinfo", `n=$(cat "`+count+`" 2>/dev/null || echo 0)
+echo $((n + 1)) > "`+count+`"
+if [ "$n" -lt `+strconv.Itoa(failures)+` ]; then
+	echo "xdpyinfo:  unable to open display \"$2\"." >&2
+	exit 1
+fi
+echo "name of display:    $2"
+`)
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+"/bin:/usr/bin")
+}
+
+func TestProbeDisplaySucceedsAfterRetries(t *testing.T) {
+	fakeXdpyinfo(t, 2)
+
+	if err := probeDisplay(":42", "/tmp/auth", 3, 10*time.Millisecond); err != nil {
+		t.Errorf("expected the third attempt to succeed, got %v", err)
+	}
+}
+
+func TestProbeDisplayGivesUp(t *testing.T) {
+	fakeXdpyinfo(t, 10)
+
+	err := probeDisplay(":42", "/tmp/auth", 3, 10*time.Millisecond)
+	if err == nil {
+		t.Fatal("expected the probe to fail")
+	}
+	if !strings.Contains(err.Error(), "after 3 attempts") || !strings.Contains(err.Error(), `unable to open display ":42"`) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestProbeDisplayWithoutXdpyinfo(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+
+	if err := probeDisplay(":42", "/tmp/auth", 3, 10*time.Millisecond); !errors.Is(err, exec.ErrNotFound) {
+		t.Errorf("expected exec.ErrNotFound, got %v", err)
+	}
+}
+
+func TestRunnerProbeBeforeStart(t *testing.T) {
+	r := &Runner{}
+	if err := r.Probe(0, 0); err == nil {
+		t.Fatal("expected an error probing before Start")
+	}
+}
+
+func TestDisplayDepths(t *testing.T) {
+	bin := t.TempDir()
+	fakeTool(t, bin, "xdpyinfo", `cat <<'EOF'
+screen #0:
+  dimensions:    1280x1024 pixels (339x271 millimeters)
+  depths (7):    24, 1, 4, 8, 15, 16, 32
+screen #1:
+  depths (2):    24, 1
+EOF
+`)
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+"/bin:/usr/bin")
+
+	depths, err := displayDepths(":42", "/tmp/auth")
+	if err != nil {
+		t.Fatalf("displayDepths: %v", err)
+	}
+	if want := []int{24, 1, 4, 8, 15, 16, 32}; !slices.Equal(depths, want) {
+		t.Errorf("got %v, want %v", depths, want)
+	}
+}
+
+func TestDisplayDepthsWithoutDepthsLine(t *testing.T) {
+	fakeXdpyinfo(t, 0)
+
+	if _, err := displayDepths(":42", "/tmp/auth"); err == nil {
+		t.Error("expected an error when xdpyinfo lists no depths")
+	}
+}
+
+func TestRunnerDepthsBeforeStart(t *testing.T) {
+	if _, err := (&Runner{}).Depths(); err == nil {
+		t.Error("expected an error before Start")
+	}
+}
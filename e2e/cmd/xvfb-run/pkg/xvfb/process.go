@@ -0,0 +1,45 @@
+package xvfb
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// groupGracePeriod is how long a command's process group gets after
+// SIGTERM before SIGKILL, unless Runner.ShutdownTimeout says otherwise.
+const groupGracePeriod = 2 * time.Second
+
+// terminateGroup stops every process in the process group led by pid, so
+// anything the child forked (browsers, helpers) goes away with it. What is
+// left after grace is killed.
+func terminateGroup(pid int, grace time.Duration) {
+	if err := syscall.Kill(-pid, syscall.SIGTERM); err != nil {
+		// Nothing left in the group
+		return
+	}
+	deadline := time.Now().Add(grace)
+	for time.Now().Before(deadline) {
+		if err := syscall.Kill(-pid, 0); err != nil {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	syscall.Kill(-pid, syscall.SIGKILL)
+}
+
+// ownsGroup reports whether the started cmd leads a process group of its
+// own.
+func ownsGroup(cmd *exec.Cmd) bool {
+	attr := cmd.SysProcAttr
+	return attr != nil && attr.Setpgid && attr.Pgid == 0
+}
+
+// signalTarget is what to pass to kill(2) to signal cmd and, if it leads
+// one, its process group.
+func signalTarget(cmd *exec.Cmd) int {
+	if ownsGroup(cmd) {
+		return -cmd.Process.Pid
+	}
+	return cmd.Process.Pid
+}
@@ -0,0 +1,87 @@
+package xvfb
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// startGroup starts script under sh as the leader of a new process group
+// and reaps it in the background.
+func startGroup(t *testing.T, script string) (*exec.Cmd, <-chan error) {
+	t.Helper()
+	cmd := exec.Command("sh", "-c", script)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	// Give sh time to fork its children
+	time.Sleep(100 * time.Millisecond)
+	return cmd, done
+}
+
+// killedBy returns the signal that ended a process, or 0.
+func killedBy(err error) syscall.Signal {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return 0
+	}
+	status := exitErr.Sys().(syscall.WaitStatus)
+	if !status.Signaled() {
+		return 0
+	}
+	return status.Signal()
+}
+
+func groupAlive(pid int) bool {
+	return syscall.Kill(-pid, 0) == nil
+}
+
+func TestTerminateGroupKillsForkedChildren(t *testing.T) {
+	cmd, done := startGroup(t, "sleep 30 & sleep 30 & wait")
+
+	terminateGroup(cmd.Process.Pid, groupGracePeriod)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("group leader still running after terminateGroup")
+	}
+	if groupAlive(cmd.Process.Pid) {
+		t.Error("expected every process in the group to be gone")
+	}
+}
+
+func TestTerminateGroupEscalatesToSIGKILL(t *testing.T) {
+	cmd, done := startGroup(t, `trap "" TERM; sleep 30 & wait`)
+
+	start := time.Now()
+	terminateGroup(cmd.Process.Pid, 200*time.Millisecond)
+
+	select {
+	case err := <-done:
+		if sig := killedBy(err); sig != syscall.SIGKILL {
+			t.Errorf("expected the leader to die from SIGKILL, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("group leader survived SIGKILL")
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("expected SIGKILL only after the grace period, took %s", elapsed)
+	}
+}
+
+func TestTerminateGroupWithNoGroupReturnsImmediately(t *testing.T) {
+	cmd, done := startGroup(t, "exit 0")
+	<-done
+
+	start := time.Now()
+	terminateGroup(cmd.Process.Pid, groupGracePeriod)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected terminateGroup to return at once, took %s", elapsed)
+	}
+}
@@ -0,0 +1,66 @@
+package xvfb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// DefaultRecordFramerate is the frame rate Record uses when given zero.
+const DefaultRecordFramerate = 25
+
+// recorderGracePeriod is how long ffmpeg gets to finish the file after
+// SIGINT before it is killed.
+var recorderGracePeriod = 10 * time.Second
+
+// startRecorder starts ffmpeg capturing display into path. It runs in its
+// own process group so a Ctrl-C on the terminal doesn't reach it before
+// stopRecorder does.
+func startRecorder(display, authFile, path string, framerate int) (*exec.Cmd, error) {
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(ffmpeg,
+		"-y", "-nostdin", "-loglevel", "error",
+		"-f", "x11grab", "-framerate", strconv.Itoa(framerate), "-i", display,
+		"-pix_fmt", "yuv420p", path)
+	cmd.Env = displayEnv(os.Environ(), display, authFile)
+	cmd.Stderr = &bytes.Buffer{}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// stopRecorder interrupts ffmpeg so it writes out the container, and waits
+// for it. ffmpeg exits with status 255 when interrupted, which is expected.
+func stopRecorder(cmd *exec.Cmd) error {
+	if err := cmd.Process.Signal(os.Interrupt); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(recorderGracePeriod):
+		cmd.Process.Kill()
+		<-done
+		return fmt.Errorf("ffmpeg didn't finish within %s, the recording may be unusable", recorderGracePeriod)
+	}
+	var exitErr *exec.ExitError
+	if err == nil || errors.As(err, &exitErr) && exitErr.ExitCode() == 255 {
+		return nil
+	}
+	return fmt.Errorf("ffmpeg: %w: %s", err, bytes.TrimSpace(cmd.Stderr.(*bytes.Buffer).Bytes()))
+}
@@ -0,0 +1,82 @@
+package xvfb
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeFFmpeg puts an ffmpeg in PATH that writes its arguments to the output
+// file when interrupted. With ignoreInt it has to be killed instead.
+func fakeFFmpeg(t *testing.T, ignoreInt bool) {
+	t.Helper()
+	bin := t.TempDir()
+	onInt := `echo "$args" > "$out"; exit 255`
+	if ignoreInt {
+		onInt = ":"
+	}
+	fakeTool(t, bin, "ffmpeg", `args="$*"
+for a; do out=$a; done
+trap '`+onInt+`' INT
+while :; do sleep 0.05; done
+`)
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+"/bin:/usr/bin")
+}
+
+func TestRecorderFinishesOnStop(t *testing.T) {
+	fakeFFmpeg(t, false)
+	path := filepath.Join(t.TempDir(), "session.mp4")
+
+	cmd, err := startRecorder(":42", "/tmp/auth", path, 15)
+	if err != nil {
+		t.Fatalf("startRecorder: %v", err)
+	}
+	// Give the shell time to install its trap
+	time.Sleep(200 * time.Millisecond)
+	if err := stopRecorder(cmd); err != nil {
+		t.Fatalf("stopRecorder: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("expected ffmpeg to write %s: %v", path, err)
+	}
+	if got := string(data); !strings.Contains(got, "-f x11grab -framerate 15 -i :42") {
+		t.Errorf("unexpected ffmpeg args %q", got)
+	}
+}
+
+func TestRecorderIsKilledIfItDoesNotFinish(t *testing.T) {
+	fakeFFmpeg(t, true)
+	old := recorderGracePeriod
+	recorderGracePeriod = 200 * time.Millisecond
+	t.Cleanup(func() { recorderGracePeriod = old })
+
+	cmd, err := startRecorder(":42", "/tmp/auth", filepath.Join(t.TempDir(), "session.mp4"), 25)
+	if err != nil {
+		t.Fatalf("startRecorder: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if err := stopRecorder(cmd); err == nil || !strings.Contains(err.Error(), "didn't finish") {
+		t.Errorf("expected a timeout error, got %v", err)
+	}
+}
+
+func TestStartRecorderWithoutFFmpeg(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+
+	if _, err := startRecorder(":42", "/tmp/auth", filepath.Join(t.TempDir(), "session.mp4"), 25); !errors.Is(err, exec.ErrNotFound) {
+		t.Errorf("expected exec.ErrNotFound, got %v", err)
+	}
+}
+
+func TestRunnerRecordBeforeStart(t *testing.T) {
+	r := &Runner{}
+	if _, err := r.Record(filepath.Join(t.TempDir(), "session.mp4"), 0); err == nil {
+		t.Fatal("expected an error recording before Start")
+	}
+}
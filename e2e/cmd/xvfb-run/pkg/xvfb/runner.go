@@ -0,0 +1,934 @@
+// Package xvfb starts a private Xvfb server and runs commands against it.
+//
+// A Runner can be started once in TestMain, shared by many tests, and
+// stopped at the end:
+//
+//	r := &xvfb.Runner{}
+//	if err := r.Start(); err != nil { ... }
+//	defer r.Stop()
+//	fmt.Println("Xvfb", r.ServerPID(), "is on", r.ActiveDisplay())
+//	err := r.Run([]string{"xdpyinfo"})
+//
+// Code that talks to X itself rather than through a command can use
+// WithDisplay instead.
+package xvfb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// DefaultScreenGeometry is the size and depth of screen 0 when neither
+// ScreenGeometry nor ServerArgs is set.
+const DefaultScreenGeometry = "1280x1024x24"
+
+// DefaultReadyTimeout is how long Start waits for the display socket when
+// ReadyTimeout is zero.
+const DefaultReadyTimeout = 10 * time.Second
+
+// DefaultStartAttempts is how many displays Start tries when
+// StartAttempts is zero.
+const DefaultStartAttempts = 5
+
+// autoDisplayBase is where auto-allocation starts scanning, as in xvfb-run.
+const autoDisplayBase = 99
+
+// Screen is one of Xvfb's screens, passed as -screen Index Geometry.
+type Screen struct {
+	Index    int
+	Geometry string
+}
+
+// Runner manages one Xvfb server. The exported fields configure it and must
+// not change after Start.
+type Runner struct {
+	// Display is the display to start Xvfb on, e.g. ":99". "99", ":99.0"
+	// and "localhost:99" mean the same; commands get the plain ":99". If
+	// empty, the first free display from :99 up is used. Either way,
+	// ActiveDisplay and ServerPID report what Start ended up with.
+	Display string
+	// ScreenGeometry is the WxHxD of screen 0. If it and ServerArgs are
+	// both empty, DefaultScreenGeometry is used.
+	ScreenGeometry string
+	// Screens defines several screens, e.g. for multi-monitor tests. If
+	// set, ScreenGeometry is ignored. Indexes must be unique.
+	Screens []Screen
+	// ServerArgs are extra arguments for Xvfb. Setting them without
+	// ScreenGeometry or Screens drops the default -screen argument.
+	ServerArgs []string
+	// DisplayBase and DisplayMax bound the displays tried when Display is
+	// empty, both inclusive. Zero means :99 and 99 displays above the
+	// base, like xvfb-run -a.
+	DisplayBase int
+	DisplayMax  int
+	// CleanStale removes the lock file and socket of a pinned Display if
+	// the server that created them is gone, e.g. after a SIGKILL.
+	CleanStale bool
+	// WaitForFree, if set, makes Start wait up to this long for a pinned
+	// Display that is in use to be given up, instead of failing at once.
+	// Locks left by dead servers are removed rather than waited on.
+	WaitForFree time.Duration
+	// Depth, if set, replaces the depth of every screen, e.g. ARGBDepth
+	// for rendering tests that need an alpha channel. It has no effect on
+	// screens given in ServerArgs.
+	Depth int
+	// SocketDir is where the X server keeps its lock files and .X11-unix
+	// socket directory, if not /tmp; display allocation, readiness and
+	// stale lock checks all look there. Stock Xvfb always uses /tmp, so
+	// this is for servers built or wrapped to honor XDG_RUNTIME_DIR, which
+	// is set to SocketDir in the server's environment.
+	SocketDir string
+	// DPI, if set, is passed to Xvfb so font rendering doesn't depend on
+	// the server's default.
+	DPI int
+	// FontPath, if set, is Xvfb's font path (Xvfb -fp). If it is empty and
+	// Xvfb can't open its default font, Start retries once with the
+	// system's misc font directory.
+	FontPath string
+	// GLX turns on Xvfb's GLX and RENDER extensions, for OpenGL and
+	// WebGL clients. It has no effect on Xwayland, which always has them.
+	GLX bool
+	// FramebufferDir, if set, makes Xvfb keep each screen's framebuffer in
+	// a file there (Xvfb -fbdir); see FramebufferFiles.
+	FramebufferDir string
+	// Backend is the kind of X server to start, BackendXvfb if empty or
+	// BackendXwayland. Xwayland has a single screen and ignores the depth,
+	// DPI and -screen settings; its size comes from the screen geometry.
+	Backend string
+	// XvfbPath, if set, is the Xvfb binary to run instead of looking for
+	// one in PATH, for images that install it somewhere else. Start fails
+	// if it isn't an executable file.
+	XvfbPath string
+	// ServerBinary is the X server to fall back to when Xvfb is not in
+	// PATH, e.g. "Xephyr" or "Xvnc". It must accept Xvfb's arguments.
+	ServerBinary string
+	// ListenTCP lets Xvfb accept TCP connections, e.g. from remote
+	// debugging tools. By default it only listens on its Unix socket.
+	ListenTCP bool
+	// Env is the environment commands run with. If nil, the wrapper's own
+	// environment is used. DISPLAY, and XAUTHORITY if there is an
+	// Xauthority file, are always added, unless KeepDisplay applies.
+	Env []string
+	// KeepDisplay leaves a DISPLAY already in the commands' environment,
+	// and its XAUTHORITY, alone, so they talk to that display even though
+	// the Runner starts its own. Without one they get the Runner's.
+	KeepDisplay bool
+	// DisableAccessControl starts Xvfb with -ac instead of an Xauthority
+	// file, so any client that can reach the display may connect. It is
+	// meant for quick local experiments.
+	DisableAccessControl bool
+	// Credential, if set, is the user and group commands run as, e.g. to
+	// drop root in a container; Xvfb keeps the wrapper's. The Xauthority
+	// file Start creates is given to that user so it can connect.
+	Credential *syscall.Credential
+	// Dir is the working directory of commands. If empty, they run in the
+	// wrapper's own directory.
+	Dir string
+
+	// Stdin, Stdout and Stderr are wired to commands started by Run. Nil
+	// means the null device.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	// ServerOutput, if set, receives Xvfb's stdout and stderr. Either way
+	// the last lines Xvfb printed are included in Start's error when the
+	// server doesn't come up.
+	ServerOutput io.Writer
+
+	// Timeout, if set, bounds how long each command started by Run may
+	// take. Its process group is then terminated and Run returns
+	// ErrTimeout.
+	Timeout time.Duration
+	// ReadyTimeout bounds how long Start waits for the display to come up.
+	ReadyTimeout time.Duration
+	// ShutdownTimeout is how long Stop gives Xvfb, and Run a command's
+	// process group, to exit after SIGTERM before they are killed with
+	// SIGKILL. Zero means 3s for Xvfb and 2s for commands. It is separate
+	// from ReadyTimeout, so a server that is slow to start needn't be
+	// waited on as long when it stops.
+	ShutdownTimeout time.Duration
+	// ServerNice and CommandNice, if set, are the niceness the X server
+	// and commands run at, from -20 to 19, e.g. to keep Xvfb from
+	// competing with the test it serves. Negative values need
+	// CAP_SYS_NICE. Only Linux is supported.
+	ServerNice  int
+	CommandNice int
+	// MergeAuthFile, if set, is an Xauthority file, e.g. the user's own,
+	// that Start also adds the display's cookie to and Stop removes it from
+	// again. Its entries for other displays are left alone. This is for
+	// KeepDisplay, whose commands keep their XAUTHORITY but may still want
+	// to reach the Runner's display. It has no effect with Attach or
+	// DisableAccessControl, which leave no cookie of the Runner's.
+	MergeAuthFile string
+	// ReadyCheck is how Start decides the display is up: ReadyCheckSocket,
+	// the default, or ReadyCheckConnect.
+	ReadyCheck string
+	// StartAttempts is how many auto-allocated displays Start tries when
+	// another server takes the one it picked.
+	StartAttempts int
+
+	// Logf, if set, receives progress messages.
+	Logf func(format string, args ...any)
+
+	mu       sync.Mutex
+	display  string
+	authFile string
+	server   Server
+	attached bool
+	cmd      *exec.Cmd
+	timings  Timings
+	// mergedAuth is the MergeAuthFile the display's cookie was added to
+	mergedAuth string
+}
+
+// Timings says where Start spent its time.
+type Timings struct {
+	// Spawn runs from Start being called until the Xvfb that got the
+	// display was launched, including any attempts that lost it.
+	Spawn time.Duration
+	// Ready is how long that Xvfb took to create its display socket.
+	Ready time.Duration
+}
+
+// started reports whether the Runner has a display, its own or attached.
+func (r *Runner) started() bool {
+	return r.server != nil || r.attached
+}
+
+// Attach makes the Runner use an X server that is already running, such as
+// the one in $DISPLAY, instead of starting Xvfb. authFile may be empty if
+// the server needs no Xauthority file. Stop then leaves the server alone.
+func (r *Runner) Attach(display, authFile string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.started() {
+		return errors.New("xvfb: already started")
+	}
+	if display == "" {
+		return errors.New("xvfb: no display to attach to")
+	}
+	r.display, r.authFile, r.attached = display, authFile, true
+	return nil
+}
+
+// Start allocates a display, starts Xvfb on it and waits until it is ready.
+func (r *Runner) Start() error {
+	return r.StartContext(context.Background())
+}
+
+// StartContext is like Start, but stops waiting for the display, stops
+// Xvfb and returns an error wrapping ctx.Err() if ctx is done before the
+// display is ready.
+func (r *Runner) StartContext(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.started() {
+		return errors.New("xvfb: already started")
+	}
+
+	attempts := r.StartAttempts
+	if attempts <= 0 {
+		attempts = DefaultStartAttempts
+	}
+	server, err := r.startXvfbWithRetry(ctx, r.ScreenGeometry, attempts)
+	if err != nil {
+		return err
+	}
+	r.display, r.server = server.Display(), server
+	if r.MergeAuthFile != "" && r.authFile != "" {
+		if err := mergeAuthEntry(r.display, r.authFile, r.MergeAuthFile); err != nil {
+			r.stop()
+			return fmt.Errorf("xvfb: adding the cookie for %s to %s: %w", r.display, r.MergeAuthFile, err)
+		}
+		r.mergedAuth = r.MergeAuthFile
+		r.logf("Added the cookie for %s to %s", r.display, r.MergeAuthFile)
+	}
+	return nil
+}
+
+// PlannedAuthFile stands for the Xauthority file in the command line
+// returned by Plan, since Start only creates it when it starts Xvfb.
+const PlannedAuthFile = "$XAUTHORITY"
+
+// Plan returns the display Start would use and the X server command line it
+// would run there, without starting anything or touching lock files. An
+// auto-allocated display is the first one free now, which another server
+// may take before Start. If no server is found in PATH, the command line
+// names Xvfb. With BackendXwayland it is Xwayland's, which Start runs once
+// Weston is up.
+func (r *Runner) Plan() (display string, server []string, err error) {
+	if r.Display == "" {
+		first, last, err := r.displayRange()
+		if err != nil {
+			return "", nil, err
+		}
+		n, err := findFreeDisplay(r.x11Dir(), first, last)
+		if err != nil {
+			return "", nil, err
+		}
+		releaseDisplay(r.x11Dir(), n)
+		display = DisplayString(n)
+	} else if display, _, err = normalizeDisplay(r.Display); err != nil {
+		return "", nil, err
+	}
+
+	plannedAuth := PlannedAuthFile
+	if r.DisableAccessControl {
+		plannedAuth = ""
+	}
+	opts := r.serverOptions(display, plannedAuth, r.ScreenGeometry, r.FontPath)
+	if r.Backend == BackendXwayland {
+		return display, append([]string{"Xwayland"}, buildXwaylandArgs(opts)...), nil
+	}
+	bin, err := locateServer(r.XvfbPath, r.ServerBinary)
+	if err != nil && r.XvfbPath != "" {
+		return "", nil, err
+	}
+	if err != nil {
+		bin = "Xvfb"
+	}
+	return display, append([]string{bin}, buildXvfbArgs(opts)...), nil
+}
+
+// startXvfbWithRetry starts Xvfb and waits for its display. Between picking
+// a free display and Xvfb locking it another server can grab it; Xvfb then
+// exits with "Server is already active" while the socket we wait on belongs
+// to the other server. When that happens on an auto-allocated display, it
+// moves on to the next free one, up to attempts times.
+func (r *Runner) startXvfbWithRetry(ctx context.Context, geometry string, attempts int) (Server, error) {
+	timeout := r.ReadyTimeout
+	if timeout == 0 {
+		timeout = DefaultReadyTimeout
+	}
+
+	began := time.Now()
+	fontPath := r.FontPath
+	for attempt := 1; ; attempt++ {
+		n, err := r.pickDisplay()
+		if err != nil {
+			return nil, err
+		}
+		display := DisplayString(n)
+
+		if r.Backend == BackendXwayland {
+			r.logf("Starting Weston and Xwayland on %s", display)
+		} else {
+			r.logf("Starting Xvfb on %s", display)
+		}
+		// A missing server is worth saying plainly, rather than as whatever
+		// fails first without it
+		if err := r.CheckServer(); err != nil {
+			releaseDisplay(r.x11Dir(), n)
+			return nil, err
+		}
+
+		authFile, err := r.newAuthFile(display)
+		if err != nil {
+			releaseDisplay(r.x11Dir(), n)
+			return nil, err
+		}
+
+		output := newRingBuffer(startupOutputLines)
+		var out io.Writer = output
+		if r.ServerOutput != nil {
+			out = io.MultiWriter(r.ServerOutput, output)
+		}
+		server, err := r.newServer(display, authFile, geometry, fontPath, out)
+		if err != nil {
+			os.Remove(authFile)
+			releaseDisplay(r.x11Dir(), n)
+			return nil, err
+		}
+
+		if err := server.Start(); err != nil {
+			os.Remove(authFile)
+			releaseDisplay(r.x11Dir(), n)
+			return nil, withKind(ErrXvfbStart, err)
+		}
+		spawned := time.Now()
+
+		// Xvfb needs a moment to create its socket before clients can
+		// connect, and may die instead (bad arguments, missing fonts)
+		err = server.Ready(ctx, timeout)
+		if err == nil {
+			r.authFile = authFile
+			r.timings = Timings{Spawn: spawned.Sub(began), Ready: time.Since(spawned)}
+			return server, nil
+		}
+
+		server.Stop()
+		os.Remove(authFile)
+		releaseDisplay(r.x11Dir(), n)
+		switch {
+		case ctx.Err() != nil:
+			return nil, fmt.Errorf("display %s not ready: %w", display, err)
+		case errors.Is(err, errServerExited):
+			if fontPath == "" && missingDefaultFont(output.String()) {
+				if fallback := fallbackFontPath(); fallback != "" {
+					r.logf("Xvfb couldn't open its default font, retrying with -fp %s", fallback)
+					fontPath = fallback
+					// Not a display clash, so it doesn't use up an attempt
+					attempt--
+					continue
+				}
+			}
+			if msg := output.String(); msg != "" {
+				return nil, withKind(ErrXvfbStart, fmt.Errorf("%w on %s:\n%s", err, display, msg))
+			}
+			return nil, withKind(ErrXvfbStart, fmt.Errorf("%w on %s", err, display))
+		case !errors.Is(err, errDisplayTaken):
+			if !errors.Is(err, ErrDisplayTimeout) {
+				err = withKind(ErrXvfbStart, err)
+			}
+			if msg := output.String(); msg != "" {
+				return nil, fmt.Errorf("display %s not ready: %w:\n%s", display, err, msg)
+			}
+			return nil, fmt.Errorf("display %s not ready: %w", display, err)
+		}
+		if r.Display != "" {
+			return nil, withKind(ErrXvfbStart, fmt.Errorf("display %s: %w", display, err))
+		}
+		if attempt >= attempts {
+			return nil, withKind(ErrXvfbStart, fmt.Errorf("display %s: %w, gave up after %d attempts", display, err, attempts))
+		}
+		r.logf("Display %s was taken by another server, retrying", display)
+	}
+}
+
+// CheckServer reports whether the X server for r.Backend can be found,
+// without starting anything. It returns an error wrapping
+// ErrServerNotFound if it isn't installed.
+func (r *Runner) CheckServer() error {
+	if r.Backend == BackendXwayland {
+		_, _, err := lookupXwayland()
+		return err
+	}
+	_, err := locateServer(r.XvfbPath, r.ServerBinary)
+	return err
+}
+
+// newAuthFile creates the Xauthority file for display, owned by whoever
+// commands run as. With access control disabled there is none and the path
+// is empty.
+func (r *Runner) newAuthFile(display string) (string, error) {
+	if r.DisableAccessControl {
+		return "", nil
+	}
+	authFile, err := createAuthFile(display)
+	if err != nil {
+		return "", fmt.Errorf("creating Xauthority file: %w", err)
+	}
+	if cred := r.Credential; cred != nil {
+		if err := os.Chown(authFile, int(cred.Uid), int(cred.Gid)); err != nil {
+			os.Remove(authFile)
+			return "", fmt.Errorf("giving the Xauthority file to uid %d: %w", cred.Uid, err)
+		}
+	}
+	return authFile, nil
+}
+
+func (r *Runner) pickDisplay() (int, error) {
+	dir := r.x11Dir()
+	if r.Display == "" {
+		first, last, err := r.displayRange()
+		if err != nil {
+			return 0, err
+		}
+		return findFreeDisplay(dir, first, last)
+	}
+	n, err := DisplayNumber(r.Display)
+	if err != nil {
+		return 0, err
+	}
+	if r.CleanStale {
+		stale, err := isStaleLock(dir, r.Display)
+		if err != nil {
+			return 0, err
+		}
+		if stale {
+			r.logf("Removing stale lock for %s", r.Display)
+			if err := removeStaleLock(dir, n); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if r.WaitForFree > 0 && displayInUse(dir, n) {
+		r.logf("Waiting up to %s for %s to be free", r.WaitForFree, r.Display)
+		if err := waitForFreeDisplay(dir, r.Display, r.WaitForFree); err != nil {
+			return 0, err
+		}
+	}
+	if displayInUse(dir, n) {
+		return 0, fmt.Errorf("display %s is already in use (found %s or %s)", r.Display, lockPath(dir, n), socketPath(dir, n))
+	}
+	if !reserveDisplay(dir, n) {
+		return 0, fmt.Errorf("display %s is already in use by another Runner in this process", r.Display)
+	}
+	return n, nil
+}
+
+// Run runs cmd against the display and waits for it to finish. The command
+// gets its own process group, which is torn down afterwards so nothing it
+// forked outlives it. A failing command is reported as ErrCommandFailed
+// wrapped around its *exec.ExitError.
+func (r *Runner) Run(cmd []string) error {
+	return r.RunContext(context.Background(), cmd)
+}
+
+// RunContext is like Run, but if ctx is done before the command finishes,
+// the command's process group is terminated and ctx.Err() is returned.
+// Xvfb is left running, for the caller to inspect the display or stop it.
+func (r *Runner) RunContext(ctx context.Context, cmd []string) error {
+	return r.RunIn(ctx, r.Dir, cmd)
+}
+
+// RunIn is RunContext with the command run in dir instead of Dir, for
+// callers whose commands each need a directory of their own. An empty dir
+// is the wrapper's own directory, as it is for Dir.
+func (r *Runner) RunIn(ctx context.Context, dir string, cmd []string) error {
+	if len(cmd) == 0 {
+		return errors.New("xvfb: no command given")
+	}
+
+	runCtx := ctx
+	if r.Timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
+
+	r.mu.Lock()
+	if !r.started() {
+		r.mu.Unlock()
+		return errors.New("xvfb: not started")
+	}
+	if r.cmd != nil {
+		r.mu.Unlock()
+		return errors.New("xvfb: a command is already running")
+	}
+	env := r.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	c := exec.CommandContext(runCtx, cmd[0], cmd[1:]...)
+	c.Env = r.commandEnv(env)
+	c.Dir = dir
+	c.Stdin = r.Stdin
+	c.Stdout = r.Stdout
+	c.Stderr = r.Stderr
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Credential: r.Credential}
+	// On cancellation, ask the whole group to stop; terminateGroup below
+	// finishes anything that ignores it
+	c.Cancel = func() error { return syscall.Kill(-c.Process.Pid, syscall.SIGTERM) }
+	c.WaitDelay = r.groupGrace()
+	if err := startNiced(c, r.CommandNice); err != nil {
+		r.mu.Unlock()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if cred := r.Credential; cred != nil && errors.Is(err, syscall.EPERM) {
+			return fmt.Errorf("running as uid %d, gid %d: %w", cred.Uid, cred.Gid, err)
+		}
+		return commandError(err)
+	}
+	r.cmd = c
+	r.mu.Unlock()
+
+	err := c.Wait()
+	terminateGroup(c.Process.Pid, r.groupGrace())
+
+	r.mu.Lock()
+	r.cmd = nil
+	r.mu.Unlock()
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil && errors.Is(runCtx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w after %s", ErrTimeout, r.Timeout)
+	}
+	return commandError(err)
+}
+
+// RunCmd runs cmd, which the caller has set up with its own Stdout,
+// Stderr, Dir and process attributes, on the display, and waits for it.
+// Only DISPLAY and XAUTHORITY are added to its environment, which is r.Env
+// or the wrapper's own if cmd.Env is nil. Without SysProcAttr it gets a
+// process group of its own like commands started by Run, which is
+// terminated when it exits. Timeout applies as it does for Run.
+func (r *Runner) RunCmd(cmd *exec.Cmd) error {
+	if cmd == nil || cmd.Path == "" {
+		return errors.New("xvfb: no command given")
+	}
+	if cmd.Process != nil {
+		return errors.New("xvfb: command already started")
+	}
+
+	r.mu.Lock()
+	if !r.started() {
+		r.mu.Unlock()
+		return errors.New("xvfb: not started")
+	}
+	if r.cmd != nil {
+		r.mu.Unlock()
+		return errors.New("xvfb: a command is already running")
+	}
+	env := cmd.Env
+	if env == nil {
+		env = r.Env
+	}
+	if env == nil {
+		env = os.Environ()
+	}
+	cmd.Env = r.commandEnv(env)
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Credential: r.Credential}
+	}
+	if err := startNiced(cmd, r.CommandNice); err != nil {
+		r.mu.Unlock()
+		return commandError(err)
+	}
+	r.cmd = cmd
+	r.mu.Unlock()
+
+	var timedOut atomic.Bool
+	done := make(chan struct{})
+	if r.Timeout > 0 {
+		go func() {
+			select {
+			case <-done:
+				return
+			case <-time.After(r.Timeout):
+			}
+			timedOut.Store(true)
+			syscall.Kill(signalTarget(cmd), syscall.SIGTERM)
+			select {
+			case <-done:
+			case <-time.After(r.groupGrace()):
+				syscall.Kill(signalTarget(cmd), syscall.SIGKILL)
+			}
+		}()
+	}
+	err := cmd.Wait()
+	close(done)
+	if ownsGroup(cmd) {
+		terminateGroup(cmd.Process.Pid, r.groupGrace())
+	}
+
+	r.mu.Lock()
+	r.cmd = nil
+	r.mu.Unlock()
+	if err != nil && timedOut.Load() {
+		return fmt.Errorf("%w after %s", ErrTimeout, r.Timeout)
+	}
+	return commandError(err)
+}
+
+// commandEnv is env for a command on the display.
+func (r *Runner) commandEnv(env []string) []string {
+	if r.KeepDisplay && hasDisplay(env) {
+		return env
+	}
+	env = displayEnv(env, r.display, r.authFile)
+	if w, ok := r.server.(*xwaylandServer); ok {
+		env = append(env, "WAYLAND_DISPLAY="+w.wayland)
+	}
+	return env
+}
+
+// Signal sends sig to the process group of the command started by Run, or
+// to the command given to RunCmd if it shares the wrapper's group. It does
+// nothing if no command is running.
+func (r *Runner) Signal(sig syscall.Signal) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cmd == nil {
+		return nil
+	}
+	return syscall.Kill(signalTarget(r.cmd), sig)
+}
+
+// ActiveDisplay returns the display Xvfb is running on, e.g. ":99", or ""
+// if the Runner isn't started.
+func (r *Runner) ActiveDisplay() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.started() {
+		return ""
+	}
+	return r.display
+}
+
+// ServerPID returns the process ID of Xvfb, or 0 if the Runner didn't
+// start one.
+func (r *Runner) ServerPID() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.server == nil {
+		return 0
+	}
+	return r.server.PID()
+}
+
+// StartTimings returns how long Start took to launch Xvfb and for its
+// display to be ready, or zero if the Runner didn't start one.
+func (r *Runner) StartTimings() Timings {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.server == nil {
+		return Timings{}
+	}
+	return r.timings
+}
+
+// FramebufferFiles returns the files Xvfb keeps its screens in, one per
+// screen, or nil without FramebufferDir or before Start. Each is in XWD
+// format.
+func (r *Runner) FramebufferFiles() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.server == nil || r.FramebufferDir == "" {
+		return nil
+	}
+	if len(r.Screens) == 0 {
+		return []string{framebufferFile(r.FramebufferDir, 0)}
+	}
+	files := make([]string, 0, len(r.Screens))
+	for _, screen := range r.Screens {
+		files = append(files, framebufferFile(r.FramebufferDir, screen.Index))
+	}
+	return files
+}
+
+// AuthFile returns the Xauthority file other X clients need to connect to
+// the display, or "" if the Runner isn't started.
+func (r *Runner) AuthFile() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.started() {
+		return ""
+	}
+	return r.authFile
+}
+
+// SocketPath returns the Unix socket X clients connect to the display on,
+// or "" if the Runner isn't started.
+func (r *Runner) SocketPath() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.started() {
+		return ""
+	}
+	n, err := DisplayNumber(r.display)
+	if err != nil {
+		return ""
+	}
+	return socketPath(r.x11Dir(), n)
+}
+
+// Probe checks with xdpyinfo that the display answers X requests, trying
+// up to attempts times, interval apart (DefaultProbeAttempts and
+// DefaultProbeInterval if zero).
+func (r *Runner) Probe(attempts int, interval time.Duration) error {
+	r.mu.Lock()
+	if !r.started() {
+		r.mu.Unlock()
+		return errors.New("xvfb: not started")
+	}
+	display, authFile := r.display, r.authFile
+	r.mu.Unlock()
+
+	if attempts <= 0 {
+		attempts = DefaultProbeAttempts
+	}
+	if interval <= 0 {
+		interval = DefaultProbeInterval
+	}
+	return probeDisplay(display, authFile, attempts, interval)
+}
+
+// Depths returns the screen depths the display supports, as reported by
+// xdpyinfo, e.g. to check that Depth took effect.
+func (r *Runner) Depths() ([]int, error) {
+	r.mu.Lock()
+	if !r.started() {
+		r.mu.Unlock()
+		return nil, errors.New("xvfb: not started")
+	}
+	display, authFile := r.display, r.authFile
+	r.mu.Unlock()
+
+	return displayDepths(display, authFile)
+}
+
+// Screenshot saves the display as a PNG at path. It needs ImageMagick's
+// import, or xwd and convert.
+func (r *Runner) Screenshot(path string) error {
+	r.mu.Lock()
+	if !r.started() {
+		r.mu.Unlock()
+		return errors.New("xvfb: not started")
+	}
+	display, authFile := r.display, r.authFile
+	r.mu.Unlock()
+
+	return captureScreenshot(display, authFile, path)
+}
+
+// Record starts recording the display to path with ffmpeg at framerate
+// frames per second (DefaultRecordFramerate if zero). Call the returned
+// func to finish the file before stopping the Runner.
+func (r *Runner) Record(path string, framerate int) (stop func() error, err error) {
+	r.mu.Lock()
+	if !r.started() {
+		r.mu.Unlock()
+		return nil, errors.New("xvfb: not started")
+	}
+	display, authFile := r.display, r.authFile
+	r.mu.Unlock()
+
+	if framerate <= 0 {
+		framerate = DefaultRecordFramerate
+	}
+	cmd, err := startRecorder(display, authFile, path, framerate)
+	if err != nil {
+		return nil, err
+	}
+	return func() error { return stopRecorder(cmd) }, nil
+}
+
+// Stop shuts Xvfb down and removes the Xauthority file. It is safe to call
+// more than once and from another goroutine while Run is waiting.
+func (r *Runner) Stop() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.stop()
+}
+
+// Detach lets go of Xvfb without stopping it: the server and its
+// Xauthority file are left behind for someone else to clean up, and the
+// Runner can be started again. It is meant for debugging.
+func (r *Runner) Detach() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.server == nil {
+		return
+	}
+	if n, err := DisplayNumber(r.display); err == nil {
+		releaseDisplay(r.x11Dir(), n)
+	}
+	r.server, r.mergedAuth = nil, ""
+}
+
+func (r *Runner) stop() error {
+	if r.attached {
+		r.attached = false
+		return nil
+	}
+	if r.server == nil {
+		return nil
+	}
+	var errs []error
+	if err := r.server.Stop(); err != nil {
+		errs = append(errs, err)
+	}
+	if r.mergedAuth != "" {
+		if err := removeAuthEntry(r.display, r.mergedAuth); err != nil {
+			errs = append(errs, err)
+		}
+		r.mergedAuth = ""
+	}
+	if err := os.Remove(r.authFile); r.authFile != "" && err != nil && !errors.Is(err, os.ErrNotExist) {
+		errs = append(errs, err)
+	}
+	if n, err := DisplayNumber(r.display); err == nil {
+		releaseDisplay(r.x11Dir(), n)
+	}
+	r.server = nil
+	return errors.Join(errs...)
+}
+
+// displayRange is the first and last display auto-allocation tries.
+func (r *Runner) displayRange() (first, last int, err error) {
+	first, last = r.DisplayBase, r.DisplayMax
+	if first == 0 {
+		first = autoDisplayBase
+	}
+	if last == 0 {
+		last = first + maxDisplayScan - 1
+	}
+	if last < first {
+		return 0, 0, fmt.Errorf("xvfb: DisplayMax :%d is below DisplayBase :%d", last, first)
+	}
+	return first, last, nil
+}
+
+// serverGrace is how long Xvfb gets to exit after SIGTERM.
+func (r *Runner) serverGrace() time.Duration {
+	if r.ShutdownTimeout > 0 {
+		return r.ShutdownTimeout
+	}
+	return serverGracePeriod
+}
+
+// groupGrace is how long a command's process group gets to exit after
+// SIGTERM.
+func (r *Runner) groupGrace() time.Duration {
+	if r.ShutdownTimeout > 0 {
+		return r.ShutdownTimeout
+	}
+	return groupGracePeriod
+}
+
+// x11Dir is the directory the server's lock files and sockets are in.
+func (r *Runner) x11Dir() string {
+	if r.SocketDir != "" {
+		return r.SocketDir
+	}
+	return x11TmpDir
+}
+
+// displayEnv returns env with DISPLAY and XAUTHORITY pointing at display.
+// With no authFile, XAUTHORITY is left as it is.
+func displayEnv(env []string, display, authFile string) []string {
+	env = append(env[:len(env):len(env)], "DISPLAY="+display)
+	if authFile != "" {
+		env = append(env, "XAUTHORITY="+authFile)
+	}
+	return env
+}
+
+// hasDisplay reports whether env sets a non-empty DISPLAY.
+func hasDisplay(env []string) bool {
+	for _, kv := range env {
+		if value, ok := strings.CutPrefix(kv, "DISPLAY="); ok && value != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Runner) logf(format string, args ...any) {
+	if r.Logf != nil {
+		r.Logf(format, args...)
+	}
+}
@@ -0,0 +1,1222 @@
+package xvfb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// When the test binary is run as "Xvfb" it acts as a fake X server: it
+// listens on the display socket under $FAKE_XVFB_TMPDIR, writes the lock
+// file, and cleans both up on SIGTERM. It refuses X11 connections for lack
+// of credentials, as a real server would, unless $FAKE_XVFB_MUTE makes it
+// never answer. Displays listed in $FAKE_XVFB_TAKEN ("all" for every one)
+// behave as if another server grabbed them first, and $FAKE_XVFB_FAIL
+// makes it print that message and exit at once, as $FAKE_XVFB_NO_FONTS
+// does Xvfb's missing font error unless it is given -fp. With
+// $FAKE_XVFB_LEAK set
+// it exits on SIGTERM without cleaning up, and with $FAKE_XVFB_STUBBORN
+// it ignores SIGTERM and only goes with SIGKILL. Run as
+// "Xwayland" it does the same once it finds its compositor's socket.
+func TestMain(m *testing.M) {
+	switch filepath.Base(os.Args[0]) {
+	case "Xvfb":
+		os.Exit(fakeXvfb(os.Args[1:]))
+	case "Xwayland":
+		os.Exit(fakeXwayland(os.Args[1:]))
+	}
+	os.Exit(m.Run())
+}
+
+func fakeXvfb(args []string) int {
+	if path := os.Getenv("FAKE_XVFB_ARGS"); path != "" {
+		os.WriteFile(path, []byte(strings.Join(args, "\n")), 0o644)
+	}
+	dir := os.Getenv("FAKE_XVFB_TMPDIR")
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		// Asked to keep its files elsewhere, see Runner.SocketDir
+		dir = runtimeDir
+	}
+	n, err := DisplayNumber(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fake Xvfb:", err)
+		return 1
+	}
+
+	if msg := os.Getenv("FAKE_XVFB_FAIL"); msg != "" {
+		fmt.Fprintln(os.Stderr, msg)
+		return 1
+	}
+	if os.Getenv("FAKE_XVFB_NO_FONTS") != "" && !slices.Contains(args, "-fp") {
+		fmt.Fprintln(os.Stderr, "(EE) Fatal server error:\n(EE) could not open default font 'fixed'")
+		return 1
+	}
+	if taken := os.Getenv("FAKE_XVFB_TAKEN"); taken == "all" || slices.Contains(strings.Split(taken, ","), strconv.Itoa(n)) {
+		// The other server is our parent, the test process
+		os.WriteFile(lockPath(dir, n), []byte(fmt.Sprintf("%10d\n", os.Getppid())), 0o444)
+		os.WriteFile(socketPath(dir, n), nil, 0o777)
+		fmt.Fprintf(os.Stderr, "(EE) Fatal server error:\n(EE) Server is already active for display %d\n", n)
+		return 1
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+
+	lock := lockPath(dir, n)
+	if err := os.WriteFile(lock, []byte(fmt.Sprintf("%10d\n", os.Getpid())), 0o444); err != nil {
+		fmt.Fprintln(os.Stderr, "fake Xvfb:", err)
+		return 1
+	}
+	defer os.Remove(lock)
+	l, err := net.Listen("unix", socketPath(dir, n))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fake Xvfb:", err)
+		return 1
+	}
+	defer l.Close()
+	if os.Getenv("FAKE_XVFB_MUTE") == "" {
+		go serveX11Refusals(l)
+	}
+
+	<-sigs
+	if os.Getenv("FAKE_XVFB_STUBBORN") != "" {
+		select {}
+	}
+	if os.Getenv("FAKE_XVFB_LEAK") != "" {
+		// Skip the deferred cleanup
+		os.Exit(0)
+	}
+	return 0
+}
+
+// serveX11Refusals answers every X11 connection setup on l the way a
+// server does that wants credentials the client didn't send.
+func serveX11Refusals(l net.Listener) {
+	reason := "No protocol specified\n\x00\x00"
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		io.ReadFull(conn, make([]byte, 12))
+		reply := []byte{0, byte(len(reason) - 2), 11, 0, 0, 0, byte(len(reason) / 4), 0}
+		conn.Write(append(reply, reason...))
+		conn.Close()
+	}
+}
+
+// useFakeXvfb puts the fake server first in PATH and returns the directory
+// the X11 files are created in.
+func useFakeXvfb(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("xauth"); err != nil {
+		t.Skip("xauth not installed")
+	}
+	// Unix socket paths are short; t.TempDir can exceed the limit
+	dir, err := os.MkdirTemp("", "xvfb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	if err := os.Mkdir(filepath.Join(dir, ".X11-unix"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	old := x11TmpDir
+	x11TmpDir = dir
+	t.Cleanup(func() { x11TmpDir = old })
+
+	bin := t.TempDir()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(exe, filepath.Join(bin, "Xvfb")); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("FAKE_XVFB_TMPDIR", dir)
+	t.Setenv("XDG_RUNTIME_DIR", "")
+	return dir
+}
+
+func TestRunnerRunsCommandsAgainstItsDisplay(t *testing.T) {
+	useFakeXvfb(t)
+
+	var out bytes.Buffer
+	r := &Runner{Stdout: &out}
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer r.Stop()
+
+	if err := r.Run([]string{"sh", "-c", `echo "$DISPLAY"; test -s "$XAUTHORITY" && echo auth`}); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if got := out.String(); got != ":99\nauth\n" {
+		t.Errorf("unexpected command output %q", got)
+	}
+
+	// The display stays up for further commands
+	out.Reset()
+	if err := r.Run([]string{"sh", "-c", `echo "$DISPLAY"`}); err != nil {
+		t.Fatalf("second Run: %v", err)
+	}
+	if got := out.String(); got != ":99\n" {
+		t.Errorf("unexpected output from second command %q", got)
+	}
+}
+
+func TestRunnerPassesServerArgs(t *testing.T) {
+	useFakeXvfb(t)
+	argsFile := filepath.Join(t.TempDir(), "args")
+	t.Setenv("FAKE_XVFB_ARGS", argsFile)
+
+	r := &Runner{Display: ":7", ServerArgs: []string{"-screen", "0", "800x600x16"}}
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer r.Stop()
+
+	data, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := strings.Split(string(data), "\n")
+	if len(args) != 8 || args[0] != ":7" || args[1] != "-auth" || strings.Join(args[3:], " ") != "-nolisten tcp -screen 0 800x600x16" {
+		t.Errorf("unexpected Xvfb args %q", args)
+	}
+}
+
+func TestRunnerDisableAccessControlSkipsAuthFile(t *testing.T) {
+	useFakeXvfb(t)
+	argsFile := filepath.Join(t.TempDir(), "args")
+	t.Setenv("FAKE_XVFB_ARGS", argsFile)
+
+	r := &Runner{Display: ":7", DisableAccessControl: true}
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer r.Stop()
+
+	if r.AuthFile() != "" {
+		t.Errorf("expected no Xauthority file, got %q", r.AuthFile())
+	}
+	data, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.ReplaceAll(string(data), "\n", " "); !strings.HasPrefix(got, ":7 -ac -nolisten tcp ") {
+		t.Errorf("unexpected Xvfb args %q", got)
+	}
+}
+
+func TestRunnerStartTimings(t *testing.T) {
+	useFakeXvfb(t)
+
+	r := &Runner{}
+	if got := r.StartTimings(); got != (Timings{}) {
+		t.Errorf("expected no timings before Start, got %+v", got)
+	}
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	timings := r.StartTimings()
+	r.Stop()
+	if timings.Spawn <= 0 || timings.Ready <= 0 {
+		t.Errorf("expected both phases timed, got %+v", timings)
+	}
+	if got := r.StartTimings(); got != (Timings{}) {
+		t.Errorf("expected no timings after Stop, got %+v", got)
+	}
+}
+
+func TestRunnerNormalizesDisplay(t *testing.T) {
+	useFakeXvfb(t)
+
+	r := &Runner{Display: "localhost:7.0"}
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer r.Stop()
+	if got := r.ActiveDisplay(); got != ":7" {
+		t.Errorf("expected :7, got %q", got)
+	}
+}
+
+func TestRunnerReportsCommandExitStatus(t *testing.T) {
+	useFakeXvfb(t)
+
+	r := &Runner{}
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer r.Stop()
+
+	err := r.Run([]string{"sh", "-c", "exit 3"})
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+		t.Errorf("expected exit status 3, got %v", err)
+	}
+}
+
+func TestRunnerStopKillsXvfbAndRemovesAuthFile(t *testing.T) {
+	useFakeXvfb(t)
+
+	r := &Runner{}
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	server, authFile := r.server.(*xvfbServer), r.authFile
+
+	if err := r.Stop(); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if server.cmd.ProcessState == nil {
+		t.Error("expected Xvfb to have exited")
+	}
+	if _, err := os.Stat(authFile); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected %s to be removed, got %v", authFile, err)
+	}
+	if err := r.Stop(); err != nil {
+		t.Errorf("second Stop: %v", err)
+	}
+}
+
+func TestRunnerMergeAuthFile(t *testing.T) {
+	useFakeXvfb(t)
+	user := filepath.Join(t.TempDir(), "Xauthority")
+	if err := exec.Command("xauth", "-q", "-f", user, "add", ":1", ".", "11111111111111111111111111111111").Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Runner{MergeAuthFile: user}
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	display := r.ActiveDisplay()
+	entries := authEntries(t, user)
+	if want := authEntries(t, r.AuthFile())[display]; want == "" || entries[display] != want {
+		t.Errorf("expected the cookie for %s in %s, got %v", display, user, entries)
+	}
+
+	if err := r.Stop(); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	entries = authEntries(t, user)
+	if _, ok := entries[display]; ok || entries[":1"] == "" {
+		t.Errorf("expected only the entry for :1 to be left, got %v", entries)
+	}
+}
+
+func TestRunnerStopLetsXvfbCleanUp(t *testing.T) {
+	dir := useFakeXvfb(t)
+
+	r := &Runner{Display: ":8"}
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if err := r.Stop(); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	for _, path := range []string{lockPath(dir, 8), socketPath(dir, 8)} {
+		if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected Xvfb to remove %s, got %v", path, err)
+		}
+	}
+}
+
+func TestRunnerRefusesDisplayInUse(t *testing.T) {
+	dir := useFakeXvfb(t)
+	touch(t, filepath.Join(dir, ".X5-lock"))
+
+	r := &Runner{Display: ":5"}
+	err := r.Start()
+	if err == nil {
+		r.Stop()
+		t.Fatal("expected an error for a display in use")
+	}
+	if !strings.Contains(err.Error(), "display :5 is already in use") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestRunnerRunBeforeStart(t *testing.T) {
+	r := &Runner{}
+	if err := r.Run([]string{"true"}); err == nil {
+		t.Fatal("expected an error running before Start")
+	}
+}
+
+func TestRunnerCombinesScreenGeometryAndServerArgs(t *testing.T) {
+	useFakeXvfb(t)
+	argsFile := filepath.Join(t.TempDir(), "args")
+	t.Setenv("FAKE_XVFB_ARGS", argsFile)
+
+	r := &Runner{ScreenGeometry: "1920x1080x24", ServerArgs: []string{"-dpi", "96"}}
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer r.Stop()
+
+	data, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := strings.Split(string(data), "\n")
+	if got := strings.Join(args[3:], " "); got != "-nolisten tcp -screen 0 1920x1080x24 -dpi 96" {
+		t.Errorf("unexpected Xvfb args %q", got)
+	}
+}
+
+func TestRunnersStartedConcurrentlyGetDistinctDisplays(t *testing.T) {
+	useFakeXvfb(t)
+
+	const count = 8
+	runners := make([]*Runner, count)
+	errs := make([]error, count)
+	var wg sync.WaitGroup
+	for i := range runners {
+		runners[i] = &Runner{}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = runners[i].Start()
+		}(i)
+	}
+	wg.Wait()
+
+	seen := map[string]bool{}
+	for i, r := range runners {
+		defer r.Stop()
+		if errs[i] != nil {
+			t.Fatalf("Start %d: %v", i, errs[i])
+		}
+		if display := r.ActiveDisplay(); seen[display] {
+			t.Errorf("display %s was handed out twice", display)
+		} else {
+			seen[display] = true
+		}
+	}
+}
+
+func TestRunnerRefusesDisplayReservedInProcess(t *testing.T) {
+	dir := useFakeXvfb(t)
+	if !reserveDisplay(dir, 77) {
+		t.Fatal("expected to reserve :77")
+	}
+	defer releaseDisplay(dir, 77)
+
+	r := &Runner{Display: ":77"}
+	if err := r.Start(); err == nil || !strings.Contains(err.Error(), "another Runner in this process") {
+		r.Stop()
+		t.Errorf("expected the reservation to be honored, got %v", err)
+	}
+}
+
+func TestRunnerRetriesWhenAnotherServerTakesTheDisplay(t *testing.T) {
+	useFakeXvfb(t)
+	t.Setenv("FAKE_XVFB_TAKEN", "99,100")
+
+	var out bytes.Buffer
+	r := &Runner{Stdout: &out}
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer r.Stop()
+
+	if err := r.Run([]string{"sh", "-c", `echo "$DISPLAY"`}); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if got := out.String(); got != ":101\n" {
+		t.Errorf("expected to end up on :101, got %q", got)
+	}
+}
+
+func TestRunnerGivesUpAfterStartAttempts(t *testing.T) {
+	useFakeXvfb(t)
+	t.Setenv("FAKE_XVFB_TAKEN", "all")
+
+	r := &Runner{StartAttempts: 3}
+	err := r.Start()
+	if err == nil {
+		r.Stop()
+		t.Fatal("expected Start to fail")
+	}
+	if !strings.Contains(err.Error(), "after 3 attempts") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestRunnerDoesNotRetryAPinnedDisplay(t *testing.T) {
+	useFakeXvfb(t)
+	t.Setenv("FAKE_XVFB_TAKEN", "7")
+
+	r := &Runner{Display: ":7"}
+	err := r.Start()
+	if err == nil {
+		r.Stop()
+		t.Fatal("expected Start to fail")
+	}
+	if !errors.Is(err, errDisplayTaken) || strings.Contains(err.Error(), "attempts") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestRunnerSurfacesXvfbErrorWhenItExitsEarly(t *testing.T) {
+	useFakeXvfb(t)
+	t.Setenv("FAKE_XVFB_FAIL", "(EE) Fatal server error:\n(EE) Unrecognized option: -bogus")
+
+	r := &Runner{ReadyTimeout: time.Minute}
+	start := time.Now()
+	err := r.Start()
+	if err == nil {
+		r.Stop()
+		t.Fatal("expected Start to fail")
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("expected Start to notice the exit at once, took %s", elapsed)
+	}
+	if !errors.Is(err, errServerExited) || !strings.Contains(err.Error(), "Unrecognized option: -bogus") {
+		t.Errorf("expected Xvfb's own message in the error, got: %v", err)
+	}
+	if errors.Is(err, errDisplayTaken) {
+		t.Errorf("an early exit is not a display conflict: %v", err)
+	}
+}
+
+func TestRunnerPassesFontPath(t *testing.T) {
+	useFakeXvfb(t)
+	argsFile := filepath.Join(t.TempDir(), "args")
+	t.Setenv("FAKE_XVFB_ARGS", argsFile)
+
+	r := &Runner{FontPath: "/opt/fonts/misc"}
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	r.Stop()
+	data, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "-fp\n/opt/fonts/misc") {
+		t.Errorf("expected -fp in Xvfb's arguments, got %q", data)
+	}
+}
+
+func TestRunnerFallsBackToSystemFontPath(t *testing.T) {
+	useFakeXvfb(t)
+	t.Setenv("FAKE_XVFB_NO_FONTS", "1")
+	fonts := t.TempDir()
+	old := systemFontPaths
+	systemFontPaths = []string{filepath.Join(fonts, "missing"), fonts}
+	defer func() { systemFontPaths = old }()
+
+	var logs []string
+	r := &Runner{Logf: func(format string, args ...any) { logs = append(logs, fmt.Sprintf(format, args...)) }}
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	r.Stop()
+	if !slices.Contains(logs, "Xvfb couldn't open its default font, retrying with -fp "+fonts) {
+		t.Errorf("expected the fallback to be logged, got %q", logs)
+	}
+
+	systemFontPaths = []string{filepath.Join(fonts, "missing")}
+	r = &Runner{}
+	if err := r.Start(); err == nil || !strings.Contains(err.Error(), "could not open default font") {
+		r.Stop()
+		t.Errorf("expected Xvfb's font error without a fallback, got %v", err)
+	}
+}
+
+func TestRunnerTeesXvfbOutputToServerOutput(t *testing.T) {
+	useFakeXvfb(t)
+	t.Setenv("FAKE_XVFB_FAIL", "(EE) Unrecognized option: -bogus")
+
+	var out bytes.Buffer
+	r := &Runner{ServerOutput: &out}
+	err := r.Start()
+	if err == nil {
+		r.Stop()
+		t.Fatal("expected Start to fail")
+	}
+	if got := out.String(); got != "(EE) Unrecognized option: -bogus\n" {
+		t.Errorf("unexpected ServerOutput contents %q", got)
+	}
+	if !strings.Contains(err.Error(), "Unrecognized option: -bogus") {
+		t.Errorf("expected Xvfb's message in the error as well, got: %v", err)
+	}
+}
+
+func TestRunnerKeepsOnlyXvfbsLastLines(t *testing.T) {
+	useFakeXvfb(t)
+	var lines []string
+	for i := 1; i <= startupOutputLines+10; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+	t.Setenv("FAKE_XVFB_FAIL", strings.Join(lines, "\n"))
+
+	err := (&Runner{}).Start()
+	if err == nil {
+		t.Fatal("expected Start to fail")
+	}
+	if strings.Contains(err.Error(), "line 10\n") || !strings.Contains(err.Error(), "line 11\n") || !strings.HasSuffix(err.Error(), "line 60") {
+		t.Errorf("expected the last %d lines, got: %v", startupOutputLines, err)
+	}
+}
+
+func TestRunnerTimesOutHungCommands(t *testing.T) {
+	useFakeXvfb(t)
+
+	r := &Runner{Timeout: 200 * time.Millisecond}
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer r.Stop()
+
+	start := time.Now()
+	err := r.Run([]string{"sleep", "30"})
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("expected ErrTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("expected the command to be stopped at the timeout, took %s", elapsed)
+	}
+
+	// A quick command is unaffected
+	if err := r.Run([]string{"true"}); err != nil {
+		t.Errorf("expected a fast command to pass, got %v", err)
+	}
+}
+
+func TestRunnerRunCmd(t *testing.T) {
+	r := &Runner{Env: []string{"FROM_RUNNER=1"}}
+	if err := r.Attach(":42", "/tmp/auth"); err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+	dir := t.TempDir()
+
+	var out bytes.Buffer
+	cmd := exec.Command("sh", "-c", `echo "$DISPLAY $XAUTHORITY $MINE ${FROM_RUNNER-unset}"; pwd`)
+	cmd.Env = []string{"MINE=kept", "PATH=" + os.Getenv("PATH")}
+	cmd.Dir = dir
+	cmd.Stdout = &out
+	if err := r.RunCmd(cmd); err != nil {
+		t.Fatalf("RunCmd: %v", err)
+	}
+	if got, want := out.String(), ":42 /tmp/auth kept unset\n"+dir+"\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if !cmd.SysProcAttr.Setpgid {
+		t.Error("expected the command to get its own process group")
+	}
+
+	if err := r.RunCmd(cmd); err == nil {
+		t.Error("expected an error running a command twice")
+	}
+	if err := (&Runner{}).RunCmd(exec.Command("true")); err == nil {
+		t.Error("expected an error before Start")
+	}
+}
+
+func TestRunnerKeepDisplay(t *testing.T) {
+	r := &Runner{KeepDisplay: true}
+	if err := r.Attach(":42", "/tmp/auth"); err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+	script := `echo "$DISPLAY ${XAUTHORITY-none}"`
+	for _, tt := range []struct {
+		env  []string
+		want string
+	}{
+		{[]string{"DISPLAY=:0", "XAUTHORITY=/home/me/.Xauthority"}, ":0 /home/me/.Xauthority\n"},
+		{[]string{"DISPLAY=:0"}, ":0 none\n"},
+		{[]string{"DISPLAY="}, ":42 /tmp/auth\n"},
+		{nil, ":42 /tmp/auth\n"},
+	} {
+		var out bytes.Buffer
+		r.Env = append(tt.env, "PATH="+os.Getenv("PATH"))
+		r.Stdout = &out
+		if err := r.Run([]string{"sh", "-c", script}); err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+		if got := out.String(); got != tt.want {
+			t.Errorf("with %q: got %q, want %q", tt.env, got, tt.want)
+		}
+	}
+}
+
+func TestRunnerRunCmdKeepsSysProcAttr(t *testing.T) {
+	r := &Runner{}
+	if err := r.Attach(":42", ""); err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+	attr := &syscall.SysProcAttr{}
+	cmd := exec.Command("true")
+	cmd.SysProcAttr = attr
+	if err := r.RunCmd(cmd); err != nil {
+		t.Fatalf("RunCmd: %v", err)
+	}
+	if cmd.SysProcAttr != attr || attr.Setpgid {
+		t.Error("expected the caller's process attributes to be left alone")
+	}
+}
+
+func TestRunnerRunCmdTimesOut(t *testing.T) {
+	r := &Runner{Timeout: 200 * time.Millisecond}
+	if err := r.Attach(":42", ""); err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+
+	start := time.Now()
+	err := r.RunCmd(exec.Command("sleep", "30"))
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("expected ErrTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("expected the command to be stopped at the timeout, took %s", elapsed)
+	}
+	if err := r.RunCmd(exec.Command("true")); err != nil {
+		t.Errorf("expected a fast command to pass, got %v", err)
+	}
+}
+
+func TestRunnerDisplayOverridesEnv(t *testing.T) {
+	useFakeXvfb(t)
+
+	var out bytes.Buffer
+	r := &Runner{Stdout: &out, Env: []string{"PATH=" + os.Getenv("PATH"), "DISPLAY=:1", "EXTRA=yes"}}
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer r.Stop()
+
+	if err := r.Run([]string{"sh", "-c", `echo "$DISPLAY $EXTRA"`}); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if got := out.String(); got != ":99 yes\n" {
+		t.Errorf("unexpected command output %q", got)
+	}
+}
+
+func TestRunnerRunsCommandsInDir(t *testing.T) {
+	useFakeXvfb(t)
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	r := &Runner{Stdout: &out, Dir: dir}
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer r.Stop()
+
+	if err := r.Run([]string{"pwd"}); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if got := strings.TrimSpace(out.String()); got != dir {
+		t.Errorf("expected to run in %s, got %s", dir, got)
+	}
+}
+
+func TestRunnerRunIn(t *testing.T) {
+	useFakeXvfb(t)
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	r := &Runner{Stdout: &out, Dir: "/"}
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer r.Stop()
+
+	if err := r.RunIn(context.Background(), dir, []string{"pwd"}); err != nil {
+		t.Fatalf("RunIn: %v", err)
+	}
+	if err := r.Run([]string{"pwd"}); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if got := strings.Fields(out.String()); len(got) != 2 || got[0] != dir || got[1] != "/" {
+		t.Errorf("expected %s then Dir, got %q", dir, got)
+	}
+}
+
+func TestRunnerExposesDisplayAndAuthFile(t *testing.T) {
+	dir := useFakeXvfb(t)
+
+	r := &Runner{}
+	if r.ActiveDisplay() != "" || r.AuthFile() != "" || r.SocketPath() != "" {
+		t.Error("expected no display, auth file or socket before Start")
+	}
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if got := r.ActiveDisplay(); got != ":99" {
+		t.Errorf("expected :99, got %q", got)
+	}
+	if pid := r.server.(*xvfbServer).cmd.Process.Pid; r.ServerPID() != pid {
+		t.Errorf("expected Xvfb's PID %d, got %d", pid, r.ServerPID())
+	}
+	if _, err := os.Stat(r.AuthFile()); err != nil {
+		t.Errorf("expected the auth file to exist: %v", err)
+	}
+	if got, want := r.SocketPath(), socketPath(dir, 99); got != want {
+		t.Errorf("expected socket %s, got %s", want, got)
+	}
+
+	r.Stop()
+	if r.ActiveDisplay() != "" || r.AuthFile() != "" || r.ServerPID() != 0 {
+		t.Error("expected no display, auth file or PID after Stop")
+	}
+}
+
+func TestRunnerReportsAutoAllocatedDisplay(t *testing.T) {
+	dir := useFakeXvfb(t)
+	// :99 is taken, so auto-allocation moves on
+	if err := os.WriteFile(lockPath(dir, 99), []byte("1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Runner{}
+	if r.ActiveDisplay() != "" || r.ServerPID() != 0 {
+		t.Error("expected no display or PID before Start")
+	}
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer r.Stop()
+	if got := r.ActiveDisplay(); got != ":100" {
+		t.Errorf("expected :100, got %q", got)
+	}
+	if _, err := os.Stat(socketPath(dir, 100)); err != nil {
+		t.Errorf("expected the server's socket for :100: %v", err)
+	}
+	if !ownsLock(dir, 100, r.ServerPID()) {
+		t.Errorf("expected PID %d to hold the lock for :100", r.ServerPID())
+	}
+}
+
+func TestRunnerStopKillsAfterShutdownTimeout(t *testing.T) {
+	dir := useFakeXvfb(t)
+	t.Setenv("FAKE_XVFB_STUBBORN", "1")
+
+	r := &Runner{ShutdownTimeout: 200 * time.Millisecond, ReadyTimeout: 5 * time.Second}
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	server := r.server.(*xvfbServer)
+
+	start := time.Now()
+	if err := r.Stop(); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	elapsed := time.Since(start)
+	if elapsed < 200*time.Millisecond || elapsed > serverGracePeriod {
+		t.Errorf("expected Stop to wait ShutdownTimeout and no longer, took %s", elapsed)
+	}
+	if status, ok := server.cmd.ProcessState.Sys().(syscall.WaitStatus); !ok || status.Signal() != syscall.SIGKILL {
+		t.Errorf("expected Xvfb to be killed, got %v", server.cmd.ProcessState)
+	}
+	if _, err := os.Stat(lockPath(dir, 99)); !os.IsNotExist(err) {
+		t.Errorf("expected the killed server's lock file to be removed, got %v", err)
+	}
+}
+
+func TestRunnerRunKillsGroupAfterShutdownTimeout(t *testing.T) {
+	r := &Runner{Timeout: 100 * time.Millisecond, ShutdownTimeout: 200 * time.Millisecond}
+	if err := r.Attach(":42", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	err := r.Run([]string{"sh", "-c", `trap "" TERM; sleep 30 & wait`})
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("expected ErrTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond || elapsed > 5*time.Second {
+		t.Errorf("expected the command to be killed after Timeout and ShutdownTimeout, took %s", elapsed)
+	}
+}
+
+func TestRunnerAttachesToExistingDisplay(t *testing.T) {
+	var out bytes.Buffer
+	r := &Runner{Stdout: &out, Env: []string{"PATH=" + os.Getenv("PATH"), "XAUTHORITY=/home/me/.Xauthority"}}
+	if err := r.Attach(":42", ""); err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+	if err := r.Start(); err == nil {
+		t.Error("expected Start to fail on an attached Runner")
+	}
+
+	if err := r.Run([]string{"sh", "-c", `echo "$DISPLAY $XAUTHORITY"`}); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if got := out.String(); got != ":42 /home/me/.Xauthority\n" {
+		t.Errorf("unexpected command output %q", got)
+	}
+
+	if err := r.Stop(); err != nil {
+		t.Errorf("Stop: %v", err)
+	}
+	if err := r.Run([]string{"true"}); err == nil {
+		t.Error("expected Run to fail after Stop")
+	}
+}
+
+func TestRunnerAttachNeedsADisplay(t *testing.T) {
+	r := &Runner{}
+	if err := r.Attach("", ""); err == nil {
+		t.Error("expected an error attaching without a display")
+	}
+}
+
+func TestRunnerCleansStaleLock(t *testing.T) {
+	dir := useFakeXvfb(t)
+	writeLock(t, dir, 9, deadPID(t))
+	touch(t, filepath.Join(dir, ".X11-unix", "X9"))
+
+	if err := (&Runner{Display: ":9"}).Start(); err == nil {
+		t.Fatal("expected the stale lock to block Start without CleanStale")
+	}
+
+	r := &Runner{Display: ":9", CleanStale: true}
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	r.Stop()
+}
+
+func TestRunnerKeepsLiveLock(t *testing.T) {
+	dir := useFakeXvfb(t)
+	writeLock(t, dir, 9, os.Getpid())
+
+	r := &Runner{Display: ":9", CleanStale: true}
+	if err := r.Start(); err == nil {
+		r.Stop()
+		t.Fatal("expected a live server's lock to block Start")
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".X9-lock")); err != nil {
+		t.Errorf("expected the live lock to be kept: %v", err)
+	}
+}
+
+func TestRunnerWaitsForFreeDisplay(t *testing.T) {
+	dir := useFakeXvfb(t)
+	writeLock(t, dir, 9, os.Getpid())
+	time.AfterFunc(300*time.Millisecond, func() { os.Remove(filepath.Join(dir, ".X9-lock")) })
+
+	r := &Runner{Display: ":9", WaitForFree: 5 * time.Second}
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	r.Stop()
+}
+
+func TestRunnerDetachLeavesXvfbRunning(t *testing.T) {
+	useFakeXvfb(t)
+
+	r := &Runner{}
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	server, authFile := r.server.(*xvfbServer), r.authFile
+	exited := server.exited
+	t.Cleanup(func() {
+		stopXvfb(server.cmd.Process, exited, time.Second)
+		os.Remove(authFile)
+	})
+
+	r.Detach()
+	if err := r.Stop(); err != nil {
+		t.Errorf("Stop after Detach: %v", err)
+	}
+	select {
+	case err := <-exited:
+		t.Fatalf("expected Xvfb to keep running, it exited: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if _, err := os.Stat(authFile); err != nil {
+		t.Errorf("expected the auth file to be left behind: %v", err)
+	}
+	if r.ActiveDisplay() != "" {
+		t.Error("expected the Runner to forget the display")
+	}
+}
+
+func TestRunnerRunContextCancellation(t *testing.T) {
+	useFakeXvfb(t)
+
+	r := &Runner{}
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer r.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+	start := time.Now()
+	err := r.RunContext(ctx, []string{"sleep", "30"})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("expected the command to be stopped on cancel, took %s", elapsed)
+	}
+	if r.ActiveDisplay() == "" {
+		t.Error("expected Xvfb to be left for the caller to stop")
+	}
+}
+
+func TestRunnerRunContextDeadlineIsNotATimeout(t *testing.T) {
+	useFakeXvfb(t)
+
+	r := &Runner{Timeout: time.Minute}
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer r.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	err := r.RunContext(ctx, []string{"sleep", "30"})
+	if !errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrTimeout) {
+		t.Errorf("expected the caller's deadline, not ErrTimeout, got %v", err)
+	}
+}
+
+func TestRunnerRunContextAlreadyCanceled(t *testing.T) {
+	useFakeXvfb(t)
+
+	r := &Runner{}
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer r.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := r.RunContext(ctx, []string{"true"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestRunnerFramebufferFiles(t *testing.T) {
+	useFakeXvfb(t)
+	dir := t.TempDir()
+
+	r := &Runner{FramebufferDir: dir, Screens: []Screen{{Index: 0, Geometry: "800x600x24"}, {Index: 1, Geometry: "640x480x8"}}}
+	if files := r.FramebufferFiles(); files != nil {
+		t.Errorf("expected no framebuffers before Start, got %q", files)
+	}
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer r.Stop()
+
+	want := []string{filepath.Join(dir, "Xvfb_screen0"), filepath.Join(dir, "Xvfb_screen1")}
+	if files := r.FramebufferFiles(); !slices.Equal(files, want) {
+		t.Errorf("got %q, want %q", files, want)
+	}
+}
+
+func TestRunnerPlan(t *testing.T) {
+	dir := useTmpDir(t)
+	touch(t, filepath.Join(dir, ".X99-lock"))
+	bin := fakeServers(t, "Xvfb")
+
+	r := &Runner{DPI: 96}
+	display, server, err := r.Plan()
+	if err != nil {
+		t.Fatalf("Plan: %v", err)
+	}
+	if display != ":100" {
+		t.Errorf("expected the first free display, got %s", display)
+	}
+	want := []string{filepath.Join(bin, "Xvfb"), ":100", "-auth", PlannedAuthFile, "-nolisten", "tcp", "-screen", "0", DefaultScreenGeometry, "-dpi", "96"}
+	if !slices.Equal(server, want) {
+		t.Errorf("got %q, want %q", server, want)
+	}
+	if r.started() {
+		t.Error("expected Plan not to start anything")
+	}
+	if _, err := os.Stat(lockPath(dir, 100)); !os.IsNotExist(err) {
+		t.Errorf("expected no lock file for the planned display, got %v", err)
+	}
+}
+
+func TestRunnerPlanPinnedDisplayWithoutServer(t *testing.T) {
+	useTmpDir(t)
+	fakeServers(t)
+
+	display, server, err := (&Runner{Display: ":7", ServerArgs: []string{"-nocursor"}}).Plan()
+	if err != nil {
+		t.Fatalf("Plan: %v", err)
+	}
+	if display != ":7" {
+		t.Errorf("expected the pinned display, got %s", display)
+	}
+	if got := strings.Join(server, " "); got != "Xvfb :7 -auth $XAUTHORITY -nolisten tcp -nocursor" {
+		t.Errorf("unexpected command line %q", got)
+	}
+}
+
+func TestRunnerPlanInvalidDisplay(t *testing.T) {
+	if _, _, err := (&Runner{Display: "nope"}).Plan(); err == nil {
+		t.Error("expected an error for an invalid display")
+	}
+}
+
+func TestRunnerStopRemovesLeakedDisplayFiles(t *testing.T) {
+	dir := useFakeXvfb(t)
+	t.Setenv("FAKE_XVFB_LEAK", "1")
+
+	r := &Runner{Display: ":7"}
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if err := r.Stop(); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if displayInUse(dir, 7) {
+		t.Error("expected the leaked lock and socket to be removed")
+	}
+}
+
+func TestRunnerStopLeavesAttachedDisplayFiles(t *testing.T) {
+	dir := useTmpDir(t)
+	writeLock(t, dir, 7, deadPID(t))
+	touch(t, socketPath(dir, 7))
+
+	r := &Runner{}
+	if err := r.Attach(":7", ""); err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+	if err := r.Stop(); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if _, err := os.Stat(lockPath(dir, 7)); err != nil {
+		t.Errorf("expected the attached display's lock to stay, got %v", err)
+	}
+	if _, err := os.Stat(socketPath(dir, 7)); err != nil {
+		t.Errorf("expected the attached display's socket to stay, got %v", err)
+	}
+}
+
+func TestRunnerUsesSocketDir(t *testing.T) {
+	defaultDir := useFakeXvfb(t)
+	socketDir, err := os.MkdirTemp("", "xvfb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(socketDir) })
+	if err := os.Mkdir(filepath.Join(socketDir, ".X11-unix"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// Taken in the default directory, but that isn't where this server looks
+	touch(t, lockPath(defaultDir, 99))
+
+	r := &Runner{SocketDir: socketDir}
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if got := r.ActiveDisplay(); got != ":99" {
+		t.Errorf("expected :99 to be free in the socket dir, got %s", got)
+	}
+	if !displayInUse(socketDir, 99) {
+		t.Error("expected the server's files in the socket dir")
+	}
+	if err := r.Stop(); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if displayInUse(socketDir, 99) {
+		t.Error("expected the socket dir to be cleaned up")
+	}
+}
+
+func TestRunnerRunsCommandsAsCredential(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("needs root to switch users")
+	}
+	useFakeXvfb(t)
+
+	var out bytes.Buffer
+	r := &Runner{Stdout: &out, Credential: &syscall.Credential{Uid: 65534, Gid: 65534}}
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer r.Stop()
+
+	if err := r.Run([]string{"sh", "-c", `id -u; id -g; test -r "$XAUTHORITY" && echo readable`}); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if got := out.String(); got != "65534\n65534\nreadable\n" {
+		t.Errorf("unexpected command output %q", got)
+	}
+}
+
+func TestRunnerCredentialWithoutPermission(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root may switch users")
+	}
+	useFakeXvfb(t)
+
+	r := &Runner{Credential: &syscall.Credential{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}}
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer r.Stop()
+
+	err := r.Run([]string{"true"})
+	if !errors.Is(err, syscall.EPERM) || !strings.Contains(err.Error(), "running as uid") {
+		t.Errorf("expected a permission error, got %v", err)
+	}
+}
+
+func TestRunnerAutoDisplayRange(t *testing.T) {
+	dir := useFakeXvfb(t)
+	touch(t, lockPath(dir, 150))
+
+	r := &Runner{DisplayBase: 150, DisplayMax: 151}
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer r.Stop()
+	if got := r.ActiveDisplay(); got != ":151" {
+		t.Errorf("expected :151, got %s", got)
+	}
+
+	full := &Runner{DisplayBase: 150, DisplayMax: 151}
+	if err := full.Start(); err == nil || !strings.Contains(err.Error(), "no free display between :150 and :151") {
+		full.Stop()
+		t.Errorf("expected the range to be full, got %v", err)
+	}
+}
+
+func TestRunnerRejectsInvertedDisplayRange(t *testing.T) {
+	useFakeXvfb(t)
+
+	r := &Runner{DisplayBase: 200, DisplayMax: 150}
+	if err := r.Start(); err == nil {
+		r.Stop()
+		t.Fatal("expected Start to fail")
+	}
+}
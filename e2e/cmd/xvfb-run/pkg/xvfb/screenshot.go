@@ -0,0 +1,58 @@
+package xvfb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// errNoScreenshotTool means neither ImageMagick's import nor xwd and
+// convert are installed.
+var errNoScreenshotTool = errors.New("no screenshot tool found (need ImageMagick's import, or xwd and convert)")
+
+// captureScreenshot saves the root window of display as a PNG at path,
+// using import if available and xwd piped into convert otherwise.
+func captureScreenshot(display, authFile, path string) error {
+	env := displayEnv(os.Environ(), display, authFile)
+
+	if _, err := exec.LookPath("import"); err == nil {
+		cmd := exec.Command("import", "-window", "root", "png:"+path)
+		cmd.Env = env
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("import: %w: %s", err, bytes.TrimSpace(out))
+		}
+		return nil
+	}
+
+	if _, err := exec.LookPath("xwd"); err != nil {
+		return errNoScreenshotTool
+	}
+	if _, err := exec.LookPath("convert"); err != nil {
+		return errNoScreenshotTool
+	}
+	var xwdStderr, convertStderr bytes.Buffer
+	xwd := exec.Command("xwd", "-root", "-silent")
+	xwd.Env = env
+	xwd.Stderr = &xwdStderr
+	convert := exec.Command("convert", "xwd:-", "png:"+path)
+	convert.Env = env
+	convert.Stderr = &convertStderr
+	pipe, err := xwd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	convert.Stdin = pipe
+	if err := xwd.Start(); err != nil {
+		return fmt.Errorf("xwd: %w", err)
+	}
+	var errs []error
+	if err := convert.Run(); err != nil {
+		errs = append(errs, fmt.Errorf("convert: %w: %s", err, bytes.TrimSpace(convertStderr.Bytes())))
+	}
+	if err := xwd.Wait(); err != nil {
+		errs = append([]error{fmt.Errorf("xwd: %w: %s", err, bytes.TrimSpace(xwdStderr.Bytes()))}, errs...)
+	}
+	return errors.Join(errs...)
+}
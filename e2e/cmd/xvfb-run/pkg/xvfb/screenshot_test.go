@@ -0,0 +1,73 @@
+package xvfb
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeTool writes a shell script called name into dir.
+func fakeTool(t *testing.T, dir, name, script string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCaptureScreenshotUsesImport(t *testing.T) {
+	bin := t.TempDir()
+	t.Setenv("PATH", bin)
+	fakeTool(t, bin, "import", `echo "$DISPLAY $XAUTHORITY $*" > "${3#png:}"`)
+
+	path := filepath.Join(t.TempDir(), "shot.png")
+	if err := captureScreenshot(":42", "/tmp/auth", path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(data)); got != ":42 /tmp/auth -window root png:"+path {
+		t.Errorf("unexpected import invocation %q", got)
+	}
+}
+
+func TestCaptureScreenshotFallsBackToXwd(t *testing.T) {
+	bin := t.TempDir()
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+"/bin:/usr/bin")
+	if _, err := os.Stat("/usr/bin/import"); err == nil {
+		t.Skip("ImageMagick's import is installed")
+	}
+	fakeTool(t, bin, "xwd", `echo "xwd of $DISPLAY"`)
+	fakeTool(t, bin, "convert", `cat > "${2#png:}"`)
+
+	path := filepath.Join(t.TempDir(), "shot.png")
+	if err := captureScreenshot(":42", "/tmp/auth", path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data); got != "xwd of :42\n" {
+		t.Errorf("unexpected screenshot contents %q", got)
+	}
+}
+
+func TestCaptureScreenshotWithoutTools(t *testing.T) {
+	fakeServers(t)
+
+	err := captureScreenshot(":42", "/tmp/auth", filepath.Join(t.TempDir(), "shot.png"))
+	if !errors.Is(err, errNoScreenshotTool) {
+		t.Errorf("expected errNoScreenshotTool, got %v", err)
+	}
+}
+
+func TestRunnerScreenshotBeforeStart(t *testing.T) {
+	r := &Runner{}
+	if err := r.Screenshot(filepath.Join(t.TempDir(), "shot.png")); err == nil {
+		t.Fatal("expected an error taking a screenshot before Start")
+	}
+}
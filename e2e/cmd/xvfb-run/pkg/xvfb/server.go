@@ -0,0 +1,329 @@
+package xvfb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// serverGracePeriod is how long Xvfb gets to remove its lock file and
+// socket after SIGTERM before it is killed, unless Runner.ShutdownTimeout
+// says otherwise.
+const serverGracePeriod = 3 * time.Second
+
+// locateServer finds the X server binary. An explicit path is used as is
+// once it checks out as an executable file. Otherwise PATH is searched:
+// Xvfb is preferred; if it is missing, preferred (e.g. Xephyr or Xvnc, or
+// a full path) is used.
+func locateServer(path, preferred string) (string, error) {
+	if path != "" {
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", fmt.Errorf("xvfb: X server binary: %w", err)
+		}
+		if info.IsDir() || info.Mode().Perm()&0o111 == 0 {
+			return "", fmt.Errorf("xvfb: X server binary %s is not an executable file", path)
+		}
+		return path, nil
+	}
+	searched := []string{"Xvfb"}
+	if preferred != "" && preferred != "Xvfb" {
+		searched = append(searched, preferred)
+	}
+	for _, name := range searched {
+		if path, err := exec.LookPath(name); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("%w in PATH (looked for %s)", ErrServerNotFound, strings.Join(searched, ", "))
+}
+
+// options is what goes on Xvfb's command line.
+type options struct {
+	display         string
+	authFile        string
+	noAccessControl bool
+	screenGeometry  string
+	screens         []Screen
+	listenTCP       bool
+	depth           int
+	dpi             int
+	fontPath        string
+	fbdir           string
+	glx             bool
+	serverArgs      []string
+}
+
+// ARGBDepth is the screen depth that gives clients 32-bit visuals with an
+// alpha channel.
+const ARGBDepth = 32
+
+// buildXvfbArgs assembles Xvfb's arguments. Clients need the cookie in
+// authFile, or nothing with noAccessControl. TCP is turned off unless
+// listenTCP is set, like xvfb-run does; serverArgs come last so they can
+// override that, the screen and the DPI. A depth replaces that of every
+// screen, and ARGBDepth also turns on the Composite extension that ARGB
+// visuals need. glx turns on the GLX and RENDER extensions for OpenGL
+// clients.
+func buildXvfbArgs(opts options) []string {
+	args := []string{opts.display}
+	if opts.authFile != "" {
+		args = append(args, "-auth", opts.authFile)
+	}
+	if opts.noAccessControl {
+		args = append(args, "-ac")
+	}
+	if opts.listenTCP {
+		args = append(args, "-listen", "tcp")
+	} else {
+		args = append(args, "-nolisten", "tcp")
+	}
+	for _, screen := range opts.screens {
+		args = append(args, "-screen", strconv.Itoa(screen.Index), withDepth(screen.Geometry, opts.depth))
+	}
+	geometry := opts.screenGeometry
+	if len(opts.screens) > 0 {
+		geometry = ""
+	} else if geometry == "" && len(opts.serverArgs) == 0 {
+		geometry = DefaultScreenGeometry
+	}
+	if geometry != "" {
+		args = append(args, "-screen", "0", withDepth(geometry, opts.depth))
+	}
+	if opts.depth == ARGBDepth {
+		args = append(args, "+extension", "Composite")
+	}
+	if opts.glx {
+		args = append(args, "+extension", "GLX", "+extension", "RENDER")
+	}
+	if opts.dpi > 0 {
+		args = append(args, "-dpi", strconv.Itoa(opts.dpi))
+	}
+	if opts.fontPath != "" {
+		args = append(args, "-fp", opts.fontPath)
+	}
+	if opts.fbdir != "" {
+		args = append(args, "-fbdir", opts.fbdir)
+	}
+	return append(args, opts.serverArgs...)
+}
+
+// systemFontPaths are where distributions install the misc fonts that
+// hold Xvfb's default font, "fixed".
+var systemFontPaths = []string{
+	"/usr/share/fonts/X11/misc",
+	"/usr/share/X11/fonts/misc",
+	"/usr/lib/X11/fonts/misc",
+}
+
+// missingDefaultFont reports whether output is Xvfb failing for lack of
+// its default font, as it does in images with a broken font path.
+func missingDefaultFont(output string) bool {
+	return strings.Contains(output, "could not open default font")
+}
+
+// fallbackFontPath returns the first of systemFontPaths that exists, or "".
+func fallbackFontPath() string {
+	for _, dir := range systemFontPaths {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return dir
+		}
+	}
+	return ""
+}
+
+// withDepth returns geometry, WIDTHxHEIGHT with an optional xDEPTH, with
+// its depth set to depth. A zero depth leaves geometry as it is.
+func withDepth(geometry string, depth int) string {
+	if depth == 0 {
+		return geometry
+	}
+	parts := strings.SplitN(geometry, "x", 3)
+	if len(parts) < 2 {
+		return geometry
+	}
+	return fmt.Sprintf("%sx%sx%d", parts[0], parts[1], depth)
+}
+
+// framebufferFile is where Xvfb -fbdir dir keeps the given screen.
+func framebufferFile(dir string, screen int) string {
+	return filepath.Join(dir, fmt.Sprintf("Xvfb_screen%d", screen))
+}
+
+// xvfbServer is one Xvfb process serving display, its Server. Other X
+// servers that take Xvfb's arguments, and Xwayland, are run the same way.
+type xvfbServer struct {
+	cmd     *exec.Cmd
+	dir     string
+	display string
+	exited  <-chan error
+	// gone is set once the process has exited and exited is drained
+	gone bool
+	// connect makes Ready wait for an X11 handshake too, see
+	// ReadyCheckConnect
+	connect bool
+	// logf, if set, hears that Ready is still waiting
+	logf func(string, ...any)
+	// grace is how long Stop waits after SIGTERM before SIGKILL
+	grace time.Duration
+	// nice is the server's niceness, see Runner.ServerNice
+	nice int
+}
+
+func (s *xvfbServer) Start() error {
+	if err := startNiced(s.cmd, s.nice); err != nil {
+		return err
+	}
+	s.exited = monitorXvfb(s.cmd)
+	return nil
+}
+
+// Ready waits for the display's socket and, with connect, for the server
+// to answer on it, all within timeout. It returns errServerExited if the
+// server dies first, errDisplayTaken if the display turns out to be
+// locked by another server, and ctx.Err() if ctx is done first.
+func (s *xvfbServer) Ready(ctx context.Context, timeout time.Duration) error {
+	n, err := DisplayNumber(s.display)
+	if err != nil {
+		return err
+	}
+	began := time.Now()
+	err = waitForDisplayOrExit(ctx, s.dir, s.display, timeout, s.exited, s.logf)
+	s.gone = errors.Is(err, errServerExited)
+	if (err == nil || s.gone) && !ownsLock(s.dir, n, s.PID()) {
+		return errDisplayTaken
+	}
+	if err == nil && s.connect {
+		err = waitForX11(ctx, s.dir, s.display, timeout-time.Since(began), s.exited, s.logf)
+		s.gone = errors.Is(err, errServerExited)
+	}
+	return err
+}
+
+// Stop terminates the server and removes any lock file and socket it
+// didn't.
+func (s *xvfbServer) Stop() error {
+	if s.gone {
+		return nil
+	}
+	if err := stopXvfb(s.cmd.Process, s.exited, s.grace); err != nil {
+		return err
+	}
+	s.gone = true
+	return cleanupDisplayFiles(s.dir, s.display, s.PID())
+}
+
+func (s *xvfbServer) Display() string { return s.display }
+
+func (s *xvfbServer) PID() int { return s.cmd.Process.Pid }
+
+// monitorXvfb waits for cmd in the background. The returned channel gets
+// the result of Wait once the process exits and is never closed otherwise,
+// so a receive that would block means the server is still running.
+func monitorXvfb(cmd *exec.Cmd) <-chan error {
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	return exited
+}
+
+// process is the part of *os.Process stopXvfb needs.
+type process interface {
+	Signal(sig os.Signal) error
+	Kill() error
+}
+
+// stopXvfb asks Xvfb to exit with SIGTERM so it cleans up after itself,
+// and kills it if it is still running after grace. exited is the channel
+// from monitorXvfb; stopXvfb returns once the process is gone.
+//
+// Some sandboxes don't let the wrapper signal its own children and both
+// fail with EPERM. Then the server gets another grace period to exit on
+// its own, and if it doesn't stopXvfb gives up rather than wait for ever,
+// returning an error that says the server may still be running.
+func stopXvfb(proc process, exited <-chan error, grace time.Duration) error {
+	termErr := proc.Signal(syscall.SIGTERM)
+	if errors.Is(termErr, os.ErrProcessDone) {
+		return nil
+	}
+	if termErr == nil {
+		select {
+		case <-exited:
+			return nil
+		case <-time.After(grace):
+		}
+	}
+	killErr := proc.Kill()
+	if killErr == nil || errors.Is(killErr, os.ErrProcessDone) {
+		<-exited
+		return nil
+	}
+	select {
+	case <-exited:
+		return nil
+	case <-time.After(grace):
+	}
+	return fmt.Errorf("can't stop the server, it may still be running: %w", errors.Join(termErr, killErr))
+}
+
+// startupOutputLines is how much of Xvfb's output Start keeps to explain
+// a failure; the fatal error is usually near the end.
+const startupOutputLines = 50
+
+// ringBuffer keeps the last max lines written to it, so a server that
+// fails can be explained with its own messages.
+type ringBuffer struct {
+	mu      sync.Mutex
+	max     int
+	lines   []string
+	partial []byte
+}
+
+func newRingBuffer(max int) *ringBuffer {
+	return &ringBuffer{max: max}
+}
+
+func (b *ringBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.partial = append(b.partial, p...)
+	for {
+		i := bytes.IndexByte(b.partial, '\n')
+		if i < 0 {
+			break
+		}
+		b.lines = append(b.lines, string(b.partial[:i]))
+		b.partial = b.partial[i+1:]
+	}
+	if extra := len(b.lines) - b.max; extra > 0 {
+		b.lines = append(b.lines[:0], b.lines[extra:]...)
+	}
+	return len(p), nil
+}
+
+// String returns the kept lines, counting an unfinished last one, without
+// surrounding blank space.
+func (b *ringBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	lines := b.lines
+	if len(b.partial) > 0 {
+		if len(lines) == b.max {
+			lines = lines[1:]
+		}
+		lines = append(lines[:len(lines):len(lines)], string(b.partial))
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
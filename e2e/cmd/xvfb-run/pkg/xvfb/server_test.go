@@ -0,0 +1,350 @@
+package xvfb
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeServers creates empty executables with the given names and makes
+// them the only thing in PATH.
+func fakeServers(t *testing.T, names ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir)
+	return dir
+}
+
+func TestLocateServerPrefersXvfb(t *testing.T) {
+	dir := fakeServers(t, "Xvfb", "Xephyr")
+
+	got, err := locateServer("", "Xephyr")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := filepath.Join(dir, "Xvfb"); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestLocateServerFallsBack(t *testing.T) {
+	dir := fakeServers(t, "Xephyr")
+
+	got, err := locateServer("", "Xephyr")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := filepath.Join(dir, "Xephyr"); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestLocateServerListsWhatWasSearched(t *testing.T) {
+	fakeServers(t)
+
+	_, err := locateServer("", "Xvnc")
+	if err == nil {
+		t.Fatal("expected an error with no server in PATH")
+	}
+	if !strings.Contains(err.Error(), "looked for Xvfb, Xvnc") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestLocateServerExplicitPath(t *testing.T) {
+	dir := fakeServers(t, "Xvfb")
+	custom := filepath.Join(t.TempDir(), "Xvfb-custom")
+	if err := os.WriteFile(custom, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := locateServer(custom, "Xephyr")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != custom {
+		t.Errorf("expected the explicit path over %s, got %s", dir, got)
+	}
+}
+
+func TestLocateServerRejectsBadPath(t *testing.T) {
+	fakeServers(t, "Xvfb")
+	plain := filepath.Join(t.TempDir(), "Xvfb")
+	if err := os.WriteFile(plain, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, want := range map[string]string{
+		plain:                  "is not an executable file",
+		filepath.Dir(plain):    "is not an executable file",
+		plain + "-nonexistent": "no such file",
+	} {
+		if _, err := locateServer(path, ""); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("locateServer(%s): expected %q, got %v", path, want, err)
+		}
+	}
+}
+
+func TestBuildXvfbArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		opts options
+		want string
+	}{
+		{"defaults", options{}, "-nolisten tcp -screen 0 1280x1024x24"},
+		{"geometry", options{screenGeometry: "800x600x16"}, "-nolisten tcp -screen 0 800x600x16"},
+		{"server args replace default screen", options{serverArgs: []string{"-dpi", "96"}}, "-nolisten tcp -dpi 96"},
+		{"geometry and server args", options{screenGeometry: "800x600x16", serverArgs: []string{"-dpi", "96"}}, "-nolisten tcp -screen 0 800x600x16 -dpi 96"},
+		{"listen tcp", options{listenTCP: true}, "-listen tcp -screen 0 1280x1024x24"},
+		{"two screens", options{screens: []Screen{{0, "1280x1024x24"}, {1, "800x600x24"}}}, "-nolisten tcp -screen 0 1280x1024x24 -screen 1 800x600x24"},
+		{"screens replace geometry", options{screenGeometry: "640x480x8", screens: []Screen{{1, "800x600x24"}}}, "-nolisten tcp -screen 1 800x600x24"},
+		{"dpi", options{dpi: 96}, "-nolisten tcp -screen 0 1280x1024x24 -dpi 96"},
+		{"font path", options{fontPath: "/usr/share/fonts/X11/misc"}, "-nolisten tcp -screen 0 1280x1024x24 -fp /usr/share/fonts/X11/misc"},
+		{"fbdir with screens", options{screens: []Screen{{0, "800x600x24"}, {1, "640x480x8"}}, fbdir: "/tmp/fb"}, "-nolisten tcp -screen 0 800x600x24 -screen 1 640x480x8 -fbdir /tmp/fb"},
+		{"dpi before server args", options{dpi: 120, serverArgs: []string{"-nocursor"}}, "-nolisten tcp -dpi 120 -nocursor"},
+		{"argb depth", options{depth: ARGBDepth}, "-nolisten tcp -screen 0 1280x1024x32 +extension Composite"},
+		{"glx", options{glx: true}, "-nolisten tcp -screen 0 1280x1024x24 +extension GLX +extension RENDER"},
+		{"depth on every screen", options{depth: 16, screens: []Screen{{0, "800x600x24"}, {1, "640x480"}}}, "-nolisten tcp -screen 0 800x600x16 -screen 1 640x480x16"},
+		{"depth skips server args screens", options{depth: ARGBDepth, serverArgs: []string{"-screen", "0", "640x480x8"}}, "-nolisten tcp +extension Composite -screen 0 640x480x8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.display, tt.opts.authFile = ":5", "/tmp/auth"
+			args := buildXvfbArgs(tt.opts)
+			if !slices.Equal(args[:3], []string{":5", "-auth", "/tmp/auth"}) {
+				t.Errorf("expected display and auth first, got %q", args)
+			}
+			if got := strings.Join(args[3:], " "); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithDepth(t *testing.T) {
+	tests := []struct {
+		geometry string
+		depth    int
+		want     string
+	}{
+		{"1280x1024x24", 32, "1280x1024x32"},
+		{"800x600", 16, "800x600x16"},
+		{"800x600x24", 0, "800x600x24"},
+		{"bogus", 32, "bogus"},
+	}
+	for _, tt := range tests {
+		if got := withDepth(tt.geometry, tt.depth); got != tt.want {
+			t.Errorf("withDepth(%q, %d) = %q, want %q", tt.geometry, tt.depth, got, tt.want)
+		}
+	}
+}
+
+func TestBuildXvfbArgsTwoScreens(t *testing.T) {
+	args := buildXvfbArgs(options{
+		display:  ":5",
+		authFile: "/tmp/auth",
+		screens:  []Screen{{Index: 0, Geometry: "1280x1024x24"}, {Index: 1, Geometry: "800x600x24"}},
+	})
+	want := []string{":5", "-auth", "/tmp/auth", "-nolisten", "tcp", "-screen", "0", "1280x1024x24", "-screen", "1", "800x600x24"}
+	if !slices.Equal(args, want) {
+		t.Errorf("got %q, want %q", args, want)
+	}
+}
+
+func TestBuildXvfbArgsNoAccessControl(t *testing.T) {
+	args := buildXvfbArgs(options{display: ":5", noAccessControl: true})
+	want := []string{":5", "-ac", "-nolisten", "tcp", "-screen", "0", "1280x1024x24"}
+	if !slices.Equal(args, want) {
+		t.Errorf("got %q, want %q", args, want)
+	}
+}
+
+func TestBuildXvfbArgsListenTCPOmitsNolisten(t *testing.T) {
+	args := buildXvfbArgs(options{display: ":5", authFile: "/tmp/auth", listenTCP: true})
+	if slices.Contains(args, "-nolisten") {
+		t.Errorf("expected no -nolisten with listenTCP, got %q", args)
+	}
+}
+
+func TestMonitorXvfbReportsExit(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "exit 3")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-monitorXvfb(cmd):
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+			t.Errorf("expected exit status 3, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the exit to be reported")
+	}
+}
+
+func TestMonitorXvfbStaysQuietWhileRunning(t *testing.T) {
+	cmd := exec.Command("sleep", "30")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	exited := monitorXvfb(cmd)
+
+	select {
+	case err := <-exited:
+		t.Fatalf("expected the server to keep running, got %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	cmd.Process.Kill()
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the kill to be reported")
+	}
+}
+
+func TestRingBufferKeepsLastLines(t *testing.T) {
+	b := newRingBuffer(3)
+	b.Write([]byte("one\ntwo\nthr"))
+	b.Write([]byte("ee\nfour\n"))
+	b.Write([]byte("(EE) no screens"))
+
+	if got := b.String(); got != "three\nfour\n(EE) no screens" {
+		t.Errorf("unexpected output %q", got)
+	}
+}
+
+func TestRingBufferEmpty(t *testing.T) {
+	b := newRingBuffer(3)
+	if got := b.String(); got != "" {
+		t.Errorf("expected nothing, got %q", got)
+	}
+	if n, err := b.Write([]byte("\n\n")); n != 2 || err != nil {
+		t.Errorf("Write = %d, %v", n, err)
+	}
+	if got := b.String(); got != "" {
+		t.Errorf("expected blank lines to be trimmed, got %q", got)
+	}
+}
+
+func TestStopXvfbTerminatesGracefully(t *testing.T) {
+	cmd := exec.Command("sleep", "30")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	exited := monitorXvfb(cmd)
+
+	start := time.Now()
+	if err := stopXvfb(cmd.Process, exited, time.Minute); err != nil {
+		t.Fatalf("stopXvfb: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("expected SIGTERM to be enough, took %s", elapsed)
+	}
+	if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); !ok || status.Signal() != syscall.SIGTERM {
+		t.Errorf("expected the process to die of SIGTERM, got %v", cmd.ProcessState)
+	}
+}
+
+func TestStopXvfbKillsAfterGrace(t *testing.T) {
+	cmd := exec.Command("sh", "-c", `trap "" TERM; exec sleep 30`)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	exited := monitorXvfb(cmd)
+	// Give the shell time to ignore SIGTERM
+	time.Sleep(200 * time.Millisecond)
+
+	if err := stopXvfb(cmd.Process, exited, 200*time.Millisecond); err != nil {
+		t.Fatalf("stopXvfb: %v", err)
+	}
+	if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); !ok || status.Signal() != syscall.SIGKILL {
+		t.Errorf("expected the process to be killed, got %v", cmd.ProcessState)
+	}
+}
+
+// unkillable is a process the wrapper isn't allowed to signal, as in
+// some sandboxes.
+type unkillable struct {
+	signals []os.Signal
+	killed  bool
+}
+
+func (p *unkillable) Signal(sig os.Signal) error {
+	p.signals = append(p.signals, sig)
+	return os.NewSyscallError("kill", syscall.EPERM)
+}
+
+func (p *unkillable) Kill() error {
+	p.killed = true
+	return os.NewSyscallError("kill", syscall.EPERM)
+}
+
+func TestStopXvfbGivesUpOnUnkillableProcess(t *testing.T) {
+	proc := &unkillable{}
+
+	start := time.Now()
+	err := stopXvfb(proc, make(chan error), 100*time.Millisecond)
+	if !errors.Is(err, syscall.EPERM) || !strings.Contains(err.Error(), "may still be running") {
+		t.Errorf("expected an EPERM error saying the server may still be running, got %v", err)
+	}
+	if len(proc.signals) != 1 || proc.signals[0] != syscall.SIGTERM || !proc.killed {
+		t.Errorf("expected SIGTERM and then a kill to be tried, got %v and killed=%v", proc.signals, proc.killed)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected stopXvfb to give up, took %s", elapsed)
+	}
+}
+
+func TestStopXvfbWaitsForUnkillableProcessToExit(t *testing.T) {
+	exited := make(chan error, 1)
+	exited <- nil
+
+	if err := stopXvfb(&unkillable{}, exited, time.Minute); err != nil {
+		t.Errorf("expected no error once the process exits on its own, got %v", err)
+	}
+}
+
+func TestStopXvfbAfterExit(t *testing.T) {
+	cmd := exec.Command("true")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	exited := monitorXvfb(cmd)
+	err := <-exited
+	// Put the result back for stopXvfb, as if nobody had read it
+	ch := make(chan error, 1)
+	ch <- err
+
+	if err := stopXvfb(cmd.Process, ch, time.Minute); err != nil {
+		t.Errorf("expected no error stopping an exited server, got %v", err)
+	}
+}
+
+func TestCheckServer(t *testing.T) {
+	fakeServers(t, "Xvfb")
+
+	if err := (&Runner{}).CheckServer(); err != nil {
+		t.Errorf("expected Xvfb to be found, got %v", err)
+	}
+	if err := (&Runner{Backend: BackendXwayland}).CheckServer(); !errors.Is(err, ErrServerNotFound) {
+		t.Errorf("expected ErrServerNotFound for a missing Xwayland, got %v", err)
+	}
+
+	fakeServers(t)
+	if err := (&Runner{}).CheckServer(); !errors.Is(err, ErrServerNotFound) {
+		t.Errorf("expected ErrServerNotFound with nothing in PATH, got %v", err)
+	}
+}
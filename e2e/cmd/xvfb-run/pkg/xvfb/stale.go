@@ -0,0 +1,112 @@
+package xvfb
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// StaleDisplay is a display whose files an X server that is no longer
+// running left behind, keeping -a from using it.
+type StaleDisplay struct {
+	Display string
+	// PID is the server that held the lock file, or 0 for a socket
+	// without one that nothing listens on.
+	PID int
+	// Files are the lock file and socket that are left.
+	Files []string
+}
+
+// FindStaleDisplays looks in socketDir, /tmp if empty, for displays with
+// a lock file whose server is gone, as isStaleLock says, or with only a
+// socket that refuses connections. Displays of running servers, and locks
+// whose owner can't be told, are never reported.
+func FindStaleDisplays(socketDir string) ([]StaleDisplay, error) {
+	dir := socketDir
+	if dir == "" {
+		dir = x11TmpDir
+	}
+	locks, err := filepath.Glob(filepath.Join(dir, ".X*-lock"))
+	if err != nil {
+		return nil, err
+	}
+	sockets, err := filepath.Glob(filepath.Join(dir, ".X11-unix", "X*"))
+	if err != nil {
+		return nil, err
+	}
+	var displays []int
+	for _, path := range append(locks, sockets...) {
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), ".X"), "-lock")
+		if n, err := strconv.Atoi(strings.TrimPrefix(name, "X")); err == nil && n >= 0 && !slices.Contains(displays, n) {
+			displays = append(displays, n)
+		}
+	}
+	slices.Sort(displays)
+
+	var stale []StaleDisplay
+	for _, n := range displays {
+		if d, ok := staleDisplay(dir, n); ok {
+			stale = append(stale, d)
+		}
+	}
+	return stale, nil
+}
+
+// RemoveStaleDisplay deletes the files of d, found by FindStaleDisplays
+// in socketDir, once it has checked again that no server has taken the
+// display since.
+func RemoveStaleDisplay(socketDir string, d StaleDisplay) error {
+	dir := socketDir
+	if dir == "" {
+		dir = x11TmpDir
+	}
+	n, err := DisplayNumber(d.Display)
+	if err != nil {
+		return err
+	}
+	if _, ok := staleDisplay(dir, n); !ok {
+		return fmt.Errorf("xvfb: %s is no longer stale", d.Display)
+	}
+	return removeStaleLock(dir, n)
+}
+
+// staleDisplay describes display n in dir if its files are stale.
+func staleDisplay(dir string, n int) (StaleDisplay, bool) {
+	d := StaleDisplay{Display: DisplayString(n)}
+	lock, socket := lockPath(dir, n), socketPath(dir, n)
+	pid, err := lockOwner(dir, n)
+	switch {
+	case err == nil:
+		if stale, err := isStaleLock(dir, d.Display); err != nil || !stale {
+			return d, false
+		}
+		d.PID, d.Files = pid, []string{lock}
+	case errors.Is(err, os.ErrNotExist):
+		if !socketRefused(socket) {
+			return d, false
+		}
+	default:
+		return d, false
+	}
+	if _, err := os.Lstat(socket); err == nil {
+		d.Files = append(d.Files, socket)
+	}
+	return d, true
+}
+
+// socketRefused reports whether the Unix socket path exists but nothing
+// accepts connections on it.
+func socketRefused(path string) bool {
+	conn, err := net.DialTimeout("unix", path, x11DialTimeout)
+	if err == nil {
+		conn.Close()
+		return false
+	}
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
@@ -0,0 +1,86 @@
+package xvfb
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// listenStale makes display n's socket in dir with a listener on it; with
+// closed, nothing is left listening.
+func listenStale(t *testing.T, dir string, n int, closed bool) {
+	t.Helper()
+	l, err := net.Listen("unix", socketPath(dir, n))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	if closed {
+		l.Close()
+		return
+	}
+	t.Cleanup(func() { l.Close() })
+}
+
+func TestFindAndRemoveStaleDisplays(t *testing.T) {
+	dir := useTmpDir(t)
+	dead := deadPID(t)
+	writeLock(t, dir, 7000, dead)
+	touch(t, socketPath(dir, 7000))
+	writeLock(t, dir, 7001, os.Getpid())
+	listenStale(t, dir, 7001, false)
+	touch(t, filepath.Join(dir, ".X7002-lock"))
+	listenStale(t, dir, 7003, false)
+	listenStale(t, dir, 7004, true)
+	writeLock(t, dir, 7005, dead)
+
+	stale, err := FindStaleDisplays("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []StaleDisplay{
+		{":7000", dead, []string{lockPath(dir, 7000), socketPath(dir, 7000)}},
+		{":7004", 0, []string{socketPath(dir, 7004)}},
+		{":7005", dead, []string{lockPath(dir, 7005)}},
+	}
+	if !slices.EqualFunc(stale, want, func(a, b StaleDisplay) bool {
+		return a.Display == b.Display && a.PID == b.PID && slices.Equal(a.Files, b.Files)
+	}) {
+		t.Fatalf("got %+v, want %+v", stale, want)
+	}
+
+	for _, d := range stale {
+		if err := RemoveStaleDisplay("", d); err != nil {
+			t.Errorf("RemoveStaleDisplay(%s): %v", d.Display, err)
+		}
+		for _, path := range d.Files {
+			if _, err := os.Lstat(path); !os.IsNotExist(err) {
+				t.Errorf("expected %s to be removed, got %v", path, err)
+			}
+		}
+	}
+	for _, path := range []string{lockPath(dir, 7001), socketPath(dir, 7001), lockPath(dir, 7002), socketPath(dir, 7003)} {
+		if _, err := os.Lstat(path); err != nil {
+			t.Errorf("expected %s to be left alone, got %v", path, err)
+		}
+	}
+	if err := RemoveStaleDisplay("", StaleDisplay{Display: ":7001"}); err == nil {
+		t.Error("expected a live display not to be removed")
+	}
+}
+
+func TestFindStaleDisplaysInSocketDir(t *testing.T) {
+	useTmpDir(t)
+	dir := t.TempDir()
+	writeLock(t, dir, 7010, deadPID(t))
+
+	stale, err := FindStaleDisplays(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stale) != 1 || stale[0].Display != ":7010" {
+		t.Errorf("expected :7010 in the socket dir, got %+v", stale)
+	}
+}
@@ -0,0 +1,58 @@
+package xvfb
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// watchSocket tells through changed whenever something appears in the
+// directory path is in, with inotify, so waiting for a socket doesn't
+// depend on how often it is polled. If the directory doesn't exist yet,
+// as when Xvfb is the first server since boot, its parent is watched
+// until it appears. Events are coalesced; stop ends the watch.
+func watchSocket(path string) (changed <-chan struct{}, stop func(), err error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Non-blocking, so closing it ends a pending read
+	f := os.NewFile(uintptr(fd), "inotify")
+	const mask = syscall.IN_CREATE | syscall.IN_MOVED_TO
+	dir, watchingParent := filepath.Dir(path), false
+	if _, err := syscall.InotifyAddWatch(fd, dir, mask); errors.Is(err, syscall.ENOENT) {
+		_, err = syscall.InotifyAddWatch(fd, filepath.Dir(dir), mask)
+		watchingParent = true
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+	} else if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	events := make(chan struct{}, 1)
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			if _, err := f.Read(buf); err != nil {
+				return
+			}
+			if watchingParent {
+				// Fails until it is the directory that appeared. The
+				// socket may beat the watch, but is then there for the
+				// check the event below leads to.
+				if _, err := syscall.InotifyAddWatch(fd, dir, mask); err == nil {
+					watchingParent = false
+				}
+			}
+			select {
+			case events <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return events, func() { f.Close() }, nil
+}
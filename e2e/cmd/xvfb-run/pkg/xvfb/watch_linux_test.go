@@ -0,0 +1,63 @@
+package xvfb
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Once watched, the socket is only polled for every
+// displayWatchPollInterval, so waits well under it came from the watch.
+
+func TestWaitForDisplayWatchesForSocket(t *testing.T) {
+	dir := useTmpDir(t)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		os.WriteFile(filepath.Join(dir, ".X11-unix", "X5010"), nil, 0o644)
+	}()
+
+	began := time.Now()
+	if err := waitForDisplay(context.Background(), dir, ":5010", 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(began); waited >= displayWatchPollInterval/2 {
+		t.Errorf("expected the watch to see the socket at once, waited %s", waited)
+	}
+}
+
+func TestWaitForDisplayWatchesForSocketDirectory(t *testing.T) {
+	dir := t.TempDir()
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		os.Mkdir(filepath.Join(dir, ".X11-unix"), 0o755)
+		time.Sleep(50 * time.Millisecond)
+		os.WriteFile(filepath.Join(dir, ".X11-unix", "X5011"), nil, 0o644)
+	}()
+
+	began := time.Now()
+	if err := waitForDisplay(context.Background(), dir, ":5011", 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(began); waited >= displayWatchPollInterval/2 {
+		t.Errorf("expected the watch to follow the new directory, waited %s", waited)
+	}
+}
+
+func TestWaitForDisplayWatchKeepsTimeout(t *testing.T) {
+	dir := useTmpDir(t)
+	began := time.Now()
+	if err := waitForDisplay(context.Background(), dir, ":5012", 100*time.Millisecond); err == nil {
+		t.Fatal("expected a timeout error")
+	}
+	if waited := time.Since(began); waited >= displayWatchPollInterval/2 {
+		t.Errorf("expected to give up after the timeout, waited %s", waited)
+	}
+}
+
+func TestWatchSocketWithoutParent(t *testing.T) {
+	if _, _, err := watchSocket(filepath.Join(t.TempDir(), "missing", ".X11-unix", "X0")); err == nil {
+		t.Error("expected an error with nothing to watch")
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package xvfb
+
+import "errors"
+
+// watchSocket needs inotify, so elsewhere waiting for a socket polls.
+func watchSocket(path string) (changed <-chan struct{}, stop func(), err error) {
+	return nil, nil, errors.New("xvfb: watching for sockets needs Linux")
+}
@@ -0,0 +1,42 @@
+package xvfb
+
+import (
+	"errors"
+	"os"
+)
+
+// WithDisplay starts Xvfb, points this process's DISPLAY and XAUTHORITY at
+// it while fn runs, then restores them and stops Xvfb. It is for code that
+// talks to X in-process, such as a browser driven through a library, where
+// Run's subprocesses don't help.
+//
+// The environment is global to the process: nothing else may read or set
+// DISPLAY or XAUTHORITY meanwhile, so WithDisplay must not be used from
+// parallel tests.
+func (r *Runner) WithDisplay(fn func(display string) error) (err error) {
+	if err := r.Start(); err != nil {
+		return err
+	}
+	defer func() { err = errors.Join(err, r.Stop()) }()
+
+	display := r.ActiveDisplay()
+	defer setenvTemporarily("DISPLAY", display)()
+	if authFile := r.AuthFile(); authFile != "" {
+		defer setenvTemporarily("XAUTHORITY", authFile)()
+	}
+	return fn(display)
+}
+
+// setenvTemporarily sets key to value and returns a func that puts back
+// what was there before, unsetting key if it wasn't set.
+func setenvTemporarily(key, value string) (restore func()) {
+	old, had := os.LookupEnv(key)
+	os.Setenv(key, value)
+	return func() {
+		if had {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
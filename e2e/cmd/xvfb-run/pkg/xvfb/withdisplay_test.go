@@ -0,0 +1,70 @@
+package xvfb
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestRunnerWithDisplaySetsAndRestoresEnv(t *testing.T) {
+	dir := useFakeXvfb(t)
+	t.Setenv("DISPLAY", ":0")
+	t.Setenv("XAUTHORITY", "")
+	os.Unsetenv("XAUTHORITY")
+
+	r := &Runner{Display: ":7"}
+	var authFile string
+	err := r.WithDisplay(func(display string) error {
+		if display != ":7" || os.Getenv("DISPLAY") != ":7" {
+			t.Errorf("expected DISPLAY :7 inside fn, got %s and %s", display, os.Getenv("DISPLAY"))
+		}
+		authFile = os.Getenv("XAUTHORITY")
+		if _, err := os.Stat(authFile); err != nil {
+			t.Errorf("expected XAUTHORITY to point at the auth file: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WithDisplay: %v", err)
+	}
+
+	if got := os.Getenv("DISPLAY"); got != ":0" {
+		t.Errorf("expected DISPLAY to be restored, got %q", got)
+	}
+	if _, ok := os.LookupEnv("XAUTHORITY"); ok {
+		t.Error("expected XAUTHORITY to be unset again")
+	}
+	if r.ServerPID() != 0 || displayInUse(dir, 7) {
+		t.Error("expected Xvfb to be stopped")
+	}
+	if _, err := os.Stat(authFile); !os.IsNotExist(err) {
+		t.Errorf("expected the auth file to be removed, got %v", err)
+	}
+}
+
+func TestRunnerWithDisplayReturnsFnError(t *testing.T) {
+	useFakeXvfb(t)
+	want := errors.New("browser crashed")
+
+	r := &Runner{}
+	if err := r.WithDisplay(func(string) error { return want }); !errors.Is(err, want) {
+		t.Errorf("expected fn's error, got %v", err)
+	}
+	if r.ServerPID() != 0 {
+		t.Error("expected Xvfb to be stopped after fn fails")
+	}
+}
+
+func TestRunnerWithDisplayStartFailure(t *testing.T) {
+	useFakeXvfb(t)
+	t.Setenv("FAKE_XVFB_FAIL", "(EE) no screens found")
+
+	called := false
+	err := (&Runner{}).WithDisplay(func(string) error {
+		called = true
+		return nil
+	})
+	if err == nil || called {
+		t.Errorf("expected Start's error without calling fn, got %v, called %v", err, called)
+	}
+}
@@ -0,0 +1,106 @@
+package xvfb
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ready checks for Runner.ReadyCheck.
+const (
+	// ReadyCheckSocket waits for the display's Unix socket to appear.
+	ReadyCheckSocket = "socket"
+	// ReadyCheckConnect also waits until the server completes the start of
+	// an X11 handshake on it, which rules out a socket that exists before
+	// the server is listening.
+	ReadyCheckConnect = "connect"
+)
+
+// x11DialTimeout bounds each connection attempt dialX11 makes.
+const x11DialTimeout = time.Second
+
+// x11BasePort is the TCP port of display 0.
+const x11BasePort = 6000
+
+// dialX11 connects to display, on its Unix socket in dir or, for a
+// "host:N" display, TCP port 6000+N on host, and sends an X11 connection
+// setup request without credentials. It succeeds once the server sends a
+// whole, well-formed reply: a refusal for lack of authorization still
+// shows the server is up and speaking X11.
+func dialX11(dir, display string) error {
+	_, n, err := normalizeDisplay(display)
+	if err != nil {
+		return err
+	}
+	network, address := "unix", socketPath(dir, n)
+	if host, _, ok := strings.Cut(display, ":"); ok && host != "" && host != "unix" {
+		network, address = "tcp", net.JoinHostPort(host, strconv.Itoa(x11BasePort+n))
+	}
+	conn, err := net.DialTimeout(network, address, x11DialTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(x11DialTimeout))
+
+	// Little-endian byte order, protocol 11.0, no authorization
+	setup := []byte{'l', 0, 11, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	if _, err := conn.Write(setup); err != nil {
+		return err
+	}
+	// status, reason length, major, minor, then the length in 4-byte
+	// units of what follows
+	var header [8]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return fmt.Errorf("reading the X11 setup reply: %w", err)
+	}
+	if status := header[0]; status > 2 {
+		return fmt.Errorf("unexpected X11 setup status %d", status)
+	}
+	if major := binary.LittleEndian.Uint16(header[2:]); major != 11 {
+		return fmt.Errorf("unexpected X11 protocol version %d", major)
+	}
+	length := int64(binary.LittleEndian.Uint16(header[6:])) * 4
+	if _, err := io.CopyN(io.Discard, conn, length); err != nil {
+		return fmt.Errorf("reading the X11 setup reply: %w", err)
+	}
+	return nil
+}
+
+// waitForX11 calls dialX11 until it succeeds, exited delivers, timeout
+// elapses or ctx is done. Like waitForDisplayOrExit, it tells logf, if
+// set, that it is still waiting.
+func waitForX11(ctx context.Context, dir, display string, timeout time.Duration, exited <-chan error, logf func(string, ...any)) error {
+	began := time.Now()
+	deadline := began.Add(timeout)
+	ticker := time.NewTicker(displayPollInterval)
+	defer ticker.Stop()
+	progress, stopProgress := progressTicker(logf)
+	defer stopProgress()
+	for {
+		err := dialX11(dir, display)
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return withKind(ErrDisplayTimeout, fmt.Errorf("%s did not accept an X11 connection within %s: %w", display, timeout, err))
+		}
+		select {
+		case err := <-exited:
+			if err == nil {
+				return errServerExited
+			}
+			return fmt.Errorf("%w: %w", errServerExited, err)
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-progress:
+			logf("Still waiting for display %s to accept a connection (%s so far)", display, time.Since(began).Round(time.Second))
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package xvfb
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listenX11 listens on display 7's socket in a new directory, which it
+// returns, and answers each connection with reply.
+func listenX11(t *testing.T, reply []byte) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "x11")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	if err := os.Mkdir(filepath.Join(dir, ".X11-unix"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("unix", socketPath(dir, 7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Read(make([]byte, 12))
+			conn.Write(reply)
+			conn.Close()
+		}
+	}()
+	return dir
+}
+
+func TestDialX11AcceptsRefusal(t *testing.T) {
+	dir := listenX11(t, append([]byte{0, 4, 11, 0, 0, 0, 1, 0}, "nope"...))
+	if err := dialX11(dir, ":7"); err != nil {
+		t.Errorf("expected a refusal to count as ready, got %v", err)
+	}
+}
+
+func TestDialX11RejectsGarbage(t *testing.T) {
+	for name, reply := range map[string][]byte{
+		"short":       {1, 0, 11},
+		"truncated":   {1, 0, 11, 0, 0, 0, 2, 0, 1, 2},
+		"bad status":  {9, 0, 11, 0, 0, 0, 0, 0},
+		"bad version": {1, 0, 10, 0, 0, 0, 0, 0},
+	} {
+		dir := listenX11(t, reply)
+		if err := dialX11(dir, ":7"); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestDialX11NoServer(t *testing.T) {
+	if err := dialX11(t.TempDir(), ":7"); err == nil {
+		t.Error("expected an error without a server")
+	}
+}
+
+func TestDialX11OverTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	port := l.Addr().(*net.TCPAddr).Port
+	if port < x11BasePort {
+		t.Skipf("port %d is below the X11 range", port)
+	}
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Read(make([]byte, 12))
+		conn.Write([]byte{1, 0, 11, 0, 0, 0, 0, 0})
+	}()
+	display := "127.0.0.1:" + strings.TrimPrefix(DisplayString(port-x11BasePort), ":")
+	if err := dialX11("", display); err != nil {
+		t.Errorf("dialX11(%s): %v", display, err)
+	}
+}
+
+func TestRunnerReadyCheckConnect(t *testing.T) {
+	useFakeXvfb(t)
+
+	r := &Runner{ReadyCheck: ReadyCheckConnect}
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	r.Stop()
+}
+
+func TestRunnerReadyCheckConnectWaitsForHandshake(t *testing.T) {
+	useFakeXvfb(t)
+	t.Setenv("FAKE_XVFB_MUTE", "1")
+
+	r := &Runner{ReadyCheck: ReadyCheckConnect, ReadyTimeout: 500 * time.Millisecond}
+	err := r.Start()
+	if err == nil {
+		r.Stop()
+		t.Fatal("expected Start to fail on a server that never answers")
+	}
+	if !strings.Contains(err.Error(), "did not accept an X11 connection") {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	r = &Runner{ReadyTimeout: 500 * time.Millisecond}
+	if err := r.Start(); err != nil {
+		t.Fatalf("expected the socket check to pass, got %v", err)
+	}
+	r.Stop()
+}
+
+func TestRunnerRejectsUnknownReadyCheck(t *testing.T) {
+	useFakeXvfb(t)
+
+	r := &Runner{ReadyCheck: "ping"}
+	if err := r.Start(); err == nil || !strings.Contains(err.Error(), "unknown ready check") {
+		r.Stop()
+		t.Errorf("expected an unknown ready check error, got %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// prefixWriter prepends a tag to every line written through it, so the
+// command's output can be told apart from the wrapper's in a shared log. A
+// partial line is held until its newline arrives or Flush is called.
+type prefixWriter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	prefix  []byte
+	partial []byte
+}
+
+func newPrefixWriter(w io.Writer, prefix string) *prefixWriter {
+	return &prefixWriter{w: w, prefix: []byte(prefix)}
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n := len(b)
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			p.partial = append(p.partial, b...)
+			break
+		}
+		line := make([]byte, 0, len(p.prefix)+len(p.partial)+i+1)
+		line = append(line, p.prefix...)
+		line = append(line, p.partial...)
+		line = append(line, b[:i+1]...)
+		p.partial = p.partial[:0]
+		b = b[i+1:]
+		if _, err := p.w.Write(line); err != nil {
+			return n - len(b), err
+		}
+	}
+	return n, nil
+}
+
+// Flush writes out a trailing partial line, with its prefix but without
+// adding a newline.
+func (p *prefixWriter) Flush() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.partial) == 0 {
+		return nil
+	}
+	line := append(append([]byte{}, p.prefix...), p.partial...)
+	p.partial = p.partial[:0]
+	_, err := p.w.Write(line)
+	return err
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   string
+	}{
+		{"one line", []string{"hello\n"}, "[child] hello\n"},
+		{"several lines in one write", []string{"a\nb\n"}, "[child] a\n[child] b\n"},
+		{"line split across writes", []string{"hel", "lo\nwor", "ld\n"}, "[child] hello\n[child] world\n"},
+		{"empty lines", []string{"\n\n"}, "[child] \n[child] \n"},
+		{"trailing partial line", []string{"done\nno newline"}, "[child] done\n[child] no newline"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			w := newPrefixWriter(&out, "[child] ")
+			for _, s := range tt.writes {
+				if n, err := w.Write([]byte(s)); n != len(s) || err != nil {
+					t.Fatalf("Write(%q) = %d, %v", s, n, err)
+				}
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if got := out.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrefixWriterHoldsPartialLines(t *testing.T) {
+	var out strings.Builder
+	w := newPrefixWriter(&out, "> ")
+	w.Write([]byte("Loading..."))
+	if out.Len() != 0 {
+		t.Errorf("expected a partial line to be held, got %q", out.String())
+	}
+	w.Write([]byte(" done\n"))
+	if got := out.String(); got != "> Loading... done\n" {
+		t.Errorf("unexpected output %q", got)
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// holdDisplay prints the display Xvfb is on and its Xauthority file, one
+// per line, so a script can point other commands at it, then waits until
+// stop delivers a signal.
+func holdDisplay(out io.Writer, display, authFile string, stop <-chan os.Signal) {
+	fmt.Fprintln(out, display)
+	fmt.Fprintln(out, authFile)
+	logEvent("display_hold", fmt.Sprintf("Holding %s until interrupted", display), "display", display)
+	<-stop
+}
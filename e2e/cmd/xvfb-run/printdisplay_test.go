@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestHoldDisplayPrintsAndWaits(t *testing.T) {
+	var out bytes.Buffer
+	stop := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	go func() {
+		holdDisplay(&out, ":99", "/tmp/xvfb-run.1.Xauthority", stop)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("expected holdDisplay to wait for a signal")
+	case <-time.After(100 * time.Millisecond):
+	}
+	stop <- syscall.SIGTERM
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected holdDisplay to return after a signal")
+	}
+
+	if got := out.String(); got != ":99\n/tmp/xvfb-run.1.Xauthority\n" {
+		t.Errorf("unexpected output %q", got)
+	}
+}
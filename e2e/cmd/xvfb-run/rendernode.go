@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// driDir is where the kernel puts DRM devices; drmSysDir describes them.
+var (
+	driDir    = "/dev/dri"
+	drmSysDir = "/sys/class/drm"
+)
+
+// checkRenderNode checks that path, for --render-node, is a device under
+// driDir, e.g. /dev/dri/renderD128.
+func checkRenderNode(path string) error {
+	rel, err := filepath.Rel(driDir, filepath.Clean(path))
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return fmt.Errorf("%s is not under %s", path, driDir)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", path)
+	}
+	return nil
+}
+
+// renderNodeEnv points the command's EGL and Mesa at the GPU behind the
+// render node path. Mesa's DRI_PRIME takes the GPU's PCI address, which is
+// only set if the node's sysfs entry has one.
+func renderNodeEnv(path string) []string {
+	env := []string{"__EGL_DEVICE=" + path}
+	device, err := filepath.EvalSymlinks(filepath.Join(drmSysDir, filepath.Base(path), "device"))
+	if err != nil {
+		return env
+	}
+	// e.g. 0000:01:00.0
+	if addr := filepath.Base(device); strings.Count(addr, ":") == 2 && strings.Contains(addr, ".") {
+		env = append(env, "DRI_PRIME=pci-"+strings.NewReplacer(":", "_", ".", "_").Replace(addr))
+	}
+	return env
+}
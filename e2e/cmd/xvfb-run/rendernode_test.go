@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fakeDRI points driDir and drmSysDir at a temporary tree with a
+// renderD128 node on the GPU at PCI address 0000:01:00.0.
+func fakeDRI(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	oldDRI, oldSys := driDir, drmSysDir
+	t.Cleanup(func() { driDir, drmSysDir = oldDRI, oldSys })
+	driDir, drmSysDir = filepath.Join(root, "dev", "dri"), filepath.Join(root, "sys", "class", "drm")
+
+	gpu := filepath.Join(root, "sys", "devices", "pci0000:00", "0000:01:00.0")
+	for _, dir := range []string{driDir, gpu, filepath.Join(drmSysDir, "renderD128")} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(gpu, filepath.Join(drmSysDir, "renderD128", "device")); err != nil {
+		t.Fatal(err)
+	}
+	node := filepath.Join(driDir, "renderD128")
+	if err := os.WriteFile(node, nil, 0o666); err != nil {
+		t.Fatal(err)
+	}
+	return node
+}
+
+func TestCheckRenderNode(t *testing.T) {
+	node := fakeDRI(t)
+	if err := checkRenderNode(node); err != nil {
+		t.Errorf("expected %s to be accepted, got %v", node, err)
+	}
+	for _, path := range []string{
+		filepath.Join(driDir, "renderD129"),
+		driDir,
+		filepath.Join(driDir, "..", "null"),
+		"/dev/null",
+	} {
+		if err := checkRenderNode(path); err == nil {
+			t.Errorf("expected %s to be rejected", path)
+		}
+	}
+}
+
+func TestRenderNodeEnv(t *testing.T) {
+	node := fakeDRI(t)
+	want := []string{"__EGL_DEVICE=" + node, "DRI_PRIME=pci-0000_01_00_0"}
+	if got := renderNodeEnv(node); !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Without a PCI address there is nothing for DRI_PRIME
+	other := filepath.Join(driDir, "renderD129")
+	if got := renderNodeEnv(other); !slices.Equal(got, []string{"__EGL_DEVICE=" + other}) {
+		t.Errorf("unexpected environment %q", got)
+	}
+}
+
+func TestRunDryRunGLXAndRenderNode(t *testing.T) {
+	restoreLogging(t)
+	node := fakeDRI(t)
+	t.Setenv("XVFB_RUN_ARGS", "")
+	t.Setenv("XVFB_SCREEN_GEOMETRY", "")
+
+	var stdout, stderr strings.Builder
+	code := run([]string{"--dry-run", "-n", "5", "--glx", "--render-node", node, "--env", "DRI_PRIME=1", "true"}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	want := "DISPLAY=:5\n" +
+		"XVFB=Xvfb :5 -auth '$XAUTHORITY' -nolisten tcp -screen 0 1280x1024x24 +extension GLX +extension RENDER\n" +
+		"ENV=__EGL_DEVICE=" + node + "\n" +
+		"ENV=DRI_PRIME=pci-0000_01_00_0\n" +
+		"ENV=DRI_PRIME=1\n" +
+		"COMMAND=true\n"
+	if got := stdout.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	if code := run([]string{"--dry-run", "--render-node", "/dev/null", "true"}, &stdout, &stderr); code != 2 {
+		t.Errorf("expected exit code 2 for a node outside %s, got %d", driDir, code)
+	}
+}
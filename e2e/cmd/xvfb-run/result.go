@@ -0,0 +1,31 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// result is what --json reports on stderr when the wrapper finishes.
+type result struct {
+	Display      string           `json:"display,omitempty"`
+	ExitCode     int              `json:"exit_code"`
+	DurationMS   int64            `json:"duration_ms"`
+	XvfbPID      int              `json:"xvfb_pid,omitempty"`
+	Framebuffers []string         `json:"framebuffers,omitempty"`
+	Screenshot   string           `json:"screenshot,omitempty"`
+	Recording    string           `json:"recording,omitempty"`
+	Error        string           `json:"error,omitempty"`
+	Attempts     int              `json:"attempts,omitempty"`
+	Timings      map[string]int64 `json:"timings,omitempty"`
+	// TeardownErrors counts failures to stop the X server at cleanup,
+	// which may have left it running.
+	TeardownErrors int `json:"teardown_errors,omitempty"`
+}
+
+// writeResult fills in how long the run took since started and writes res
+// to w as a single line of JSON.
+func writeResult(w io.Writer, res result, started time.Time) error {
+	res.DurationMS = time.Since(started).Milliseconds()
+	return json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteResult(t *testing.T) {
+	var out strings.Builder
+	res := result{Display: ":99", ExitCode: 1, XvfbPID: 4242, Screenshot: "/tmp/shot.png"}
+	if err := writeResult(&out, res, time.Now().Add(-1500*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Count(out.String(), "\n") != 1 {
+		t.Errorf("expected a single line, got %q", out.String())
+	}
+	var got map[string]any
+	if err := json.Unmarshal([]byte(out.String()), &got); err != nil {
+		t.Fatalf("invalid JSON %q: %v", out.String(), err)
+	}
+	if got["display"] != ":99" || got["exit_code"] != 1.0 || got["xvfb_pid"] != 4242.0 || got["screenshot"] != "/tmp/shot.png" {
+		t.Errorf("unexpected result %v", got)
+	}
+	if ms, _ := got["duration_ms"].(float64); ms < 1500 {
+		t.Errorf("expected duration_ms of at least 1500, got %v", got["duration_ms"])
+	}
+	if _, ok := got["recording"]; ok {
+		t.Errorf("expected no recording key without a recording, got %v", got)
+	}
+}
+
+func TestWriteResultKeepsZeroExitCode(t *testing.T) {
+	var out strings.Builder
+	if err := writeResult(&out, result{Display: ":99"}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `"exit_code":0`) {
+		t.Errorf("expected exit_code 0 to be reported, got %q", out.String())
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"xvfb-run/pkg/xvfb"
+)
+
+// errMaxRuntime is what the wrapper fails with once --max-runtime is used
+// up. It is an xvfb.ErrTimeout, so the wrapper exits with timeoutExitCode.
+var errMaxRuntime = fmt.Errorf("%w: --max-runtime used up", xvfb.ErrTimeout)
+
+// runWithRetries runs args in dir on runner's display and, while it exits
+// non-zero, runs it again up to retries more times, so a flaky UI test
+// gets another chance on the same server. Each attempt is a fresh process
+// in its own process group and is bounded by runner's Timeout, while ctx
+// bounds all of them together: once it is done the running attempt is
+// killed, no more are made and errMaxRuntime is returned. It returns how
+// many attempts were made and the last one's error, and gives up early
+// once the wrapper has caught a signal.
+func runWithRetries(ctx context.Context, runner *xvfb.Runner, dir string, args []string, retries int) (int, error) {
+	if caughtSignal.Load() != 0 {
+		return 0, nil
+	}
+	attempt := 1
+	for {
+		err := runner.RunIn(ctx, dir, args)
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = errMaxRuntime
+		}
+		if err == nil || attempt > retries || ctx.Err() != nil || caughtSignal.Load() != 0 {
+			if retries > 0 {
+				if err == nil {
+					logEvent("command_retry", fmt.Sprintf("Command succeeded on attempt %d of %d", attempt, retries+1), "attempts", attempt)
+				} else {
+					logEvent("command_retry", fmt.Sprintf("Command failed after %d attempts", attempt), "attempts", attempt)
+				}
+			}
+			return attempt, err
+		}
+		logEvent("command_retry", fmt.Sprintf("Command exited with code %d, retrying (attempt %d of %d)", exitCode(err), attempt+1, retries+1), "exit_code", exitCode(err), "attempt", attempt+1)
+		attempt++
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"xvfb-run/pkg/xvfb"
+)
+
+// flakyCommand fails until it has been run succeedOn times, counting its
+// runs in a file under dir.
+func flakyCommand(dir string, succeedOn int) []string {
+	count := filepath.Join(dir, "count")
+	return []string{"sh", "-c", `n=$(($(cat "$1" 2>/dev/null || echo 0) + 1)); echo $n > "$1"; echo "attempt $n"; [ $n -ge $2 ]`, "sh", count, strconv.Itoa(succeedOn)}
+}
+
+func TestRunRetriesUntilSuccess(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+
+	var stdout, stderr strings.Builder
+	args := append([]string{"--reuse", "--retries", "3", "--json", "--"}, flakyCommand(t.TempDir(), 3)...)
+	if code := run(args, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if got := stdout.String(); got != "attempt 1\nattempt 2\nattempt 3\n" {
+		t.Errorf("expected three attempts, got %q", got)
+	}
+	lines := strings.Split(strings.TrimSpace(stderr.String()), "\n")
+	var res result
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &res); err != nil {
+		t.Fatalf("bad --json output %q: %v", stderr.String(), err)
+	}
+	if res.Attempts != 3 {
+		t.Errorf("expected 3 attempts in the result, got %d", res.Attempts)
+	}
+}
+
+func TestRunRetriesGivesUp(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+
+	var stdout, stderr strings.Builder
+	args := append([]string{"--reuse", "-q", "--retries", "1", "--"}, flakyCommand(t.TempDir(), 5)...)
+	if code := run(args, &stdout, &stderr); code != 1 {
+		t.Errorf("expected the last attempt's exit code 1, got %d", code)
+	}
+	if got := stdout.String(); got != "attempt 1\nattempt 2\n" {
+		t.Errorf("expected two attempts, got %q", got)
+	}
+}
+
+func TestRunRejectsNegativeRetries(t *testing.T) {
+	restoreLogging(t)
+
+	var stdout, stderr strings.Builder
+	if code := run([]string{"--retries", "-1", "true"}, &stdout, &stderr); code != 2 {
+		t.Errorf("expected exit code 2, got %d", code)
+	}
+}
+
+func TestRunMaxRuntimeCapsRetries(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+
+	var stdout, stderr strings.Builder
+	began := time.Now()
+	code := run([]string{"--reuse", "--retries", "10", "--timeout", "300ms", "--max-runtime", "700ms", "sleep", "5"}, &stdout, &stderr)
+	elapsed := time.Since(began)
+	if code != timeoutExitCode {
+		t.Errorf("expected exit code %d, got %d: %s", timeoutExitCode, code, stderr.String())
+	}
+	if elapsed > 3*time.Second {
+		t.Errorf("expected --max-runtime to stop the retries, took %s", elapsed)
+	}
+	if !strings.Contains(stderr.String(), "--max-runtime of 700ms used up") {
+		t.Errorf("expected a --max-runtime message, got %q", stderr.String())
+	}
+}
+
+func TestRunMaxRuntimeLeavesDisplayForAfter(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+	out := filepath.Join(t.TempDir(), "after")
+
+	var stdout, stderr strings.Builder
+	code := run([]string{"--reuse", "--max-runtime", "300ms", "--after", `echo "$DISPLAY" > ` + out, "sleep", "5"}, &stdout, &stderr)
+	if code != timeoutExitCode {
+		t.Errorf("expected exit code %d, got %d: %s", timeoutExitCode, code, stderr.String())
+	}
+	if data, err := os.ReadFile(out); err != nil || strings.TrimSpace(string(data)) != ":42" {
+		t.Errorf("expected --after to run on :42, got %q, %v", data, err)
+	}
+}
+
+func TestRunMaxRuntimeCoversXvfbStartup(t *testing.T) {
+	if _, err := exec.LookPath("xauth"); err != nil {
+		t.Skip("xauth not installed")
+	}
+	restoreLogging(t)
+	t.Setenv("XVFB_RUN_ARGS", "")
+	bin := t.TempDir()
+	// An Xvfb that never creates its socket
+	if err := os.WriteFile(filepath.Join(bin, "Xvfb"), []byte("#!/bin/sh\nexec sleep 30\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	var stdout, stderr strings.Builder
+	began := time.Now()
+	code := run([]string{"-a", "--wait-timeout", "20s", "--max-runtime", "300ms", "true"}, &stdout, &stderr)
+	if code != timeoutExitCode {
+		t.Errorf("expected exit code %d, got %d: %s", timeoutExitCode, code, stderr.String())
+	}
+	if elapsed := time.Since(began); elapsed > 5*time.Second {
+		t.Errorf("expected --max-runtime to cut the wait for the display short, took %s", elapsed)
+	}
+}
+
+func TestRunWithRetriesStopsWhenContextIsDone(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+	runner := &xvfb.Runner{}
+	if err := runner.Attach(":42", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	attempts, err := runWithRetries(ctx, runner, "", []string{"sleep", "5"}, 5)
+	if !errors.Is(err, errMaxRuntime) || exitCode(err) != timeoutExitCode {
+		t.Errorf("expected errMaxRuntime, got %v", err)
+	}
+	if attempts != 1 {
+		t.Errorf("expected one attempt, got %d", attempts)
+	}
+}
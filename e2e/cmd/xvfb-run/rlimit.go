@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Go can't run code in the child between fork and exec, so resource limits
+// are applied by starting the wrapper itself with rlimitExecArg: it sets
+// the limits on its own process and then execs the real command, which
+// inherits them. --cpu-affinity's CPUs are set the same way. Only Linux is
+// supported.
+
+// rlimitExecArg is the hidden first argument that makes the wrapper apply
+// limits and exec a command instead of running normally.
+const rlimitExecArg = "__xvfb-run-rlimit-exec"
+
+// cannotExecExitCode is what shells exit with for a command that was found
+// but couldn't be run.
+const cannotExecExitCode = 126
+
+// Rlimit is one limit for the command, applied as both its soft and hard
+// limit. Name is "as" (address space, in bytes) or "nofile" (open files).
+type Rlimit struct {
+	Name  string
+	Value uint64
+}
+
+func (l Rlimit) String() string {
+	return fmt.Sprintf("%s=%d", l.Name, l.Value)
+}
+
+// rlimitResources maps an Rlimit's Name to its setrlimit resource.
+var rlimitResources = map[string]int{
+	"as":     syscall.RLIMIT_AS,
+	"nofile": syscall.RLIMIT_NOFILE,
+}
+
+// parseSize parses a byte count with an optional K, M or G suffix (powers
+// of 1024), e.g. "4G" for --rlimit-as.
+func parseSize(s string) (uint64, error) {
+	shift := 0
+	switch {
+	case strings.HasSuffix(s, "K"):
+		shift = 10
+	case strings.HasSuffix(s, "M"):
+		shift = 20
+	case strings.HasSuffix(s, "G"):
+		shift = 30
+	}
+	digits := s
+	if shift > 0 {
+		digits = s[:len(s)-1]
+	}
+	n, err := strconv.ParseUint(digits, 10, 64)
+	if err != nil || n == 0 {
+		return 0, fmt.Errorf("invalid size %q, want a positive number of bytes with an optional K, M or G suffix", s)
+	}
+	if n > (1<<64-1)>>shift {
+		return 0, fmt.Errorf("size %q is too large", s)
+	}
+	return n << shift, nil
+}
+
+// parseRlimits builds the limits for --rlimit-as and --rlimit-nofile; an
+// empty value sets no limit.
+func parseRlimits(as, nofile string) ([]Rlimit, error) {
+	var limits []Rlimit
+	if as != "" {
+		n, err := parseSize(as)
+		if err != nil {
+			return nil, fmt.Errorf("--rlimit-as: %w", err)
+		}
+		limits = append(limits, Rlimit{"as", n})
+	}
+	if nofile != "" {
+		n, err := strconv.ParseUint(nofile, 10, 64)
+		if err != nil || n == 0 {
+			return nil, fmt.Errorf("--rlimit-nofile: invalid count %q, want a positive number of files", nofile)
+		}
+		limits = append(limits, Rlimit{"nofile", n})
+	}
+	return limits, nil
+}
+
+// rlimitCommand returns the command line that runs cmd with limits and
+// pinned to cpus, if any, by way of the wrapper's own binary.
+func rlimitCommand(limits []Rlimit, cpus []int, cmd []string) ([]string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("finding the xvfb-run binary to apply resource limits: %w", err)
+	}
+	args := []string{exe, rlimitExecArg}
+	for _, l := range limits {
+		args = append(args, l.String())
+	}
+	if len(cpus) > 0 {
+		args = append(args, "cpus="+formatCPUList(cpus))
+	}
+	return append(append(args, "--"), cmd...), nil
+}
+
+// applyRlimits sets limits on the current process, so that whatever it
+// execs next inherits them. It runs in the child started by rlimitCommand.
+func applyRlimits(limits []Rlimit) error {
+	for _, l := range limits {
+		resource, ok := rlimitResources[l.Name]
+		if !ok {
+			return fmt.Errorf("unknown resource limit %q", l.Name)
+		}
+		lim := &syscall.Rlimit{Cur: l.Value, Max: l.Value}
+		if err := syscall.Setrlimit(resource, lim); err != nil {
+			return fmt.Errorf("--rlimit-%s %d: %w", l.Name, l.Value, err)
+		}
+	}
+	return nil
+}
+
+// execWithRlimits is the wrapper started with rlimitExecArg. args are the
+// limits as NAME=VALUE and the CPUs as cpus=LIST, then "--" and the
+// command. It only returns if the command can't be started.
+func execWithRlimits(args []string, stderr io.Writer) int {
+	var limits []Rlimit
+	var cpus []int
+	for len(args) > 0 && args[0] != "--" {
+		name, value, _ := strings.Cut(args[0], "=")
+		if name == "cpus" {
+			var err error
+			if cpus, err = parseCPUList(value); err != nil {
+				fmt.Fprintln(stderr, decorate("❌ "+err.Error()))
+				return cannotExecExitCode
+			}
+			args = args[1:]
+			continue
+		}
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			fmt.Fprintf(stderr, decorate("❌ invalid resource limit %q\n"), args[0])
+			return cannotExecExitCode
+		}
+		limits = append(limits, Rlimit{name, n})
+		args = args[1:]
+	}
+	if len(args) < 2 {
+		fmt.Fprintln(stderr, decorate("❌ no command to run with resource limits"))
+		return cannotExecExitCode
+	}
+	cmd := args[1:]
+	if err := applyRlimits(limits); err != nil {
+		fmt.Fprintln(stderr, decorate("❌ "+err.Error()))
+		return cannotExecExitCode
+	}
+	if len(cpus) > 0 {
+		if err := setCPUAffinity(cpus); err != nil {
+			fmt.Fprintln(stderr, decorate("❌ "+err.Error()))
+			return cannotExecExitCode
+		}
+	}
+	path, err := exec.LookPath(cmd[0])
+	if err != nil && !errors.Is(err, exec.ErrDot) {
+		fmt.Fprintln(stderr, decorate("❌ "+err.Error()))
+		return notFoundExitCode
+	}
+	err = syscall.Exec(path, cmd, os.Environ())
+	fmt.Fprintln(stderr, decorate("❌ "+err.Error()))
+	return cannotExecExitCode
+}
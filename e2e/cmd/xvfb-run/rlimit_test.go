@@ -0,0 +1,104 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestMain lets the test binary stand in for the wrapper when --rlimit-*
+// re-executes it to apply limits.
+func TestMain(m *testing.M) {
+	if len(os.Args) > 1 && os.Args[1] == rlimitExecArg {
+		os.Exit(execWithRlimits(os.Args[2:], os.Stderr))
+	}
+	os.Exit(m.Run())
+}
+
+func TestParseSize(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want uint64
+	}{
+		{"4096", 4096},
+		{"64K", 64 << 10},
+		{"512M", 512 << 20},
+		{"4G", 4 << 30},
+	} {
+		if got, err := parseSize(tt.in); err != nil || got != tt.want {
+			t.Errorf("parseSize(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"", "0", "-1", "4T", "G", "1.5G", "99999999999999999G"} {
+		if _, err := parseSize(in); err == nil {
+			t.Errorf("parseSize(%q): expected an error", in)
+		}
+	}
+}
+
+func TestParseRlimits(t *testing.T) {
+	limits, err := parseRlimits("1G", "256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Rlimit{{"as", 1 << 30}, {"nofile", 256}}; !slices.Equal(limits, want) {
+		t.Errorf("got %v, want %v", limits, want)
+	}
+	if limits, err := parseRlimits("", ""); err != nil || limits != nil {
+		t.Errorf("expected no limits, got %v, %v", limits, err)
+	}
+	if _, err := parseRlimits("", "0"); err == nil || !strings.Contains(err.Error(), "--rlimit-nofile") {
+		t.Errorf("expected a --rlimit-nofile error, got %v", err)
+	}
+	if _, err := parseRlimits("lots", ""); err == nil || !strings.Contains(err.Error(), "--rlimit-as") {
+		t.Errorf("expected a --rlimit-as error, got %v", err)
+	}
+}
+
+func TestRlimitCommand(t *testing.T) {
+	args, err := rlimitCommand([]Rlimit{{"nofile", 64}}, nil, []string{"mytool", "--flag"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{rlimitExecArg, "nofile=64", "--", "mytool", "--flag"}; !slices.Equal(args[1:], want) {
+		t.Errorf("got %q, want the binary then %q", args, want)
+	}
+}
+
+func TestRunAppliesRlimits(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("resource limits are Linux only")
+	}
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+
+	var stdout, stderr strings.Builder
+	code := run([]string{"--reuse", "--rlimit-nofile", "64", "--rlimit-as", "1G", "sh", "-c", "ulimit -n; ulimit -v"}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if got := stdout.String(); got != "64\n1048576\n" {
+		t.Errorf("expected the limits in the command, got %q", got)
+	}
+}
+
+func TestRunPassesExitCodeThroughRlimits(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("resource limits are Linux only")
+	}
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+
+	var stdout, stderr strings.Builder
+	if code := run([]string{"--reuse", "--rlimit-nofile", "64", "sh", "-c", "exit 3"}, &stdout, &stderr); code != 3 {
+		t.Errorf("expected exit code 3, got %d: %s", code, stderr.String())
+	}
+}
+
+func TestApplyRlimitsRejectsUnknownResource(t *testing.T) {
+	if err := applyRlimits([]Rlimit{{"cpu", 1}}); err == nil {
+		t.Error("expected an error for an unknown resource")
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"xvfb-run/pkg/xvfb"
+)
+
+// commandSeparator splits several commands given to one wrapper, which
+// then run one after another on the same display:
+//
+//	xvfb-run -a -- test-login --- test-checkout --- test-logout
+const commandSeparator = "---"
+
+// splitCommands splits args at each commandSeparator. Every command must
+// have at least its name.
+func splitCommands(args []string) ([][]string, error) {
+	var commands [][]string
+	for {
+		i := 0
+		for i < len(args) && args[i] != commandSeparator {
+			i++
+		}
+		if i == 0 {
+			return nil, fmt.Errorf("empty command around %s", commandSeparator)
+		}
+		commands = append(commands, args[:i])
+		if i == len(args) {
+			return commands, nil
+		}
+		args = args[i+1:]
+	}
+}
+
+// runSequence runs commands in order against runner's display, each with
+// limits applied and pinned to cpus if any are set, and retried up to
+// retries times if it fails. With failFast it stops at the first command
+// that fails; otherwise every command runs. With cwdFromArg each command
+// runs in its commandDir. It returns how many times commands were run and
+// the first failure, so the wrapper exits with that command's code, and
+// stops early once ctx is done or the wrapper has caught a signal.
+func runSequence(ctx context.Context, runner *xvfb.Runner, commands [][]string, failFast bool, retries int, limits []Rlimit, cpus []int, cwdFromArg bool) (int, error) {
+	var first error
+	attempts := 0
+	for i, command := range commands {
+		if ctx.Err() != nil || caughtSignal.Load() != 0 {
+			break
+		}
+		if len(commands) > 1 {
+			logEvent("command_start", fmt.Sprintf("Running command %d of %d: %s", i+1, len(commands), strings.Join(command, " ")), "command", command, "index", i+1)
+		} else {
+			logEvent("command_start", fmt.Sprintf("Running command: %s", strings.Join(command, " ")), "command", command)
+		}
+		dir := runner.Dir
+		if cwdFromArg {
+			dir = commandDir(command[0])
+		}
+		runArgs := command
+		if len(limits) > 0 || len(cpus) > 0 {
+			var err error
+			if runArgs, err = rlimitCommand(limits, cpus, command); err != nil {
+				return attempts, errors.Join(first, err)
+			}
+		}
+		started := time.Now()
+		n, err := runWithRetries(ctx, runner, dir, runArgs, retries)
+		attempts += n
+		logEvent("command_exit", fmt.Sprintf("Command exited with code %d", exitCode(err)), "exit_code", exitCode(err), "duration_ms", time.Since(started).Milliseconds())
+		if err != nil && first == nil {
+			first = err
+			if failFast {
+				break
+			}
+		}
+	}
+	return attempts, first
+}
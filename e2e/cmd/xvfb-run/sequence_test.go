@@ -0,0 +1,77 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitCommands(t *testing.T) {
+	got, err := splitCommands([]string{"a", "-x", "---", "b", "---", "c", "1", "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"a", "-x"}, {"b"}, {"c", "1", "2"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	for _, bad := range [][]string{{"---", "a"}, {"a", "---"}, {"a", "---", "---", "b"}} {
+		if _, err := splitCommands(bad); err == nil {
+			t.Errorf("splitCommands(%q): expected an error", bad)
+		}
+	}
+}
+
+func TestRunSequenceRunsAll(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+
+	var stdout, stderr strings.Builder
+	code := run([]string{"--reuse", "-q", "sh", "-c", "echo first; exit 2", "---", "sh", "-c", "echo second; exit 3", "---", "echo", "third"}, &stdout, &stderr)
+	if code != 2 {
+		t.Errorf("expected the first failure's exit code 2, got %d", code)
+	}
+	if got := stdout.String(); got != "first\nsecond\nthird\n" {
+		t.Errorf("expected every command to run, got %q", got)
+	}
+}
+
+func TestRunSequenceFailFast(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+
+	var stdout, stderr strings.Builder
+	code := run([]string{"--reuse", "-q", "--fail-fast", "echo", "first", "---", "sh", "-c", "exit 4", "---", "echo", "third"}, &stdout, &stderr)
+	if code != 4 {
+		t.Errorf("expected exit code 4, got %d", code)
+	}
+	if got := stdout.String(); got != "first\n" {
+		t.Errorf("expected to stop after the failure, got %q", got)
+	}
+}
+
+func TestRunSequenceChecksEveryCommand(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+
+	var stdout, stderr strings.Builder
+	if code := run([]string{"--reuse", "echo", "first", "---", "no-such-command"}, &stdout, &stderr); code != notFoundExitCode {
+		t.Errorf("expected exit code %d, got %d", notFoundExitCode, code)
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("expected nothing to run, got %q", stdout.String())
+	}
+}
+
+func TestDryRunSequence(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+
+	var stdout, stderr strings.Builder
+	if code := run([]string{"--dry-run", "--reuse", "true", "---", "echo", "a b"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if got := stdout.String(); got != "DISPLAY=:42\nCOMMAND=true\nCOMMAND=echo 'a b'\n" {
+		t.Errorf("unexpected output %q", got)
+	}
+}
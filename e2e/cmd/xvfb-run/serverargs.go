@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// parseServerArgs splits s into arguments the way a shell would: on
+// whitespace, with single quotes, double quotes and backslashes escaping it.
+// An unterminated quote runs to the end of the string.
+func parseServerArgs(s string) []string {
+	var (
+		args    []string
+		current strings.Builder
+		inToken bool
+		quote   rune
+		escaped bool
+	)
+	for _, r := range s {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inToken = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inToken = true
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if inToken {
+				args = append(args, current.String())
+				current.Reset()
+				inToken = false
+			}
+		default:
+			current.WriteRune(r)
+			inToken = true
+		}
+	}
+	if inToken {
+		args = append(args, current.String())
+	}
+	return args
+}
+
+// readArgsFile reads X server arguments from path, split like
+// parseServerArgs does, one or more to a line. Blank lines and lines
+// starting with "#" are skipped; a quote can't span lines.
+func readArgsFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var args []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		args = append(args, parseServerArgs(line)...)
+	}
+	return args, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseServerArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []string
+	}{
+		{"empty", "", nil},
+		{"blank", "   ", nil},
+		{"single screen", "-screen 0 1920x1080x24", []string{"-screen", "0", "1920x1080x24"}},
+		{
+			"multiple screens",
+			"-screen 0 1280x1024x24 -screen 1 800x600x16",
+			[]string{"-screen", "0", "1280x1024x24", "-screen", "1", "800x600x16"},
+		},
+		{"extra whitespace", "  -dpi\t96 \n -nolisten  tcp ", []string{"-dpi", "96", "-nolisten", "tcp"}},
+		{"double quotes", `-fp "/usr/share/fonts/X11/misc,built-ins"`, []string{"-fp", "/usr/share/fonts/X11/misc,built-ins"}},
+		{"single quotes", `-fp '/opt/my fonts'`, []string{"-fp", "/opt/my fonts"}},
+		{"escaped space", `-fp /opt/my\ fonts`, []string{"-fp", "/opt/my fonts"}},
+		{"quote inside token", `-fbdir=/tmp/"a b"`, []string{"-fbdir=/tmp/a b"}},
+		{"empty quoted token", `-x ""`, []string{"-x", ""}},
+		{"unterminated quote", `-fp "/opt/fonts`, []string{"-fp", "/opt/fonts"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseServerArgs(tt.in); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseServerArgs(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadArgsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "xvfb.args")
+	content := `# Screens for the visual tests
+-screen 0 1920x1080x24
+
+  # two monitors
+-screen 1 800x600x16
+-fp "/opt/my fonts" -nocursor
+	-dpi 96
+`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readArgsFile(path)
+	if err != nil {
+		t.Fatalf("readArgsFile: %v", err)
+	}
+	want := []string{"-screen", "0", "1920x1080x24", "-screen", "1", "800x600x16", "-fp", "/opt/my fonts", "-nocursor", "-dpi", "96"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestReadArgsFileOnlyComments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "xvfb.args")
+	if err := os.WriteFile(path, []byte("# nothing yet\n\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readArgsFile(path)
+	if err != nil {
+		t.Fatalf("readArgsFile: %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("expected no arguments, got %q", got)
+	}
+}
+
+func TestReadArgsFileMissing(t *testing.T) {
+	if _, err := readArgsFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
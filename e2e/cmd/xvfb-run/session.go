@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"xvfb-run/pkg/xvfb"
+)
+
+// Session is what the wrapper knows about the display its commands run on.
+// XvfbPID is 0 for a display the wrapper didn't start. Geometry, the first
+// screen's, is only known for displays found by listDisplays.
+type Session struct {
+	Display  string `json:"display"`
+	Socket   string `json:"socket,omitempty"`
+	AuthFile string `json:"auth_file,omitempty"`
+	XvfbPID  int    `json:"xvfb_pid,omitempty"`
+	Geometry string `json:"geometry,omitempty"`
+}
+
+// newSession describes runner's display once it is started or attached.
+func newSession(runner *xvfb.Runner) Session {
+	return Session{
+		Display:  runner.ActiveDisplay(),
+		Socket:   runner.SocketPath(),
+		AuthFile: runner.AuthFile(),
+		XvfbPID:  runner.ServerPID(),
+	}
+}
+
+// childEnv returns base with --export-env's variables for session set:
+// XVFB_DISPLAY_NUM, XVFB_SOCKET and XAUTHORITY, which the runner sets
+// too but some tools only trust when it comes from their parent. They
+// replace any stale values base has from an enclosing session; --env is
+// applied on top of the result, so what the user set there wins.
+//
+// Which display the command talks to depends on --reuse and
+// --prefer-existing when base already has a DISPLAY:
+//
+//	neither             Xvfb is started, DISPLAY is Xvfb's
+//	--reuse             no Xvfb, DISPLAY is the user's, as is session
+//	--prefer-existing   Xvfb is started, DISPLAY and XAUTHORITY stay the
+//	                    user's, XVFB_DISPLAY_NUM and XVFB_SOCKET are Xvfb's
+//	both                as --reuse
+//
+// Without a DISPLAY in base, all four start Xvfb and use it. keepDisplay
+// is --prefer-existing.
+func childEnv(base []string, session Session, keepDisplay bool) []string {
+	var derived []string
+	if n, err := xvfb.DisplayNumber(session.Display); err == nil {
+		derived = append(derived, "XVFB_DISPLAY_NUM="+strconv.Itoa(n))
+	}
+	if session.Socket != "" {
+		derived = append(derived, "XVFB_SOCKET="+session.Socket)
+	}
+	if session.AuthFile != "" && !(keepDisplay && hasEnv(base, "DISPLAY")) {
+		derived = append(derived, "XAUTHORITY="+session.AuthFile)
+	}
+	return mergeEnv(base, derived)
+}
+
+// hasEnv reports whether env sets key to a non-empty value.
+func hasEnv(env []string, key string) bool {
+	for _, kv := range env {
+		if value, ok := strings.CutPrefix(kv, key+"="); ok && value != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// userAuthFile is the Xauthority file X clients use by default, for
+// --merge-auth: $XAUTHORITY, or ~/.Xauthority without it.
+func userAuthFile() (string, error) {
+	if path := os.Getenv("XAUTHORITY"); path != "" {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".Xauthority"), nil
+}
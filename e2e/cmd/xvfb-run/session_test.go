@@ -0,0 +1,140 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestChildEnv(t *testing.T) {
+	base := []string{"PATH=/bin", "XVFB_DISPLAY_NUM=3"}
+	got := childEnv(base, Session{Display: ":7", Socket: "/tmp/.X11-unix/X7", AuthFile: "/tmp/auth"}, false)
+	want := []string{"PATH=/bin", "XVFB_DISPLAY_NUM=7", "XVFB_SOCKET=/tmp/.X11-unix/X7", "XAUTHORITY=/tmp/auth"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := childEnv(base, Session{Display: "wayland-0"}, false); !reflect.DeepEqual(got, base) {
+		t.Errorf("expected nothing added for an unknown session, got %q", got)
+	}
+	got = childEnv(base, Session{Display: "localhost:10.0"}, false)
+	if want := []string{"PATH=/bin", "XVFB_DISPLAY_NUM=10"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestChildEnvKeepDisplay(t *testing.T) {
+	session := Session{Display: ":7", AuthFile: "/tmp/auth"}
+	got := childEnv([]string{"DISPLAY=:0"}, session, true)
+	if want := []string{"DISPLAY=:0", "XVFB_DISPLAY_NUM=7"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	got = childEnv([]string{"DISPLAY="}, session, true)
+	if want := []string{"DISPLAY=", "XVFB_DISPLAY_NUM=7", "XAUTHORITY=/tmp/auth"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// useFakeDaemonXvfb puts fakeDaemonXvfb first in PATH as Xvfb and returns
+// a directory for --socket-dir.
+func useFakeDaemonXvfb(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("xauth"); err != nil {
+		t.Skip("xauth not installed")
+	}
+	bin, socketDir := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "Xvfb"), []byte(fakeDaemonXvfb), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(socketDir, ".X11-unix"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("XVFB_RUN_ARGS", "")
+	return socketDir
+}
+
+func TestRunDisplayMatrix(t *testing.T) {
+	restoreLogging(t)
+	socketDir := useFakeDaemonXvfb(t)
+
+	for _, tt := range []struct {
+		display string
+		flags   []string
+		want    string
+	}{
+		{":42", nil, ":13 13"},
+		{":42", []string{"--reuse"}, ":42 42"},
+		{":42", []string{"--prefer-existing"}, ":42 13"},
+		{":42", []string{"--reuse", "--prefer-existing"}, ":42 42"},
+		{"", []string{"--prefer-existing"}, ":13 13"},
+	} {
+		t.Setenv("DISPLAY", tt.display)
+		var stdout, stderr strings.Builder
+		args := append([]string{"-n", "13", "--socket-dir", socketDir, "--export-env"}, tt.flags...)
+		args = append(args, "sh", "-c", `echo "$DISPLAY $XVFB_DISPLAY_NUM"`)
+		if code := run(args, &stdout, &stderr); code != 0 {
+			t.Fatalf("DISPLAY=%s %q: expected exit code 0, got %d: %s", tt.display, tt.flags, code, stderr.String())
+		}
+		if got := strings.TrimSpace(stdout.String()); got != tt.want {
+			t.Errorf("DISPLAY=%s %q: got %q, want %q", tt.display, tt.flags, got, tt.want)
+		}
+	}
+}
+
+func TestRunExportEnv(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+	t.Setenv("XAUTHORITY", "/home/me/.Xauthority")
+
+	var stdout, stderr strings.Builder
+	code := run([]string{"--reuse", "--export-env", "--env", "XVFB_SOCKET=/custom", "sh", "-c", `echo "$XVFB_DISPLAY_NUM $XVFB_SOCKET $XAUTHORITY"`}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if got := stdout.String(); got != "42 /custom /home/me/.Xauthority\n" {
+		t.Errorf("unexpected environment %q", got)
+	}
+}
+
+func TestRunDoesNotExportEnvByDefault(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+
+	var stdout, stderr strings.Builder
+	if code := run([]string{"--reuse", "sh", "-c", `echo "${XVFB_DISPLAY_NUM-unset}"`}, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if got := stdout.String(); got != "unset\n" {
+		t.Errorf("expected no XVFB_DISPLAY_NUM, got %q", got)
+	}
+}
+
+func TestRunExportEnvSocket(t *testing.T) {
+	restoreLogging(t)
+	dir := t.TempDir()
+	t.Setenv("DISPLAY", ":42")
+
+	var stdout, stderr strings.Builder
+	if code := run([]string{"--reuse", "--socket-dir", dir, "--export-env", "sh", "-c", `echo "$XVFB_SOCKET"`}, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if got, want := strings.TrimSpace(stdout.String()), filepath.Join(dir, ".X11-unix", "X42"); got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
+
+func TestUserAuthFile(t *testing.T) {
+	t.Setenv("XAUTHORITY", "/tmp/custom.Xauthority")
+	if got, err := userAuthFile(); err != nil || got != "/tmp/custom.Xauthority" {
+		t.Errorf("expected $XAUTHORITY, got %q, %v", got, err)
+	}
+	t.Setenv("XAUTHORITY", "")
+	t.Setenv("HOME", "/home/ci")
+	if got, err := userAuthFile(); err != nil || got != "/home/ci/.Xauthority" {
+		t.Errorf("expected ~/.Xauthority, got %q, %v", got, err)
+	}
+}
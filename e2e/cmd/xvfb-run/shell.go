@@ -0,0 +1,23 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+// stdin is the wrapper's standard input; tests replace it.
+var stdin io.Reader = os.Stdin
+
+// readScript reads the --shell script from r.
+func readScript(r io.Reader) (string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(string(data)) == "" {
+		return "", errors.New("no script on stdin, pipe one in, e.g. echo 'xterm' | xvfb-run --shell")
+	}
+	return string(data), nil
+}
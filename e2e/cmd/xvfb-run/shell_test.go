@@ -0,0 +1,24 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadScript(t *testing.T) {
+	script, err := readScript(strings.NewReader("xterm &\nxmessage hi\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if script != "xterm &\nxmessage hi\n" {
+		t.Errorf("unexpected script %q", script)
+	}
+}
+
+func TestReadScriptRejectsEmptyInput(t *testing.T) {
+	for _, in := range []string{"", "  \n\t\n"} {
+		if _, err := readScript(strings.NewReader(in)); err == nil || !strings.Contains(err.Error(), "no script on stdin") {
+			t.Errorf("readScript(%q): expected a no-script error, got %v", in, err)
+		}
+	}
+}
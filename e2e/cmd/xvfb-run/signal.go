@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"xvfb-run/pkg/xvfb"
+)
+
+// signalGracePeriod is how long the child gets to exit after a forwarded
+// signal before it and Xvfb are killed.
+const signalGracePeriod = 5 * time.Second
+
+// caughtSignal is the first SIGINT/SIGTERM the wrapper received, or 0.
+var caughtSignal atomic.Int32
+
+// session is the part of *xvfb.Runner the signal handler drives.
+type session interface {
+	Signal(sig syscall.Signal) error
+	Stop() error
+}
+
+// startingSession is a Runner being started with a context that cancel
+// cancels. A signal cancels it first, so StartContext gives up and stops
+// Xvfb rather than the signal waiting for the display to be ready.
+type startingSession struct {
+	*xvfb.Runner
+	cancel context.CancelFunc
+}
+
+func (s startingSession) Signal(sig syscall.Signal) error {
+	s.cancel()
+	return s.Runner.Signal(sig)
+}
+
+// setupSignalHandling forwards SIGINT and SIGTERM to the running command's
+// process group. If the command is still around after grace, or another
+// signal arrives, it escalates to SIGKILL and stops Xvfb too, which makes
+// the pending Run in main return. The returned func stops the handling.
+func setupSignalHandling(s session, grace time.Duration) func() {
+	sigs := make(chan os.Signal, 2)
+	done := make(chan struct{})
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		var expired <-chan time.Time
+		for {
+			select {
+			case sig := <-sigs:
+				if caughtSignal.CompareAndSwap(0, int32(sig.(syscall.Signal))) {
+					s.Signal(sig.(syscall.Signal))
+					expired = time.After(grace)
+					continue
+				}
+			case <-expired:
+			case <-done:
+				return
+			}
+			s.Signal(syscall.SIGKILL)
+			s.Stop()
+			expired = nil
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigs)
+		close(done)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeSession records what the signal handler asks of it.
+type fakeSession struct {
+	mu      sync.Mutex
+	signals []syscall.Signal
+	stopped chan struct{}
+}
+
+func newFakeSession(t *testing.T) *fakeSession {
+	caughtSignal.Store(0)
+	t.Cleanup(func() { caughtSignal.Store(0) })
+	return &fakeSession{stopped: make(chan struct{})}
+}
+
+func (s *fakeSession) Signal(sig syscall.Signal) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.signals = append(s.signals, sig)
+	return nil
+}
+
+func (s *fakeSession) Stop() error {
+	close(s.stopped)
+	return nil
+}
+
+func (s *fakeSession) sent() []syscall.Signal {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]syscall.Signal(nil), s.signals...)
+}
+
+func waitForSignals(t *testing.T, s *fakeSession, n int) []syscall.Signal {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if sent := s.sent(); len(sent) >= n {
+			return sent
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected %d signals, got %v", n, s.sent())
+	return nil
+}
+
+func waitStopped(t *testing.T, s *fakeSession) {
+	t.Helper()
+	select {
+	case <-s.stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the session to be stopped")
+	}
+}
+
+func TestSignalIsForwardedToChild(t *testing.T) {
+	s := newFakeSession(t)
+	stop := setupSignalHandling(s, signalGracePeriod)
+	defer stop()
+
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+
+	sent := waitForSignals(t, s, 1)
+	if sent[0] != syscall.SIGTERM {
+		t.Errorf("expected SIGTERM to be forwarded, got %v", sent[0])
+	}
+	if got := caughtSignal.Load(); got != int32(syscall.SIGTERM) {
+		t.Errorf("expected SIGTERM to be recorded, got %d", got)
+	}
+}
+
+func TestRepeatedSignalEscalatesToSIGKILL(t *testing.T) {
+	s := newFakeSession(t)
+	stop := setupSignalHandling(s, signalGracePeriod)
+	defer stop()
+
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	waitForSignals(t, s, 1)
+	syscall.Kill(os.Getpid(), syscall.SIGINT)
+
+	sent := waitForSignals(t, s, 2)
+	if sent[1] != syscall.SIGKILL {
+		t.Errorf("expected the second signal to escalate to SIGKILL, got %v", sent[1])
+	}
+	waitStopped(t, s)
+	if got := caughtSignal.Load(); got != int32(syscall.SIGTERM) {
+		t.Errorf("expected the first signal to be recorded, got %d", got)
+	}
+}
+
+func TestStubbornChildIsKilledAfterGracePeriod(t *testing.T) {
+	s := newFakeSession(t)
+	stop := setupSignalHandling(s, 200*time.Millisecond)
+	defer stop()
+
+	syscall.Kill(os.Getpid(), syscall.SIGINT)
+
+	sent := waitForSignals(t, s, 2)
+	if sent[0] != syscall.SIGINT || sent[1] != syscall.SIGKILL {
+		t.Errorf("expected SIGINT then SIGKILL, got %v", sent)
+	}
+	waitStopped(t, s)
+}
+
+// slowXvfb never creates its display socket, so the wrapper is still
+// waiting for it when the test signals. It leaves its PID in xvfb.pid.
+const slowXvfb = `#!/bin/sh
+n=${1#:}
+dir=${XDG_RUNTIME_DIR:-/tmp}
+printf '%10d\n' $$ > "$dir/.X$n-lock"
+trap 'rm -f "$dir/.X$n-lock"; exit 0' TERM
+echo $$ > "$dir/xvfb.pid"
+while :; do sleep 0.05; done
+`
+
+func TestSignalDuringStartupCleansUp(t *testing.T) {
+	restoreLogging(t)
+	socketDir := useFakeDaemonXvfb(t)
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "Xvfb"), []byte(slowXvfb), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	caughtSignal.Store(0)
+	t.Cleanup(func() { caughtSignal.Store(0) })
+
+	codes := make(chan int, 1)
+	go func() {
+		var stdout, stderr strings.Builder
+		codes <- run([]string{"-n", "14", "--socket-dir", socketDir, "true"}, &stdout, &stderr)
+	}()
+	pidFile := filepath.Join(socketDir, "xvfb.pid")
+	var pid int
+	deadline := time.Now().Add(5 * time.Second)
+	for pid == 0 && time.Now().Before(deadline) {
+		data, _ := os.ReadFile(pidFile)
+		pid, _ = strconv.Atoi(strings.TrimSpace(string(data)))
+		time.Sleep(10 * time.Millisecond)
+	}
+	if pid == 0 {
+		t.Fatal("Xvfb didn't start")
+	}
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+
+	select {
+	case code := <-codes:
+		if code != 128+int(syscall.SIGTERM) {
+			t.Errorf("expected exit code %d, got %d", 128+int(syscall.SIGTERM), code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the wrapper kept waiting for the display after SIGTERM")
+	}
+	if processAlive(pid) {
+		t.Errorf("Xvfb (PID %d) was left running", pid)
+	}
+	if _, err := os.Stat(filepath.Join(socketDir, ".X14-lock")); !os.IsNotExist(err) {
+		t.Errorf("expected Xvfb's lock file to be gone, got %v", err)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// writeStatusFile records the session in path as KEY=VALUE lines that a
+// shell can source:
+//
+//	DISPLAY=:99
+//	XVFB_PID=12345
+//
+// XVFB_PID is left out when pid is 0.
+func writeStatusFile(path, display string, pid int) error {
+	content := fmt.Sprintf("DISPLAY=%s\n", display)
+	if pid != 0 {
+		content += fmt.Sprintf("XVFB_PID=%d\n", pid)
+	}
+	return writeFileAtomic(path, content)
+}
+
+// writeFileAtomic writes content to path under a temporary name and renames
+// it into place, so a watcher never sees it half-written.
+func writeFileAtomic(path, content string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.WriteString(content); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteStatusFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "xvfb.status")
+
+	if err := writeStatusFile(path, ":99", 12345); err != nil {
+		t.Fatalf("writeStatusFile: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data); got != "DISPLAY=:99\nXVFB_PID=12345\n" {
+		t.Errorf("unexpected status file %q", got)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only the status file to be left, got %v", entries)
+	}
+}
+
+func TestWriteStatusFileReplacesOldFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "xvfb.status")
+	if err := os.WriteFile(path, []byte("DISPLAY=:1\nXVFB_PID=1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeStatusFile(path, ":42", 0); err != nil {
+		t.Fatalf("writeStatusFile: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data); got != "DISPLAY=:42\n" {
+		t.Errorf("unexpected status file %q", got)
+	}
+}
+
+func TestWriteStatusFileInMissingDirectory(t *testing.T) {
+	if err := writeStatusFile(filepath.Join(t.TempDir(), "missing", "xvfb.status"), ":99", 1); err == nil {
+		t.Fatal("expected an error for a missing directory")
+	}
+}
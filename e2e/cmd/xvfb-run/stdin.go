@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// checkStdinMode rejects a --stdin value other than auto, inherit or null.
+func checkStdinMode(mode string) error {
+	switch mode {
+	case "auto", "inherit", "null":
+		return nil
+	}
+	return fmt.Errorf("invalid --stdin %q, want auto, inherit or null", mode)
+}
+
+// resolveStdin returns what the command reads for --stdin mode: the
+// wrapper's stdin for inherit, nothing (the null device) for null, and for
+// auto the wrapper's stdin only if it is a terminal. In CI stdin is often a
+// pipe nobody ever closes, and a tool waiting for EOF on it hangs.
+func resolveStdin(mode string) io.Reader {
+	switch mode {
+	case "inherit":
+		return stdin
+	case "auto":
+		if isTerminal(stdin) {
+			return stdin
+		}
+	}
+	return nil
+}
+
+// isTerminal reports whether stream, the wrapper's stdin or stderr, is a
+// character device such as a tty. The null device counts too, which does
+// no harm: reading it gives EOF anyway, and nobody reads what is written
+// to it.
+func isTerminal(stream any) bool {
+	f, ok := stream.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestResolveStdin(t *testing.T) {
+	old := stdin
+	t.Cleanup(func() { stdin = old })
+	pipe := strings.NewReader("piped")
+	stdin = pipe
+
+	if got := resolveStdin("inherit"); got != pipe {
+		t.Errorf("inherit: got %v, want the wrapper's stdin", got)
+	}
+	if got := resolveStdin("null"); got != nil {
+		t.Errorf("null: got %v, want nil", got)
+	}
+	if got := resolveStdin("auto"); got != nil {
+		t.Errorf("auto with a pipe: got %v, want nil", got)
+	}
+
+	tty, err := os.Open("/dev/null")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tty.Close()
+	stdin = tty
+	if got := resolveStdin("auto"); got != tty {
+		t.Errorf("auto with a character device: got %v, want it passed through", got)
+	}
+}
+
+func TestIsTerminal(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "stdin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if isTerminal(f) {
+		t.Error("expected a regular file not to be a terminal")
+	}
+	if isTerminal(strings.NewReader("")) {
+		t.Error("expected a reader that isn't a file not to be a terminal")
+	}
+}
+
+func TestCheckStdinMode(t *testing.T) {
+	for _, mode := range []string{"auto", "inherit", "null"} {
+		if err := checkStdinMode(mode); err != nil {
+			t.Errorf("%s: %v", mode, err)
+		}
+	}
+	if err := checkStdinMode("tty"); err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"xvfb-run/pkg/xvfb"
+)
+
+// A display can outlive one wrapper and be shared by several steps of a CI
+// pipeline:
+//
+//	DISPLAY=$(xvfb-run start -a)
+//	xvfb-run run "$DISPLAY" -- mytool --first
+//	xvfb-run run "$DISPLAY" -- mytool --second
+//	xvfb-run stop "$DISPLAY"
+//
+// start leaves a state file behind that run and stop read to find the
+// server's Xauthority file and PID, and "xvfb-run list" to show what is
+// left running. "xvfb-run clean" removes the lock files and sockets of
+// servers that were killed without cleaning up. "xvfb-run version" is the
+// same as --version. A real command called start, run, stop, list, clean
+// or version can still be wrapped after "--", e.g. xvfb-run -- stop.
+
+// dispatch routes args to a subcommand if the first one names it and to
+// the classic wrapper otherwise.
+func dispatch(args []string, stdout, stderr io.Writer) int {
+	logOutput, plainOutput = stderr, plainStatus(stderr)
+	if len(args) == 0 {
+		return run(args, stdout, stderr)
+	}
+	switch args[0] {
+	case "start":
+		return wrap(append([]string{"--print-display"}, args[1:]...), stdout, stderr, true)
+	case "run":
+		return runOnDisplay(args[1:], stdout, stderr)
+	case "stop":
+		return stopDisplay(args[1:])
+	case "list":
+		return listCommand(args[1:], stdout, stderr)
+	case "clean":
+		return cleanCommand(args[1:], stdout, stderr)
+	case "version":
+		writeVersion(stdout, buildInfo())
+		return 0
+	}
+	return run(args, stdout, stderr)
+}
+
+// stopGracePeriod is how long stop waits for Xvfb to exit after SIGTERM
+// before it sends SIGKILL.
+const stopGracePeriod = 5 * time.Second
+
+// stateFile is where start records how to reach the server on display.
+func stateFile(display string) string {
+	return filepath.Join(os.TempDir(), "xvfb-run-"+strings.TrimPrefix(display, ":")+".env")
+}
+
+// writeState records a server left running by start as KEY=VALUE lines.
+// socketDir is where its lock file is, "" for /tmp.
+func writeState(display, authFile, socketDir string, pid int) error {
+	content := fmt.Sprintf("DISPLAY=%s\nXAUTHORITY=%s\nXVFB_SOCKET_DIR=%s\nXVFB_PID=%d\n", display, authFile, socketDir, pid)
+	return writeFileAtomic(stateFile(display), content)
+}
+
+// readState reads what start recorded for display.
+func readState(display string) (authFile, socketDir string, pid int, err error) {
+	f, err := os.Open(stateFile(display))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", "", 0, fmt.Errorf("no server was started on %s with xvfb-run start", display)
+	}
+	if err != nil {
+		return "", "", 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), "=")
+		switch key {
+		case "XAUTHORITY":
+			authFile = value
+		case "XVFB_SOCKET_DIR":
+			socketDir = value
+		case "XVFB_PID":
+			pid, _ = strconv.Atoi(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", "", 0, err
+	}
+	if pid <= 0 {
+		return "", "", 0, fmt.Errorf("malformed state file %s", stateFile(display))
+	}
+	return authFile, socketDir, pid, nil
+}
+
+// checkSubcommandDisplay makes sure run and stop were given a ":N" display.
+func checkSubcommandDisplay(name string, args []string) error {
+	if len(args) == 0 || !strings.HasPrefix(args[0], ":") {
+		return fmt.Errorf("usage: xvfb-run %s :N", name)
+	}
+	if n, err := xvfb.DisplayNumber(args[0]); err != nil || xvfb.DisplayString(n) != args[0] {
+		return fmt.Errorf("invalid display %q", args[0])
+	}
+	return nil
+}
+
+// runOnDisplay is "xvfb-run run :N [flags] [--] command": the classic
+// wrapper with --reuse, pointed at a display from start.
+func runOnDisplay(args []string, stdout, stderr io.Writer) int {
+	if err := checkSubcommandDisplay("run", args); err != nil {
+		logError("usage", err.Error())
+		return 2
+	}
+	display := args[0]
+	authFile, _, _, err := readState(display)
+	if err != nil {
+		logError("display_reuse", err.Error())
+		return 1
+	}
+	os.Setenv("DISPLAY", display)
+	if authFile != "" {
+		os.Setenv("XAUTHORITY", authFile)
+	}
+	return run(append([]string{"--reuse"}, args[1:]...), stdout, stderr)
+}
+
+// stopDisplay is "xvfb-run stop :N": it terminates the server start left
+// on the display and removes its Xauthority and state files. The PID start
+// recorded is only signalled while the display's lock file still names
+// it; otherwise the server is gone and the PID may have been reused.
+func stopDisplay(args []string) int {
+	if err := checkSubcommandDisplay("stop", args); err != nil {
+		logError("usage", err.Error())
+		return 2
+	}
+	display := args[0]
+	authFile, socketDir, pid, err := readState(display)
+	if err != nil {
+		logError("cleanup", err.Error())
+		return 1
+	}
+	if owner, err := xvfb.LockOwner(socketDir, display); err != nil || owner != pid {
+		logWarning("cleanup", fmt.Sprintf("Xvfb on %s (PID %d) is already gone", display, pid), "display", display, "xvfb_pid", pid)
+	} else if err := terminate(pid, stopGracePeriod); err != nil {
+		logError("cleanup", fmt.Sprintf("Can't stop Xvfb on %s (PID %d): %v", display, pid, err))
+		return 1
+	}
+	if authFile != "" {
+		os.Remove(authFile)
+	}
+	os.Remove(stateFile(display))
+	logEvent("cleanup", fmt.Sprintf("Stopped Xvfb on %s", display), "display", display)
+	return 0
+}
+
+// terminate sends pid SIGTERM and, if it is still running after grace,
+// SIGKILL. A process that is already gone is not an error.
+func terminate(pid int, grace time.Duration) error {
+	if err := syscall.Kill(pid, syscall.SIGTERM); err != nil {
+		if errors.Is(err, syscall.ESRCH) {
+			return nil
+		}
+		return err
+	}
+	deadline := time.Now().Add(grace)
+	for processAlive(pid) {
+		if time.Now().After(deadline) {
+			return syscall.Kill(pid, syscall.SIGKILL)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return nil
+}
+
+// detachDisplay finishes "xvfb-run start": it records the server, prints
+// its display and lets it keep running after the wrapper exits.
+func detachDisplay(stdout io.Writer, runner *xvfb.Runner) error {
+	display, pid := runner.ActiveDisplay(), runner.ServerPID()
+	if err := writeState(display, runner.AuthFile(), runner.SocketDir, pid); err != nil {
+		return err
+	}
+	runner.Detach()
+	fmt.Fprintln(stdout, display)
+	logEvent("display_hold", fmt.Sprintf("Left Xvfb running on %s (PID %d), stop it with xvfb-run stop %s", display, pid, display), "display", display, "xvfb_pid", pid)
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// fakeDaemonXvfb is an X server stand-in that locks its display in
+// $XDG_RUNTIME_DIR, makes a socket file there and runs until SIGTERM.
+const fakeDaemonXvfb = `#!/bin/sh
+n=${1#:}
+dir=${XDG_RUNTIME_DIR:-/tmp}
+printf '%10d\n' $$ > "$dir/.X$n-lock"
+trap 'rm -f "$dir/.X$n-lock" "$dir/.X11-unix/X$n"; exit 0' TERM
+touch "$dir/.X11-unix/X$n"
+while :; do sleep 0.05; done
+`
+
+func TestStartRunStop(t *testing.T) {
+	if _, err := exec.LookPath("xauth"); err != nil {
+		t.Skip("xauth not installed")
+	}
+	restoreLogging(t)
+	t.Setenv("XVFB_RUN_ARGS", "")
+	t.Setenv("TMPDIR", t.TempDir())
+	bin, socketDir := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "Xvfb"), []byte(fakeDaemonXvfb), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(socketDir, ".X11-unix"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	var stdout, stderr strings.Builder
+	if code := dispatch([]string{"start", "-n", "12", "--socket-dir", socketDir}, &stdout, &stderr); code != 0 {
+		t.Fatalf("start: expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if got := stdout.String(); got != ":12\n" {
+		t.Errorf("start: expected the display, got %q", got)
+	}
+	authFile, _, pid, err := readState(":12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { syscall.Kill(pid, syscall.SIGKILL) })
+	if !processAlive(pid) {
+		t.Fatal("expected Xvfb to keep running after start")
+	}
+
+	stdout.Reset()
+	t.Setenv("DISPLAY", "")
+	t.Setenv("XAUTHORITY", "")
+	if code := dispatch([]string{"run", ":12", "--", "sh", "-c", "echo $DISPLAY $XAUTHORITY"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("run: expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if got := stdout.String(); got != ":12 "+authFile+"\n" {
+		t.Errorf("run: unexpected output %q", got)
+	}
+
+	if code := dispatch([]string{"stop", ":12"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("stop: expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	for _, path := range []string{stateFile(":12"), authFile, filepath.Join(socketDir, ".X12-lock")} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed after stop, got %v", path, err)
+		}
+	}
+}
+
+func TestStopWithoutStart(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("TMPDIR", t.TempDir())
+
+	var stdout, stderr strings.Builder
+	if code := dispatch([]string{"stop", ":12"}, &stdout, &stderr); code != 1 {
+		t.Errorf("expected exit code 1, got %d", code)
+	}
+	if !strings.Contains(stderr.String(), "no server was started on :12") {
+		t.Errorf("unexpected stderr %q", stderr.String())
+	}
+}
+
+func TestStopLeavesAReusedPIDAlone(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("TMPDIR", t.TempDir())
+	// The server is gone and its PID now belongs to something else
+	other := exec.Command("sleep", "30")
+	if err := other.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { other.Process.Kill(); other.Wait() })
+	authFile := filepath.Join(t.TempDir(), "Xauthority")
+	if err := os.WriteFile(authFile, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeState(":12", authFile, t.TempDir(), other.Process.Pid); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr strings.Builder
+	if code := dispatch([]string{"stop", ":12"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if !processAlive(other.Process.Pid) {
+		t.Error("expected stop not to signal a PID that no longer holds the display")
+	}
+	if !strings.Contains(stderr.String(), "already gone") {
+		t.Errorf("unexpected stderr %q", stderr.String())
+	}
+	for _, path := range []string{stateFile(":12"), authFile} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed after stop, got %v", path, err)
+		}
+	}
+}
+
+func TestSubcommandsNeedADisplay(t *testing.T) {
+	restoreLogging(t)
+	for _, args := range [][]string{{"run"}, {"run", "99", "true"}, {"stop", ":x"}, {"stop", ":-1"}, {"stop", ":+5"}, {"run", ":12.0", "true"}} {
+		var stdout, stderr strings.Builder
+		if code := dispatch(args, &stdout, &stderr); code != 2 {
+			t.Errorf("%q: expected exit code 2, got %d: %s", args, code, stderr.String())
+		}
+	}
+}
+
+func TestDispatchRunsOtherCommandsClassically(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+
+	var stdout, stderr strings.Builder
+	if code := dispatch([]string{"--reuse", "echo", "stop"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if got := stdout.String(); got != "stop\n" {
+		t.Errorf("unexpected output %q", got)
+	}
+}
+
+func TestStartRejectsACommand(t *testing.T) {
+	restoreLogging(t)
+
+	var stdout, stderr strings.Builder
+	if code := dispatch([]string{"start", "true"}, &stdout, &stderr); code != 2 {
+		t.Errorf("expected exit code 2, got %d", code)
+	}
+	if !strings.Contains(stderr.String(), "xvfb-run start doesn't take a command") {
+		t.Errorf("unexpected stderr %q", stderr.String())
+	}
+}
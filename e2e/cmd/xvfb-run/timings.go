@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// phaseTimer collects how long each phase of a run took, in the order they
+// happened, for --timings.
+type phaseTimer struct {
+	phases []phase
+}
+
+type phase struct {
+	name     string
+	duration time.Duration
+}
+
+// record adds a phase that took d.
+func (t *phaseTimer) record(name string, d time.Duration) {
+	t.phases = append(t.phases, phase{name, d})
+}
+
+// milliseconds returns the phases keyed by name, for --json.
+func (t *phaseTimer) milliseconds() map[string]int64 {
+	if len(t.phases) == 0 {
+		return nil
+	}
+	ms := make(map[string]int64, len(t.phases))
+	for _, p := range t.phases {
+		ms[p.name] = p.duration.Milliseconds()
+	}
+	return ms
+}
+
+// write prints one line per phase to w.
+func (t *phaseTimer) write(w io.Writer) {
+	if len(t.phases) == 0 {
+		return
+	}
+	fmt.Fprintln(w, decorate("⏱️ Timings:"))
+	for _, p := range t.phases {
+		fmt.Fprintf(w, "   %-18s %6d\n", p.name, p.duration.Milliseconds())
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPhaseTimer(t *testing.T) {
+	var timer phaseTimer
+	timer.record("xvfb_spawn_ms", 12*time.Millisecond)
+	timer.record("command_ms", 1500*time.Millisecond)
+
+	ms := timer.milliseconds()
+	if ms["xvfb_spawn_ms"] != 12 || ms["command_ms"] != 1500 {
+		t.Errorf("unexpected phases %v", ms)
+	}
+
+	var out strings.Builder
+	timer.write(&out)
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[1], "xvfb_spawn_ms") || !strings.HasSuffix(lines[1], " 12") || !strings.Contains(lines[2], "command_ms") {
+		t.Errorf("expected phases in the order recorded, got:\n%s", out.String())
+	}
+}
+
+func TestPhaseTimerEmpty(t *testing.T) {
+	var timer phaseTimer
+	if ms := timer.milliseconds(); ms != nil {
+		t.Errorf("expected nil, got %v", ms)
+	}
+	var out strings.Builder
+	timer.write(&out)
+	if out.Len() != 0 {
+		t.Errorf("expected no output, got %q", out.String())
+	}
+}
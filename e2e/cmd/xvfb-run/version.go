@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+)
+
+// Set at build time, e.g.
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%FT%TZ)"
+//
+// Whatever is left empty is filled in from the Go build info by buildInfo.
+var (
+	version   string
+	commit    string
+	buildDate string
+)
+
+// versionInfo is what --version reports about this build.
+type versionInfo struct {
+	Version   string
+	Commit    string
+	BuildDate string
+	Modified  bool
+	GoVersion string
+}
+
+// buildInfo returns the version, commit and build date set with -ldflags,
+// falling back to what the Go toolchain recorded in the binary: the module
+// version and the VCS revision and commit time.
+func buildInfo() versionInfo {
+	info := versionInfo{Version: version, Commit: commit, BuildDate: buildDate, GoVersion: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+			info.Version = bi.Main.Version
+		}
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = s.Value
+				}
+			case "vcs.time":
+				if info.BuildDate == "" {
+					info.BuildDate = s.Value
+				}
+			case "vcs.modified":
+				info.Modified = commit == "" && s.Value == "true"
+			}
+		}
+	}
+	if info.Version == "" {
+		info.Version = "dev"
+	}
+	return info
+}
+
+// writeVersion prints info for --version, one field per line.
+func writeVersion(w io.Writer, info versionInfo) {
+	fmt.Fprintln(w, "xvfb-run", info.Version)
+	commit := info.Commit
+	if commit == "" {
+		commit = "unknown"
+	} else if info.Modified {
+		commit += " (modified)"
+	}
+	date := info.BuildDate
+	if date == "" {
+		date = "unknown"
+	}
+	fmt.Fprintln(w, "commit:", commit)
+	fmt.Fprintln(w, "built:", date)
+	fmt.Fprintln(w, "go:", info.GoVersion)
+}
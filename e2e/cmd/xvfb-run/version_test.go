@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuildInfoUsesLdflags(t *testing.T) {
+	version, commit, buildDate = "v1.2.3", "abc123", "2024-05-01T10:00:00Z"
+	t.Cleanup(func() { version, commit, buildDate = "", "", "" })
+
+	info := buildInfo()
+	if info.Version != "v1.2.3" || info.Commit != "abc123" || info.BuildDate != "2024-05-01T10:00:00Z" || info.Modified {
+		t.Errorf("unexpected build info %+v", info)
+	}
+}
+
+func TestBuildInfoDefaultsVersion(t *testing.T) {
+	if got := buildInfo().Version; got == "" {
+		t.Error("expected a version even without -ldflags")
+	}
+}
+
+func TestWriteVersion(t *testing.T) {
+	var out strings.Builder
+	writeVersion(&out, versionInfo{Version: "v1.2.3", Commit: "abc123", Modified: true, GoVersion: "go1.21.0"})
+
+	want := "xvfb-run v1.2.3\ncommit: abc123 (modified)\nbuilt: unknown\ngo: go1.21.0\n"
+	if out.String() != want {
+		t.Errorf("expected %q, got %q", want, out.String())
+	}
+}
+
+func TestRunVersionSkipsEverythingElse(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("XVFB_RUN_ARGS", "")
+	t.Setenv("PATH", t.TempDir())
+	version = "v9.9.9"
+	t.Cleanup(func() { version = "" })
+
+	for _, args := range [][]string{{"--version"}, {"version"}, {"--version", "--backend", "bogus", "mytool"}} {
+		var stdout, stderr strings.Builder
+		if code := dispatch(args, &stdout, &stderr); code != 0 {
+			t.Fatalf("%q: expected exit code 0, got %d: %s", args, code, stderr.String())
+		}
+		if !strings.HasPrefix(stdout.String(), "xvfb-run v9.9.9\n") {
+			t.Errorf("%q: expected the version on stdout, got %q", args, stdout.String())
+		}
+		if stderr.Len() != 0 {
+			t.Errorf("%q: expected nothing on stderr, got %q", args, stderr.String())
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// wmSettleTime is how long --wm gives the window manager to take over the
+// display before the command starts.
+var wmSettleTime = 500 * time.Millisecond
+
+// checkWindowManager checks that the program command, for --wm, runs is in
+// PATH, so a missing one fails before Xvfb is started.
+func checkWindowManager(command string) error {
+	args := parseServerArgs(command)
+	if len(args) == 0 {
+		return errors.New("no window manager given")
+	}
+	_, err := exec.LookPath(args[0])
+	return err
+}
+
+// windowManager is a window manager started with --wm.
+type windowManager struct {
+	pid  int
+	done chan error
+}
+
+// startWindowManager starts command, e.g. "fluxbox", "openbox" or one
+// with arguments, with sh -c on session's display, like an --on-ready
+// hook, and gives it wmSettleTime to settle. A window manager that exits
+// in that time, e.g. because another one is running, is an error.
+func startWindowManager(session Session, command string, env []string, out io.Writer) (*windowManager, error) {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Env = hookEnv(session, env)
+	cmd.Stdout, cmd.Stderr = out, out
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.WaitDelay = hookGracePeriod
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	wm := &windowManager{pid: cmd.Process.Pid, done: make(chan error, 1)}
+	go func() { wm.done <- cmd.Wait() }()
+	select {
+	case err := <-wm.done:
+		syscall.Kill(-wm.pid, syscall.SIGKILL)
+		if err == nil {
+			err = errors.New("exited right away")
+		}
+		return nil, fmt.Errorf("%s: %w", command, err)
+	case <-time.After(wmSettleTime):
+	}
+	logEvent("wm_start", fmt.Sprintf("Started window manager (PID %d): %s", wm.pid, command), "command", command, "pid", wm.pid)
+	return wm, nil
+}
+
+// stop terminates the window manager's process group, like stopReadyHook.
+func (wm *windowManager) stop() {
+	stopGroup(wm.pid, wm.done)
+}
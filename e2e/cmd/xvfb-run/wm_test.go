@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeWindowManager puts a "fakewm" in PATH that records its PID and
+// display in dir and then stays up, and shortens wmSettleTime.
+func fakeWindowManager(t *testing.T) (dir string) {
+	t.Helper()
+	dir = t.TempDir()
+	script := "#!/bin/sh\necho \"$DISPLAY\" > " + filepath.Join(dir, "display") + "\necho $$ > " + filepath.Join(dir, "pid") + "\nexec sleep 30\n"
+	if err := os.WriteFile(filepath.Join(dir, "fakewm"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	old := wmSettleTime
+	t.Cleanup(func() { wmSettleTime = old })
+	wmSettleTime = 100 * time.Millisecond
+	return dir
+}
+
+func TestCheckWindowManager(t *testing.T) {
+	fakeWindowManager(t)
+	if err := checkWindowManager("fakewm --replace"); err != nil {
+		t.Errorf("expected fakewm to be found, got %v", err)
+	}
+	for _, command := range []string{"", "no-such-wm", "no-such-wm fakewm"} {
+		if err := checkWindowManager(command); err == nil {
+			t.Errorf("expected %q to be rejected", command)
+		}
+	}
+}
+
+func TestStartWindowManagerExitingIsAnError(t *testing.T) {
+	restoreLogging(t)
+	fakeWindowManager(t)
+	for _, command := range []string{"true", "exit 1"} {
+		if _, err := startWindowManager(Session{Display: ":7"}, command, os.Environ(), &strings.Builder{}); err == nil {
+			t.Errorf("expected %q exiting at once to be an error", command)
+		}
+	}
+}
+
+func TestRunWindowManager(t *testing.T) {
+	restoreLogging(t)
+	t.Setenv("DISPLAY", ":42")
+	dir := fakeWindowManager(t)
+
+	var stdout, stderr strings.Builder
+	if code := run([]string{"--reuse", "--wm", "fakewm", "sh", "-c", "cat " + filepath.Join(dir, "display")}, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if got := stdout.String(); got != ":42\n" {
+		t.Errorf("expected the window manager to be up on the display before the command, got %q", got)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "pid"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	// The window manager's shell may fork it, leaving it for init to reap
+	deadline := time.Now().Add(2 * time.Second)
+	for processAlive(pid) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the window manager (PID %d) to be stopped at cleanup", pid)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if code := run([]string{"--reuse", "--wm", "no-such-wm", "true"}, &stdout, &stderr); code != 2 {
+		t.Errorf("expected exit code 2 for a missing window manager, got %d", code)
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"io"
+	"os"
+)
+
+// newXvfbLogWriter returns where Xvfb's output should go: the file at path,
+// appended to like xvfb-run -e does, or nowhere when path is empty, since
+// Start's error brings the last lines along anyway. The returned func
+// flushes and closes the file.
+func newXvfbLogWriter(path string) (w io.Writer, closeLog func(), err error) {
+	if path == "" {
+		return nil, func() {}, nil
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, func() {
+		f.Sync()
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewXvfbLogWriterWithoutPath(t *testing.T) {
+	w, closeLog, err := newXvfbLogWriter("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer closeLog()
+
+	if w != nil {
+		t.Errorf("expected no writer, got %T", w)
+	}
+}
+
+func TestNewXvfbLogWriterAppendsToFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "xvfb.log")
+	if err := os.WriteFile(path, []byte("previous run\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	w, closeLog, err := newXvfbLogWriter(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	w.Write([]byte("this run\n"))
+	closeLog()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data); got != "previous run\nthis run\n" {
+		t.Errorf("unexpected file contents %q", got)
+	}
+}
+
+func TestNewXvfbLogWriterReportsUnwritablePath(t *testing.T) {
+	if _, _, err := newXvfbLogWriter(filepath.Join(t.TempDir(), "missing", "xvfb.log")); err == nil {
+		t.Fatal("expected an error for a path in a missing directory")
+	}
+}